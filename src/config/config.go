@@ -0,0 +1,860 @@
+// Package config holds the settings of the whole application. They are
+// resolved, from lowest to highest precedence, from the profile defaults,
+// the YAML file, the environment and the command line flags.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ProfileDev  = "dev"
+	ProfileTest = "test"
+	ProfileProd = "prod"
+)
+
+// Shortest JWT secret accepted in production, in bytes
+const minProdSecretLength = 32
+
+// bcrypt ignores everything after the 72nd byte of a password
+const bcryptMaxBytes = 72
+
+type AppConfig struct {
+	Profile  string         `yaml:"profile"`
+	HTTP     HTTPConfig     `yaml:"http"`
+	Database DatabaseConfig `yaml:"database"`
+	Mongo    MongoConfig    `yaml:"mongo"`
+	Auth     AuthConfig     `yaml:"auth"`
+	Password PasswordConfig `yaml:"password"`
+	OIDC     OIDCConfig     `yaml:"oidc"`
+	// Throttling of the failed logins, by account and by IP address
+	Throttle ThrottleConfig `yaml:"login_throttle"`
+	Metrics  MetricsConfig  `yaml:"metrics"`
+	Tracing  TracingConfig  `yaml:"tracing"`
+	Outbox   OutboxConfig   `yaml:"outbox"`
+}
+
+type HTTPConfig struct {
+	Port              int           `yaml:"port"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	// Deadline of the context of a request, its queries are cancelled once
+	// it passes. Zero disables it.
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	// How long /readyz fails before the listener is closed on shutdown, so
+	// the load balancer stops sending traffic first
+	DrainDelay time.Duration `yaml:"drain_delay"`
+	// How long in-flight requests get to finish once a shutdown starts
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// Origins browsers may call the API from, "*" allows any origin
+	CORSOrigins []string `yaml:"cors_origins"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+	// CA certificate the server certificate is checked against
+	SSLRootCert string `yaml:"sslrootcert"`
+	// Name of the connections in pg_stat_activity
+	ApplicationName string `yaml:"application_name"`
+	// Zero leaves the number of open connections unlimited
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	// Time to establish a connection, rounded up to the second
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// Statements running longer are cancelled by the server, zero disables it
+	StatementTimeout time.Duration `yaml:"statement_timeout"`
+	// Attempts made after a failed connection on startup, waiting
+	// RetryBackoff, then twice as long each time
+	ConnectRetries int           `yaml:"connect_retries"`
+	RetryBackoff   time.Duration `yaml:"retry_backoff"`
+	// Read replicas as host or host:port, sharing the credentials and the
+	// options of the primary. Reads go to them while they answer.
+	Replicas []string `yaml:"replicas"`
+	// How often the replicas are pinged, a failing one gets no reads
+	// until it answers again
+	ReplicaCheckInterval time.Duration `yaml:"replica_check_interval"`
+}
+
+// MongoConfig points to a MongoDB Atlas cluster. Mongo is not connected
+// when no cluster is set.
+type MongoConfig struct {
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Cluster  string `yaml:"cluster"`
+}
+
+type AuthConfig struct {
+	JWTSecret string `yaml:"jwt_secret"`
+	// Cookies are only sent over HTTPS, off on dev which is served over
+	// plain HTTP. Kept on behind a proxy terminating TLS.
+	SecureCookies bool `yaml:"secure_cookies"`
+}
+
+// PasswordConfig is the policy of the new passwords and how they are hashed
+type PasswordConfig struct {
+	MinLength int `yaml:"min_length"`
+	// bcrypt ignores everything after the 72nd byte
+	MaxBytes      int  `yaml:"max_bytes"`
+	RequireUpper  bool `yaml:"require_upper"`
+	RequireLower  bool `yaml:"require_lower"`
+	RequireDigit  bool `yaml:"require_digit"`
+	RequireSymbol bool `yaml:"require_symbol"`
+	// SHA-1 hashes of known breached passwords, one per line as in the
+	// Have I Been Pwned dumps. Empty disables the check.
+	BreachedFile string             `yaml:"breached_file"`
+	Hash         PasswordHashConfig `yaml:"hash"`
+}
+
+// Algorithms the passwords are hashed with
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// PasswordHashConfig picks the algorithm of the new hashes. Stored hashes
+// of another algorithm or weaker parameters are upgraded on login.
+type PasswordHashConfig struct {
+	// bcrypt or argon2id
+	Algorithm  string `yaml:"algorithm"`
+	BcryptCost int    `yaml:"bcrypt_cost"`
+	// In KiB
+	Argon2Memory     int `yaml:"argon2_memory"`
+	Argon2Iterations int `yaml:"argon2_iterations"`
+	Argon2Threads    int `yaml:"argon2_threads"`
+}
+
+// OIDCConfig is the OpenID Connect client registered at the issuer.
+// External login is disabled when no issuer is set.
+type OIDCConfig struct {
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// ThrottleConfig makes an account wait after a failed login, doubling the
+// wait from BaseDelay up to MaxDelay, and locks it for Lockout after
+// MaxFailures. An IP address only starts waiting after AddressFailures and
+// is never locked, since many users may share it. The failures are
+// forgotten after Window without any.
+type ThrottleConfig struct {
+	MaxFailures     int           `yaml:"max_failures"`
+	AddressFailures int           `yaml:"address_failures"`
+	BaseDelay       time.Duration `yaml:"base_delay"`
+	MaxDelay        time.Duration `yaml:"max_delay"`
+	Lockout         time.Duration `yaml:"lockout"`
+	Window          time.Duration `yaml:"window"`
+}
+
+type MetricsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Bearer token the scraper must send, required on prod
+	Token string `yaml:"token"`
+}
+
+// Exporters of the spans
+const (
+	TracingNone   = "none"
+	TracingStdout = "stdout"
+	TracingFile   = "file"
+	TracingOTLP   = "otlp"
+)
+
+type TracingConfig struct {
+	// One of none, stdout, file or otlp
+	Exporter    string `yaml:"exporter"`
+	ServiceName string `yaml:"service_name"`
+	// OTLP/HTTP collector, e.g. localhost:4318
+	Endpoint string `yaml:"endpoint"`
+	Insecure bool   `yaml:"insecure"`
+	// Where the file exporter appends the spans, one JSON object each
+	File string `yaml:"file"`
+	// Fraction of the traces started here that are kept, traces started
+	// by a caller follow its decision
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// Sinks the outbox events are published to
+const (
+	SinkLog     = "log"
+	SinkWebhook = "webhook"
+	SinkMongo   = "mongo"
+)
+
+// OutboxConfig controls the dispatcher publishing the events of the
+// outbox table
+type OutboxConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Any of log, webhook and mongo, every event is published to each
+	Sinks []string `yaml:"sinks"`
+	// Wait between two polls of the table when it had nothing to publish
+	Interval  time.Duration `yaml:"interval"`
+	BatchSize int           `yaml:"batch_size"`
+	// Deliveries tried before an event is marked failed, it can then be
+	// replayed from /api/v1/outbox
+	MaxAttempts int `yaml:"max_attempts"`
+	// Receives the events as JSON, signed with the secret when one is set
+	WebhookURL    string `yaml:"webhook_url"`
+	WebhookSecret string `yaml:"webhook_secret"`
+	// Where the mongo sink stores the events
+	MongoDatabase   string `yaml:"mongo_database"`
+	MongoCollection string `yaml:"mongo_collection"`
+}
+
+var DefaultHTTPConfig = HTTPConfig{
+	Port:              7676,
+	ReadTimeout:       15 * time.Second,
+	ReadHeaderTimeout: 5 * time.Second,
+	WriteTimeout:      30 * time.Second,
+	IdleTimeout:       60 * time.Second,
+	RequestTimeout:    25 * time.Second,
+	ShutdownTimeout:   20 * time.Second,
+	CORSOrigins:       []string{"*"},
+}
+
+// Defaults returns the settings of a profile before any file, environment
+// variable or flag is applied. Dev and test point to the db_dev database
+// of docker-compose; prod has no credentials so they must be provided.
+func Defaults(profile string) AppConfig {
+	config := AppConfig{
+		Profile: profile,
+		HTTP:    DefaultHTTPConfig,
+		Database: DatabaseConfig{
+			Host:             "localhost",
+			Port:             5432,
+			SSLMode:          "disable",
+			ApplicationName:  "minimarketplace",
+			MaxOpenConns:     25,
+			MaxIdleConns:     5,
+			ConnMaxLifetime:  30 * time.Minute,
+			ConnMaxIdleTime:  5 * time.Minute,
+			ConnectTimeout:   5 * time.Second,
+			StatementTimeout: 30 * time.Second,
+			ConnectRetries:   5,
+			RetryBackoff:     time.Second,
+
+			ReplicaCheckInterval: 5 * time.Second,
+		},
+		Auth: AuthConfig{SecureCookies: true},
+		Password: PasswordConfig{
+			MinLength:    8,
+			MaxBytes:     bcryptMaxBytes,
+			RequireUpper: true,
+			RequireLower: true,
+			RequireDigit: true,
+			Hash: PasswordHashConfig{
+				Algorithm:        HashBcrypt,
+				BcryptCost:       12,
+				Argon2Memory:     64 * 1024,
+				Argon2Iterations: 3,
+				Argon2Threads:    2,
+			},
+		},
+		OIDC: OIDCConfig{
+			Scopes: []string{"openid", "email", "profile"},
+		},
+		Throttle: ThrottleConfig{
+			MaxFailures:     5,
+			AddressFailures: 50,
+			BaseDelay:       time.Second,
+			MaxDelay:        30 * time.Second,
+			Lockout:         15 * time.Minute,
+			Window:          time.Hour,
+		},
+		Metrics: MetricsConfig{Enabled: true},
+		Tracing: TracingConfig{
+			Exporter:    TracingNone,
+			ServiceName: "minimarketplace",
+			Endpoint:    "localhost:4318",
+			File:        "traces.json",
+			SampleRatio: 1,
+		},
+		Outbox: OutboxConfig{
+			Enabled:         true,
+			Sinks:           []string{SinkLog},
+			Interval:        time.Second,
+			BatchSize:       100,
+			MaxAttempts:     10,
+			MongoDatabase:   "minimarketplace",
+			MongoCollection: "events",
+		},
+	}
+
+	switch profile {
+	case ProfileDev:
+		config.Database.Port = 5433
+		config.Database.User = "user"
+		config.Database.Password = "password"
+		config.Database.Name = "marketplace_db_dev"
+		config.Auth.JWTSecret = "insecure-dev-secret"
+		config.Auth.SecureCookies = false
+		config.Tracing.Insecure = true
+	case ProfileTest:
+		config.HTTP.ShutdownTimeout = time.Second
+		config.Database.Port = 5433
+		config.Database.User = "user"
+		config.Database.Password = "password"
+		config.Database.Name = "marketplace_db_dev"
+		config.Auth.JWTSecret = "insecure-test-secret"
+		config.Tracing.Insecure = true
+	case ProfileProd:
+		config.HTTP.DrainDelay = 5 * time.Second
+		config.Database.Host = ""
+		config.Database.SSLMode = "require"
+	}
+
+	return config
+}
+
+// setting binds an environment variable and a flag to a field. Secrets
+// have no flag since the command line is visible to other processes.
+type setting struct {
+	env    string
+	flag   string
+	usage  string
+	target any
+}
+
+func (c *AppConfig) settings() []setting {
+	return []setting{
+		{"HTTP_PORT", "port", "port the HTTP server listens on", &c.HTTP.Port},
+		{"HTTP_READ_TIMEOUT", "http-read-timeout", "maximum duration for reading a request", &c.HTTP.ReadTimeout},
+		{"HTTP_READ_HEADER_TIMEOUT", "http-read-header-timeout", "maximum duration for reading request headers", &c.HTTP.ReadHeaderTimeout},
+		{"HTTP_WRITE_TIMEOUT", "http-write-timeout", "maximum duration for writing a response", &c.HTTP.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", "http-idle-timeout", "maximum duration of an idle keep-alive connection", &c.HTTP.IdleTimeout},
+		{"HTTP_REQUEST_TIMEOUT", "http-request-timeout", "deadline of a request and its queries, 0 to disable", &c.HTTP.RequestTimeout},
+		{"HTTP_DRAIN_DELAY", "http-drain-delay", "time readiness fails before the server stops listening", &c.HTTP.DrainDelay},
+		{"HTTP_SHUTDOWN_TIMEOUT", "http-shutdown-timeout", "time given to in-flight requests on shutdown", &c.HTTP.ShutdownTimeout},
+		{"HTTP_CORS_ORIGINS", "http-cors-origins", "origins allowed by CORS, * for any", &c.HTTP.CORSOrigins},
+		{"DB_HOST", "db-host", "PostgreSQL host", &c.Database.Host},
+		{"DB_PORT", "db-port", "PostgreSQL port", &c.Database.Port},
+		{"DB_USER", "db-user", "PostgreSQL user", &c.Database.User},
+		{"DB_PASSWORD", "", "", &c.Database.Password},
+		{"DB_NAME", "db-name", "PostgreSQL database name", &c.Database.Name},
+		{"DB_SSLMODE", "db-sslmode", "PostgreSQL sslmode", &c.Database.SSLMode},
+		{"DB_SSLROOTCERT", "db-sslrootcert", "CA certificate of the PostgreSQL server", &c.Database.SSLRootCert},
+		{"DB_APPLICATION_NAME", "db-application-name", "application_name of the PostgreSQL connections", &c.Database.ApplicationName},
+		{"DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open PostgreSQL connections, 0 for unlimited", &c.Database.MaxOpenConns},
+		{"DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle PostgreSQL connections kept in the pool", &c.Database.MaxIdleConns},
+		{"DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum lifetime of a PostgreSQL connection, 0 for unlimited", &c.Database.ConnMaxLifetime},
+		{"DB_CONN_MAX_IDLE_TIME", "db-conn-max-idle-time", "maximum idle time of a PostgreSQL connection, 0 for unlimited", &c.Database.ConnMaxIdleTime},
+		{"DB_CONNECT_TIMEOUT", "db-connect-timeout", "time to establish a PostgreSQL connection", &c.Database.ConnectTimeout},
+		{"DB_STATEMENT_TIMEOUT", "db-statement-timeout", "maximum duration of a statement, 0 to disable", &c.Database.StatementTimeout},
+		{"DB_CONNECT_RETRIES", "db-connect-retries", "connection attempts retried on startup", &c.Database.ConnectRetries},
+		{"DB_RETRY_BACKOFF", "db-retry-backoff", "wait before the first retry, doubled after each one", &c.Database.RetryBackoff},
+		{"DB_REPLICAS", "db-replicas", "comma separated read replicas, host or host:port", &c.Database.Replicas},
+		{"DB_REPLICA_CHECK_INTERVAL", "db-replica-check-interval", "how often the read replicas are pinged", &c.Database.ReplicaCheckInterval},
+		{"MONGO_USER", "mongo-user", "MongoDB user", &c.Mongo.User},
+		{"MONGO_PASSWORD", "", "", &c.Mongo.Password},
+		{"MONGO_CLUSTER", "mongo-cluster", "MongoDB Atlas cluster, empty to disable Mongo", &c.Mongo.Cluster},
+		{"JWT_SECRET", "", "", &c.Auth.JWTSecret},
+		{"AUTH_SECURE_COOKIES", "auth-secure-cookies", "send the cookies over HTTPS only", &c.Auth.SecureCookies},
+		{"PASSWORD_MIN_LENGTH", "password-min-length", "fewest characters of a new password", &c.Password.MinLength},
+		{"PASSWORD_MAX_BYTES", "password-max-bytes", "most bytes of a new password, at most 72", &c.Password.MaxBytes},
+		{"PASSWORD_REQUIRE_UPPER", "password-require-upper", "new passwords need an uppercase letter", &c.Password.RequireUpper},
+		{"PASSWORD_REQUIRE_LOWER", "password-require-lower", "new passwords need a lowercase letter", &c.Password.RequireLower},
+		{"PASSWORD_REQUIRE_DIGIT", "password-require-digit", "new passwords need a digit", &c.Password.RequireDigit},
+		{"PASSWORD_REQUIRE_SYMBOL", "password-require-symbol", "new passwords need a symbol", &c.Password.RequireSymbol},
+		{"PASSWORD_BREACHED_FILE", "password-breached-file", "file of SHA-1 hashes of breached passwords", &c.Password.BreachedFile},
+		{"PASSWORD_HASH_ALGORITHM", "password-hash-algorithm", "hash of new passwords: bcrypt or argon2id", &c.Password.Hash.Algorithm},
+		{"PASSWORD_HASH_BCRYPT_COST", "password-hash-bcrypt-cost", "bcrypt cost", &c.Password.Hash.BcryptCost},
+		{"PASSWORD_HASH_ARGON2_MEMORY", "password-hash-argon2-memory", "argon2id memory in KiB", &c.Password.Hash.Argon2Memory},
+		{"PASSWORD_HASH_ARGON2_ITERATIONS", "password-hash-argon2-iterations", "argon2id iterations", &c.Password.Hash.Argon2Iterations},
+		{"PASSWORD_HASH_ARGON2_THREADS", "password-hash-argon2-threads", "argon2id threads", &c.Password.Hash.Argon2Threads},
+		{"OIDC_ISSUER", "oidc-issuer", "OpenID Connect issuer URL, empty to disable external login", &c.OIDC.Issuer},
+		{"OIDC_CLIENT_ID", "oidc-client-id", "OpenID Connect client id", &c.OIDC.ClientID},
+		{"OIDC_CLIENT_SECRET", "", "", &c.OIDC.ClientSecret},
+		{"OIDC_REDIRECT_URL", "oidc-redirect-url", "URL the issuer redirects to after a login", &c.OIDC.RedirectURL},
+		{"OIDC_SCOPES", "oidc-scopes", "comma or space separated scopes asked to the issuer", &c.OIDC.Scopes},
+		{"LOGIN_THROTTLE_MAX_FAILURES", "login-throttle-max-failures", "failed logins before a lockout", &c.Throttle.MaxFailures},
+		{"LOGIN_THROTTLE_ADDRESS_FAILURES", "login-throttle-address-failures", "failed logins from an IP address before it waits", &c.Throttle.AddressFailures},
+		{"LOGIN_THROTTLE_BASE_DELAY", "login-throttle-base-delay", "wait after a failed login, doubled after each one", &c.Throttle.BaseDelay},
+		{"LOGIN_THROTTLE_MAX_DELAY", "login-throttle-max-delay", "longest wait after a failed login", &c.Throttle.MaxDelay},
+		{"LOGIN_THROTTLE_LOCKOUT", "login-throttle-lockout", "wait after max failures", &c.Throttle.Lockout},
+		{"LOGIN_THROTTLE_WINDOW", "login-throttle-window", "time after which the failures are forgotten", &c.Throttle.Window},
+		{"METRICS_ENABLED", "metrics-enabled", "serve Prometheus metrics on /metrics", &c.Metrics.Enabled},
+		{"METRICS_TOKEN", "", "", &c.Metrics.Token},
+		{"TRACING_EXPORTER", "tracing-exporter", "span exporter: none, stdout, file or otlp", &c.Tracing.Exporter},
+		{"OTEL_SERVICE_NAME", "tracing-service-name", "service name attached to the spans", &c.Tracing.ServiceName},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", "tracing-endpoint", "OTLP/HTTP collector host:port", &c.Tracing.Endpoint},
+		{"TRACING_INSECURE", "tracing-insecure", "send spans to the collector over plain HTTP", &c.Tracing.Insecure},
+		{"TRACING_FILE", "tracing-file", "file the file exporter appends spans to", &c.Tracing.File},
+		{"TRACING_SAMPLE_RATIO", "tracing-sample-ratio", "fraction of new traces kept, between 0 and 1", &c.Tracing.SampleRatio},
+		{"OUTBOX_ENABLED", "outbox-enabled", "publish the events of the outbox", &c.Outbox.Enabled},
+		{"OUTBOX_SINKS", "outbox-sinks", "comma separated sinks of the events: log, webhook, mongo", &c.Outbox.Sinks},
+		{"OUTBOX_INTERVAL", "outbox-interval", "wait between two polls of the outbox", &c.Outbox.Interval},
+		{"OUTBOX_BATCH_SIZE", "outbox-batch-size", "events published per poll", &c.Outbox.BatchSize},
+		{"OUTBOX_MAX_ATTEMPTS", "outbox-max-attempts", "deliveries tried before an event fails", &c.Outbox.MaxAttempts},
+		{"OUTBOX_WEBHOOK_URL", "outbox-webhook-url", "URL the webhook sink posts the events to", &c.Outbox.WebhookURL},
+		{"OUTBOX_WEBHOOK_SECRET", "", "", &c.Outbox.WebhookSecret},
+		{"OUTBOX_MONGO_DATABASE", "outbox-mongo-database", "MongoDB database of the mongo sink", &c.Outbox.MongoDatabase},
+		{"OUTBOX_MONGO_COLLECTION", "outbox-mongo-collection", "MongoDB collection of the mongo sink", &c.Outbox.MongoCollection},
+	}
+}
+
+func (s setting) set(value string) error {
+	switch target := s.target.(type) {
+	case *string:
+		*target = value
+	case *bool:
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+	case *int:
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+	case *float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+	case *time.Duration:
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		*target = parsed
+	case *[]string:
+		*target = strings.FieldsFunc(value, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+	default:
+		return fmt.Errorf("unsupported setting type %T", target)
+	}
+
+	return nil
+}
+
+// Load resolves the configuration from the command line arguments, without
+// the program name, and the environment. The file is read from -config or
+// CONFIG_FILE and the profile from -profile, APP_PROFILE or the file,
+// defaulting to dev.
+func Load(args []string) (*AppConfig, error) {
+	config, _, err := LoadArgs(args)
+	return config, err
+}
+
+// LoadArgs is Load for the subcommands, it also returns the arguments left
+// after the flags, e.g. "up" in "-profile prod up"
+func LoadArgs(args []string) (*AppConfig, []string, error) {
+	// Flags are parsed first to find the file and the profile, but they are
+	// applied last so they override everything else
+	settings := (&AppConfig{}).settings()
+
+	flags := flag.NewFlagSet("minimarketplace", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML configuration file")
+	profile := flags.String("profile", os.Getenv("APP_PROFILE"), "configuration profile: dev, test or prod")
+	raw := make(map[string]*string)
+	for _, s := range settings {
+		if s.flag != "" {
+			raw[s.flag] = flags.String(s.flag, "", s.usage)
+		}
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	var file []byte
+	if *configFile != "" {
+		var err error
+		file, err = os.ReadFile(*configFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read configuration file: %w", err)
+		}
+	}
+
+	if *profile == "" && file != nil {
+		var peek struct {
+			Profile string `yaml:"profile"`
+		}
+		if err := yaml.Unmarshal(file, &peek); err != nil {
+			return nil, nil, fmt.Errorf("invalid configuration file: %w", err)
+		}
+		*profile = peek.Profile
+	}
+	if *profile == "" {
+		*profile = ProfileDev
+	}
+	if !validProfile(*profile) {
+		return nil, nil, fmt.Errorf("unknown profile %q", *profile)
+	}
+
+	config := Defaults(*profile)
+
+	if file != nil {
+		if err := decodeFile(file, &config); err != nil {
+			return nil, nil, err
+		}
+		// The file can't override a profile picked by a flag or variable
+		config.Profile = *profile
+	}
+
+	settings = config.settings()
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("invalid %s: %w", s.env, err)
+			}
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && flagErr == nil {
+				if err := s.set(*raw[s.flag]); err != nil {
+					flagErr = fmt.Errorf("invalid -%s: %w", s.flag, err)
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, nil, flagErr
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+
+	return &config, flags.Args(), nil
+}
+
+func decodeFile(file []byte, config *AppConfig) error {
+	decoder := yaml.NewDecoder(bytes.NewReader(file))
+	// Catch typos instead of silently ignoring them
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("invalid configuration file: %w", err)
+	}
+
+	return nil
+}
+
+func validProfile(profile string) bool {
+	return profile == ProfileDev || profile == ProfileTest || profile == ProfileProd
+}
+
+// Validate reports every invalid setting at once
+func (c *AppConfig) Validate() error {
+	var errs []error
+
+	if !validProfile(c.Profile) {
+		errs = append(errs, fmt.Errorf("unknown profile %q", c.Profile))
+	}
+
+	if c.HTTP.Port < 1 || c.HTTP.Port > 65535 {
+		errs = append(errs, fmt.Errorf("http port must be between 1 and 65535"))
+	}
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"read_timeout", c.HTTP.ReadTimeout},
+		{"read_header_timeout", c.HTTP.ReadHeaderTimeout},
+		{"write_timeout", c.HTTP.WriteTimeout},
+		{"idle_timeout", c.HTTP.IdleTimeout},
+		{"request_timeout", c.HTTP.RequestTimeout},
+		{"drain_delay", c.HTTP.DrainDelay},
+		{"shutdown_timeout", c.HTTP.ShutdownTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			errs = append(errs, fmt.Errorf("http %s must not be negative", timeout.name))
+		}
+	}
+
+	if c.Database.Host == "" {
+		errs = append(errs, fmt.Errorf("database host is required"))
+	}
+	if c.Database.Port < 1 || c.Database.Port > 65535 {
+		errs = append(errs, fmt.Errorf("database port must be between 1 and 65535"))
+	}
+	if c.Database.User == "" {
+		errs = append(errs, fmt.Errorf("database user is required"))
+	}
+	if c.Database.Name == "" {
+		errs = append(errs, fmt.Errorf("database name is required"))
+	}
+	switch c.Database.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("unknown database sslmode %q", c.Database.SSLMode))
+	}
+	if c.Database.MaxOpenConns < 0 || c.Database.MaxIdleConns < 0 || c.Database.ConnectRetries < 0 {
+		errs = append(errs, fmt.Errorf("database max_open_conns, max_idle_conns and connect_retries must not be negative"))
+	}
+	if c.Database.MaxOpenConns > 0 && c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("database max_idle_conns must not exceed max_open_conns"))
+	}
+	durations := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"conn_max_lifetime", c.Database.ConnMaxLifetime},
+		{"conn_max_idle_time", c.Database.ConnMaxIdleTime},
+		{"connect_timeout", c.Database.ConnectTimeout},
+		{"statement_timeout", c.Database.StatementTimeout},
+		{"retry_backoff", c.Database.RetryBackoff},
+	}
+	for _, duration := range durations {
+		if duration.value < 0 {
+			errs = append(errs, fmt.Errorf("database %s must not be negative", duration.name))
+		}
+	}
+	for _, replica := range c.Database.Replicas {
+		if _, err := c.Database.Replica(replica); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(c.Database.Replicas) > 0 && c.Database.ReplicaCheckInterval <= 0 {
+		errs = append(errs, fmt.Errorf("database replica_check_interval must be positive"))
+	}
+
+	if c.Mongo.Enabled() && c.Mongo.User == "" {
+		errs = append(errs, fmt.Errorf("mongo user is required when a cluster is set"))
+	}
+
+	if c.Auth.JWTSecret == "" {
+		errs = append(errs, fmt.Errorf("jwt secret is required"))
+	} else if c.Profile == ProfileProd && len(c.Auth.JWTSecret) < minProdSecretLength {
+		errs = append(errs, fmt.Errorf("jwt secret must have at least %d bytes in prod", minProdSecretLength))
+	}
+
+	errs = append(errs, c.validatePassword()...)
+	errs = append(errs, c.validateOIDC()...)
+
+	throttle := c.Throttle
+	if throttle.MaxFailures < 1 {
+		errs = append(errs, fmt.Errorf("login_throttle max_failures must be at least 1"))
+	}
+	if throttle.AddressFailures < 0 {
+		errs = append(errs, fmt.Errorf("login_throttle address_failures must not be negative"))
+	}
+	if throttle.BaseDelay <= 0 || throttle.MaxDelay <= 0 || throttle.Lockout <= 0 || throttle.Window <= 0 {
+		errs = append(errs, fmt.Errorf("login_throttle base_delay, max_delay, lockout and window must be positive"))
+	}
+	if throttle.BaseDelay > throttle.MaxDelay {
+		errs = append(errs, fmt.Errorf("login_throttle base_delay must not exceed max_delay"))
+	}
+
+	if c.Profile == ProfileProd && c.Metrics.Enabled && c.Metrics.Token == "" {
+		errs = append(errs, fmt.Errorf("metrics token is required in prod"))
+	}
+
+	switch c.Tracing.Exporter {
+	case TracingNone, TracingStdout:
+	case TracingFile:
+		if c.Tracing.File == "" {
+			errs = append(errs, fmt.Errorf("tracing file is required by the file exporter"))
+		}
+	case TracingOTLP:
+		if c.Tracing.Endpoint == "" {
+			errs = append(errs, fmt.Errorf("tracing endpoint is required by the otlp exporter"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown tracing exporter %q", c.Tracing.Exporter))
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Errorf("tracing sample ratio must be between 0 and 1"))
+	}
+
+	if c.Outbox.Enabled {
+		errs = append(errs, c.validateOutbox()...)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+
+	return nil
+}
+
+func (c *AppConfig) validatePassword() []error {
+	var errs []error
+
+	password := c.Password
+	if password.MaxBytes < 1 || password.MaxBytes > bcryptMaxBytes {
+		errs = append(errs, fmt.Errorf("password max_bytes must be between 1 and %d", bcryptMaxBytes))
+	}
+	if password.MinLength < 1 || password.MinLength > password.MaxBytes {
+		errs = append(errs, fmt.Errorf("password min_length must be between 1 and max_bytes"))
+	}
+
+	hash := password.Hash
+	switch hash.Algorithm {
+	case HashBcrypt:
+	case HashArgon2id:
+	default:
+		errs = append(errs, fmt.Errorf("unknown password hash algorithm %q", hash.Algorithm))
+	}
+	// Both are checked, the hashes of the other algorithm are still verified
+	if hash.BcryptCost < bcrypt.MinCost || hash.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("password hash bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if hash.Argon2Memory < 1 || hash.Argon2Memory > math.MaxUint32 || hash.Argon2Iterations < 1 || hash.Argon2Iterations > math.MaxUint32 {
+		errs = append(errs, fmt.Errorf("password hash argon2_memory and argon2_iterations must be positive 32 bit integers"))
+	}
+	if hash.Argon2Threads < 1 || hash.Argon2Threads > math.MaxUint8 {
+		errs = append(errs, fmt.Errorf("password hash argon2_threads must be between 1 and %d", math.MaxUint8))
+	}
+
+	return errs
+}
+
+func (c *AppConfig) validateOIDC() []error {
+	if !c.OIDC.Enabled() {
+		return nil
+	}
+
+	var errs []error
+	issuer, err := url.Parse(c.OIDC.Issuer)
+	if err != nil || (issuer.Scheme != "http" && issuer.Scheme != "https") || issuer.Host == "" {
+		errs = append(errs, fmt.Errorf("oidc issuer must be an http or https URL"))
+	}
+	if c.OIDC.ClientID == "" || c.OIDC.RedirectURL == "" {
+		errs = append(errs, fmt.Errorf("oidc client_id and redirect_url are required when an issuer is set"))
+	}
+	if !slices.Contains(c.OIDC.Scopes, "openid") {
+		errs = append(errs, fmt.Errorf("oidc scopes must include openid"))
+	}
+
+	return errs
+}
+
+func (c *AppConfig) validateOutbox() []error {
+	var errs []error
+
+	if c.Outbox.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("outbox interval must be positive"))
+	}
+	if c.Outbox.BatchSize < 1 || c.Outbox.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("outbox batch_size and max_attempts must be at least 1"))
+	}
+	if len(c.Outbox.Sinks) == 0 {
+		errs = append(errs, fmt.Errorf("outbox needs at least one sink"))
+	}
+	for _, sink := range c.Outbox.Sinks {
+		switch sink {
+		case SinkLog:
+		case SinkWebhook:
+			target, err := url.Parse(c.Outbox.WebhookURL)
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+				errs = append(errs, fmt.Errorf("outbox webhook_url must be an http or https URL"))
+			}
+		case SinkMongo:
+			if !c.Mongo.Enabled() {
+				errs = append(errs, fmt.Errorf("outbox mongo sink requires a mongo cluster"))
+			}
+			if c.Outbox.MongoDatabase == "" || c.Outbox.MongoCollection == "" {
+				errs = append(errs, fmt.Errorf("outbox mongo_database and mongo_collection are required by the mongo sink"))
+			}
+		default:
+			errs = append(errs, fmt.Errorf("unknown outbox sink %q", sink))
+		}
+	}
+
+	return errs
+}
+
+// Addr is the address the HTTP server listens on
+func (h HTTPConfig) Addr() string {
+	return ":" + strconv.Itoa(h.Port)
+}
+
+// DSN is the PostgreSQL connection URL. It holds the password, never log it.
+// The statement timeout is sent as a run-time parameter of the session.
+func (d DatabaseConfig) DSN() string {
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(d.User, d.Password),
+		Host:   net.JoinHostPort(d.Host, strconv.Itoa(d.Port)),
+		Path:   "/" + d.Name,
+	}
+
+	query := url.Values{}
+	query.Set("sslmode", d.SSLMode)
+	query.Set("TimeZone", "UTC")
+	if d.SSLRootCert != "" {
+		query.Set("sslrootcert", d.SSLRootCert)
+	}
+	if d.ApplicationName != "" {
+		query.Set("application_name", d.ApplicationName)
+	}
+	if d.ConnectTimeout > 0 {
+		// Whole seconds, a shorter timeout would mean no timeout at all
+		seconds := (d.ConnectTimeout + time.Second - 1) / time.Second
+		query.Set("connect_timeout", strconv.FormatInt(int64(seconds), 10))
+	}
+	if d.StatementTimeout > 0 {
+		query.Set("statement_timeout", strconv.FormatInt(d.StatementTimeout.Milliseconds(), 10))
+	}
+	dsn.RawQuery = query.Encode()
+
+	return dsn.String()
+}
+
+// Replica is the configuration of the read replica at addr, host or
+// host:port, the port of the primary being used when there is none
+func (d DatabaseConfig) Replica(addr string) (DatabaseConfig, error) {
+	replica := d
+	replica.Host = addr
+	replica.Replicas = nil
+
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		replica.Host = host
+		replica.Port, err = strconv.Atoi(port)
+		if err != nil || replica.Port < 1 || replica.Port > 65535 {
+			return replica, fmt.Errorf("database replica %q has an invalid port", addr)
+		}
+	}
+	if replica.Host == "" {
+		return replica, fmt.Errorf("database replica %q has no host", addr)
+	}
+
+	return replica, nil
+}
+
+func (o OIDCConfig) Enabled() bool {
+	return o.Issuer != ""
+}
+
+func (m MongoConfig) Enabled() bool {
+	return m.Cluster != ""
+}
+
+// URI is the MongoDB connection string. It holds the password, never log it.
+func (m MongoConfig) URI() string {
+	return fmt.Sprintf("mongodb+srv://%s@%s", url.UserPassword(m.User, m.Password).String(), m.Cluster)
+}
@@ -0,0 +1,388 @@
+package config
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Clears the variables Load reads so the tests don't depend on the machine
+func clearEnv(t *testing.T) {
+	t.Helper()
+
+	names := []string{"CONFIG_FILE", "APP_PROFILE"}
+	for _, s := range (&AppConfig{}).settings() {
+		names = append(names, s.env)
+	}
+
+	for _, name := range names {
+		if value, ok := os.LookupEnv(name); ok {
+			os.Unsetenv(name)
+			t.Cleanup(func() { os.Setenv(name, value) })
+		}
+	}
+}
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.Profile != ProfileDev {
+		t.Errorf("Expected profile %q, got %q", ProfileDev, cfg.Profile)
+	}
+
+	if cfg.HTTP.Addr() != ":7676" {
+		t.Errorf("Expected address :7676, got %q", cfg.HTTP.Addr())
+	}
+
+	// Dev is served over plain HTTP, the others may be behind a proxy
+	if cfg.Auth.SecureCookies || !Defaults(ProfileTest).Auth.SecureCookies || !Defaults(ProfileProd).Auth.SecureCookies {
+		t.Error("Expected secure cookies everywhere but on dev")
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	clearEnv(t)
+
+	path := writeFile(t, `
+http:
+  port: 8000
+  write_timeout: 45s
+database:
+  host: file-host
+  port: 6000
+  name: file-db
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("DB_PORT", "6001")
+	t.Setenv("DB_HOST", "env-host")
+
+	cfg, err := Load([]string{"-db-host", "flag-host"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Flag over environment over file over profile defaults
+	if cfg.Database.Host != "flag-host" {
+		t.Errorf("Expected host from the flag, got %q", cfg.Database.Host)
+	}
+	if cfg.Database.Port != 6001 {
+		t.Errorf("Expected port from the environment, got %d", cfg.Database.Port)
+	}
+	if cfg.Database.Name != "file-db" || cfg.HTTP.Port != 8000 || cfg.HTTP.WriteTimeout != 45*time.Second {
+		t.Errorf("Expected values from the file, got %+v", cfg)
+	}
+	if cfg.Database.User != "user" || cfg.HTTP.IdleTimeout != DefaultHTTPConfig.IdleTimeout {
+		t.Errorf("Expected the defaults for unset values, got %+v", cfg)
+	}
+}
+
+func TestLoadProfileFromFile(t *testing.T) {
+	clearEnv(t)
+
+	path := writeFile(t, "profile: test\n")
+
+	cfg, err := Load([]string{"-config", path})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Profile != ProfileTest || cfg.Auth.JWTSecret != Defaults(ProfileTest).Auth.JWTSecret {
+		t.Errorf("Expected the test profile, got %+v", cfg)
+	}
+
+	// The flag wins over the file
+	cfg, err = Load([]string{"-config", path, "-profile", ProfileDev})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Profile != ProfileDev {
+		t.Errorf("Expected profile %q, got %q", ProfileDev, cfg.Profile)
+	}
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	clearEnv(t)
+
+	path := writeFile(t, "database:\n  hots: typo\n")
+
+	if _, err := Load([]string{"-config", path}); err == nil {
+		t.Fatal("Expected an error for an unknown key")
+	}
+}
+
+func TestLoadInvalidValues(t *testing.T) {
+	clearEnv(t)
+
+	t.Setenv("DB_PORT", "not-a-port")
+	_, err := Load(nil)
+	if err == nil || !strings.Contains(err.Error(), "DB_PORT") {
+		t.Errorf("Expected an error naming DB_PORT, got %v", err)
+	}
+
+	if _, err := Load([]string{"-profile", "staging"}); err == nil {
+		t.Error("Expected an error for an unknown profile")
+	}
+}
+
+func TestProdRequiresSecrets(t *testing.T) {
+	clearEnv(t)
+
+	t.Setenv("DB_HOST", "db")
+	t.Setenv("DB_USER", "user")
+	t.Setenv("DB_NAME", "marketplace_db")
+
+	_, err := Load([]string{"-profile", ProfileProd})
+	if err == nil || !strings.Contains(err.Error(), "jwt secret") {
+		t.Fatalf("Expected a missing jwt secret error, got %v", err)
+	}
+
+	t.Setenv("JWT_SECRET", "short")
+	if _, err := Load([]string{"-profile", ProfileProd}); err == nil {
+		t.Fatal("Expected a short jwt secret to be rejected in prod")
+	}
+
+	t.Setenv("JWT_SECRET", strings.Repeat("s", minProdSecretLength))
+	_, err = Load([]string{"-profile", ProfileProd})
+	if err == nil || !strings.Contains(err.Error(), "metrics token") {
+		t.Fatalf("Expected a missing metrics token error, got %v", err)
+	}
+
+	t.Setenv("METRICS_TOKEN", "scraper-token")
+	if _, err := Load([]string{"-profile", ProfileProd}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// Without metrics there is nothing to protect
+	t.Setenv("METRICS_TOKEN", "")
+	if _, err := Load([]string{"-profile", ProfileProd, "-metrics-enabled=false"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestValidateReportsEverything(t *testing.T) {
+	cfg := Defaults(ProfileDev)
+	cfg.HTTP.Port = 0
+	cfg.Database.SSLMode = "sometimes"
+	cfg.Auth.JWTSecret = ""
+	cfg.Tracing.Exporter = "zipkin"
+	cfg.Tracing.SampleRatio = 2
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	for _, want := range []string{"http port", "sslmode", "jwt secret", "tracing exporter", "sample ratio"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got %v", want, err)
+		}
+	}
+}
+
+func TestDSN(t *testing.T) {
+	database := DatabaseConfig{
+		Host:     "db",
+		Port:     5432,
+		User:     "user",
+		Password: "p@ss word",
+		Name:     "marketplace_db",
+		SSLMode:  "disable",
+	}
+
+	want := "postgres://user:p%40ss%20word@db:5432/marketplace_db?TimeZone=UTC&sslmode=disable"
+	if dsn := database.DSN(); dsn != want {
+		t.Errorf("Expected %q, got %q", want, dsn)
+	}
+}
+
+func TestDSNOptions(t *testing.T) {
+	database := Defaults(ProfileDev).Database
+	database.SSLMode = "verify-full"
+	database.SSLRootCert = "/etc/ssl/db-ca.pem"
+	database.ConnectTimeout = 1500 * time.Millisecond
+
+	dsn, err := url.Parse(database.DSN())
+	if err != nil {
+		t.Fatalf("Expected a valid URL, got %v", err)
+	}
+
+	query := dsn.Query()
+	want := map[string]string{
+		"sslmode":           "verify-full",
+		"sslrootcert":       "/etc/ssl/db-ca.pem",
+		"application_name":  "minimarketplace",
+		"connect_timeout":   "2",
+		"statement_timeout": "30000",
+	}
+	for key, value := range want {
+		if got := query.Get(key); got != value {
+			t.Errorf("Expected %s=%q, got %q", key, value, got)
+		}
+	}
+
+	database.StatementTimeout = 0
+	if dsn := database.DSN(); strings.Contains(dsn, "statement_timeout") {
+		t.Errorf("Expected no statement timeout when disabled, got %q", dsn)
+	}
+}
+
+func TestValidatePool(t *testing.T) {
+	cfg := Defaults(ProfileDev)
+	cfg.Database.MaxOpenConns = 2
+	cfg.Database.MaxIdleConns = 3
+	cfg.Database.RetryBackoff = -time.Second
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	for _, want := range []string{"max_idle_conns must not exceed", "retry_backoff"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got %v", want, err)
+		}
+	}
+}
+
+func TestLoadOutboxSinks(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("OUTBOX_SINKS", "log, webhook")
+	t.Setenv("OUTBOX_WEBHOOK_URL", "https://hooks.example.com/events")
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(cfg.Outbox.Sinks) != 2 || cfg.Outbox.Sinks[1] != SinkWebhook {
+		t.Errorf("Expected the log and webhook sinks, got %q", cfg.Outbox.Sinks)
+	}
+}
+
+func TestLoadCORSOrigins(t *testing.T) {
+	clearEnv(t)
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.HTTP.CORSOrigins) != 1 || cfg.HTTP.CORSOrigins[0] != "*" {
+		t.Errorf("Expected any origin by default, got %q", cfg.HTTP.CORSOrigins)
+	}
+
+	t.Setenv("HTTP_CORS_ORIGINS", "https://shop.example.com, https://admin.example.com")
+	cfg, err = Load(nil)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cfg.HTTP.CORSOrigins) != 2 || cfg.HTTP.CORSOrigins[1] != "https://admin.example.com" {
+		t.Errorf("Expected the two origins, got %q", cfg.HTTP.CORSOrigins)
+	}
+}
+
+func TestValidateOutbox(t *testing.T) {
+	cfg := Defaults(ProfileDev)
+	cfg.Outbox.Sinks = []string{SinkWebhook, SinkMongo, "kafka"}
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	for _, want := range []string{"webhook_url", "requires a mongo cluster", `unknown outbox sink "kafka"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got %v", want, err)
+		}
+	}
+
+	cfg.Outbox.Enabled = false
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected the sinks of a disabled outbox to be ignored, got %v", err)
+	}
+}
+
+func TestLoadAuthSettings(t *testing.T) {
+	clearEnv(t)
+	t.Setenv("PASSWORD_HASH_ALGORITHM", HashArgon2id)
+	t.Setenv("OIDC_ISSUER", "https://issuer.example.com")
+	t.Setenv("OIDC_CLIENT_ID", "marketplace")
+	t.Setenv("OIDC_REDIRECT_URL", "https://marketplace.example.com/oidc/callback")
+	t.Setenv("OIDC_SCOPES", "openid email")
+	t.Setenv("LOGIN_THROTTLE_ADDRESS_FAILURES", "100")
+	path := writeFile(t, "password:\n  min_length: 12\nlogin_throttle:\n  max_failures: 3\n")
+
+	cfg, err := Load([]string{"-config", path, "-login-throttle-lockout", "1h"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.Password.MinLength != 12 || !cfg.Password.RequireUpper || cfg.Password.Hash.Algorithm != HashArgon2id {
+		t.Errorf("Expected the password settings of the file and the environment, got %+v", cfg.Password)
+	}
+	if !cfg.OIDC.Enabled() || len(cfg.OIDC.Scopes) != 2 || cfg.OIDC.Scopes[1] != "email" {
+		t.Errorf("Expected the space separated scopes, got %+v", cfg.OIDC)
+	}
+	if cfg.Throttle.MaxFailures != 3 || cfg.Throttle.AddressFailures != 100 || cfg.Throttle.Lockout != time.Hour || cfg.Throttle.Window != time.Hour {
+		t.Errorf("Expected the throttle settings of the file and the flags, got %+v", cfg.Throttle)
+	}
+}
+
+func TestValidateAuthSettings(t *testing.T) {
+	cfg := Defaults(ProfileDev)
+	cfg.Password.MaxBytes = 100
+	cfg.Password.Hash.Algorithm = "md5"
+	cfg.Password.Hash.BcryptCost = 40
+	cfg.OIDC.Issuer = "https://issuer.example.com"
+	cfg.Throttle.BaseDelay = time.Minute
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	for _, want := range []string{"max_bytes", `algorithm "md5"`, "bcrypt_cost", "client_id", "base_delay must not exceed"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got %v", want, err)
+		}
+	}
+}
+
+func TestReplica(t *testing.T) {
+	cfg := Defaults(ProfileDev)
+
+	replica, err := cfg.Database.Replica("replica-1:6432")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if replica.Host != "replica-1" || replica.Port != 6432 || replica.User != cfg.Database.User {
+		t.Errorf("Expected the address of the replica with the options of the primary, got %+v", replica)
+	}
+
+	if replica, _ := cfg.Database.Replica("replica-2"); replica.Port != cfg.Database.Port {
+		t.Errorf("Expected the port of the primary, got %d", replica.Port)
+	}
+
+	cfg.Database.Replicas = []string{"replica-3:abc", ":5432"}
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "invalid port") || !strings.Contains(err.Error(), "no host") {
+		t.Errorf("Expected both replicas to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/security"
+)
+
+type apiKeyBody struct {
+	Label     *string    `json:"label" validate:"required,notblank,max=100"`
+	Scopes    []string   `json:"scopes" validate:"dive,scope"`
+	ExpiresAt *time.Time `json:"expires_at" validate:"omitnil,future"`
+	// Only admins can create keys for other users
+	UserID *uint `json:"user_id"`
+}
+
+// Only the label of a key can change
+type apiKeyUpdateBody struct {
+	Label *string `json:"label" validate:"required,notblank,max=100"`
+}
+
+type apiKeyOut struct {
+	ID         uint       `json:"id"`
+	Label      *string    `json:"label"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uint       `json:"user_id"`
+	// Only sent once, when the key is created
+	Key string `json:"key,omitempty"`
+}
+
+type APIKeyController struct {
+	apiKeys APIKeyStore
+	users   UserStore
+}
+
+func NewAPIKeyController(apiKeys APIKeyStore, users UserStore) *APIKeyController {
+	return &APIKeyController{apiKeys: apiKeys, users: users}
+}
+
+func (c *APIKeyController) HandleCreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := requestctx.User(r.Context())
+
+	var keyIn apiKeyBody
+	if !decodeBody(w, r, &keyIn) {
+		return
+	}
+
+	ownerID := user.ID
+	if keyIn.UserID != nil && *keyIn.UserID != user.ID {
+		if *user.Role != uint(ROLE_ADMIN) {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+
+		if _, err := c.users.Fetch(r.Context(), *keyIn.UserID); err != nil {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeUserNotFound)
+			return
+		}
+		ownerID = *keyIn.UserID
+	}
+
+	key, prefix, hash, err := security.GenerateAPIKey()
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal)
+		return
+	}
+
+	apiKey := &models.APIKey{
+		Label:     keyIn.Label,
+		Prefix:    prefix,
+		Hash:      hash,
+		ExpiresAt: keyIn.ExpiresAt,
+		UserID:    ownerID,
+	}
+	if len(keyIn.Scopes) > 0 {
+		scopes := strings.Join(keyIn.Scopes, " ")
+		apiKey.Scopes = &scopes
+	}
+
+	if err := c.apiKeys.Create(r.Context(), apiKey); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	logging.Log.Info(
+		"API key created",
+		slog.String("prefix", prefix),
+		slog.Uint64("user_id", uint64(ownerID)),
+		slog.Uint64("created_by", uint64(user.ID)),
+	)
+
+	out := newAPIKeyOut(apiKey)
+	out.Key = key
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(out)
+}
+
+func (c *APIKeyController) HandleListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user := requestctx.User(r.Context())
+
+	ownerID := user.ID
+	if idStr := r.URL.Query().Get("user_id"); idStr != "" {
+		id, err := strconv.Atoi(idStr)
+		if err != nil {
+			problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID)
+			return
+		}
+
+		if uint(id) != user.ID && *user.Role != uint(ROLE_ADMIN) {
+			problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+			return
+		}
+		ownerID = uint(id)
+	}
+
+	keys, err := c.apiKeys.ListByUser(r.Context(), ownerID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	out := make([]apiKeyOut, len(keys))
+	for i := range keys {
+		out[i] = newAPIKeyOut(&keys[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (c *APIKeyController) HandleUpdateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := requestctx.User(r.Context())
+
+	apiKey, ok := c.fetchOwnedAPIKey(w, r, user)
+	if !ok {
+		return
+	}
+
+	var keyIn apiKeyUpdateBody
+	if !decodeBody(w, r, &keyIn) {
+		return
+	}
+
+	if err := c.apiKeys.UpdateLabel(r.Context(), apiKey.ID, *keyIn.Label); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	apiKey.Label = keyIn.Label
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAPIKeyOut(apiKey))
+}
+
+func (c *APIKeyController) HandleRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := requestctx.User(r.Context())
+
+	apiKey, ok := c.fetchOwnedAPIKey(w, r, user)
+	if !ok {
+		return
+	}
+
+	if err := c.apiKeys.Revoke(r.Context(), apiKey.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	logging.Log.Warn(
+		"Security event: api key revoked",
+		slog.String("event", "api_key_revoked"),
+		slog.String("prefix", apiKey.Prefix),
+		slog.Uint64("revoked_by", uint64(user.ID)),
+	)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// fetchOwnedAPIKey loads the key of the /api-keys/{id} path, making sure
+// it belongs to the user unless the user is an admin
+func (c *APIKeyController) fetchOwnedAPIKey(w http.ResponseWriter, r *http.Request, user *models.User) (*models.APIKey, bool) {
+	id, err := idParam(r)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID)
+		return nil, false
+	}
+
+	apiKey, err := c.apiKeys.Fetch(r.Context(), id)
+	if err != nil {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeAPIKeyNotFound)
+		return nil, false
+	}
+
+	if apiKey.UserID != user.ID && *user.Role != uint(ROLE_ADMIN) {
+		// Same answer as a missing key, so ids of other users are not leaked
+		problem.Error(w, r, http.StatusNotFound, problem.CodeAPIKeyNotFound)
+		return nil, false
+	}
+
+	return apiKey, true
+}
+
+func newAPIKeyOut(apiKey *models.APIKey) apiKeyOut {
+	return apiKeyOut{
+		ID:         apiKey.ID,
+		Label:      apiKey.Label,
+		Prefix:     apiKey.Prefix,
+		Scopes:     apiKey.ScopeList(),
+		ExpiresAt:  apiKey.ExpiresAt,
+		LastUsedAt: apiKey.LastUsedAt,
+		RevokedAt:  apiKey.RevokedAt,
+		CreatedAt:  apiKey.CreatedAt,
+		UserID:     apiKey.UserID,
+	}
+}
@@ -1,15 +1,24 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"math"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
 	"github.com/alexbsec/MiniMarketplace/src/db/models"
 	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/metrics"
+	"github.com/alexbsec/MiniMarketplace/src/security"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -20,129 +29,448 @@ const (
     ROLE_USER  Role = 0
 )
 
-// TODO: make this secure
-var secretKey = []byte("test-secret")
+const (
+    apiKeyHeader        = "X-API-Key"
+    apiKeyTouchInterval = time.Minute
+
+    // Tokens and their sessions last one day
+    tokenLifetime        = 24 * time.Hour
+    sessionTouchInterval = time.Minute
+)
 
 type loginBody struct {
-	Email    *string `json:"email"`
-	Password *string `json:"password"`
+	Email    *string `json:"email" validate:"required"`
+	Password *string `json:"password" validate:"required"`
+}
+
+// At least one of the counters is cleared
+type unlockBody struct {
+	Email *string `json:"email" validate:"required_without=IP,omitnil,email"`
+	IP    *string `json:"ip" validate:"required_without=Email,omitnil,ip"`
+}
+
+// tokenOut is the answer of a login
+type tokenOut struct {
+	Token string `json:"token"`
 }
 
 type JWTContent struct {
-	ID       uint
-	Email    string
-	ExpireAt float64
+	ID        uint
+	Email     string
+	ExpireAt  float64
+	SessionID string
 }
 
-func HandleLogin(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		handleLoginUser(w, r)
-	default:
-		http.Error(w, "Method not allowd", http.StatusMethodNotAllowed)
-	}
+// AuthController logs users in and authenticates the requests of the
+// other routes
+type AuthController struct {
+    users    UserStore
+    apiKeys  APIKeyStore
+    sessions SessionStore
+    throttle models.LoginThrottle
+    hasher   security.PasswordHasher
+    // Signs the JWTs and the OIDC flow cookie
+    secret   []byte
+
+    // Verified on the logins of unknown emails, see verifyDummyPassword
+    dummyHashOnce sync.Once
+    dummyHash     string
 }
 
-func UserAuthFlowLax(w http.ResponseWriter, r *http.Request, expectRole Role) (*models.User, bool) {
-    jwtCtt, err := parseJWT(r) 
-    if err != nil {
-        http.Error(w, err.Error(), http.StatusUnauthorized)
-        return nil, false 
+func NewAuthController(
+    users UserStore,
+    apiKeys APIKeyStore,
+    sessions SessionStore,
+    throttle models.LoginThrottle,
+    hasher security.PasswordHasher,
+    secret []byte,
+) *AuthController {
+    return &AuthController{
+        users:    users,
+        apiKeys:  apiKeys,
+        sessions: sessions,
+        throttle: throttle,
+        hasher:   hasher,
+        secret:   secret,
+    }
+}
+
+// Authenticator returns the authentication path of the routes that
+// require the role, to be used by the core auth middleware
+func (c *AuthController) Authenticator(expectRole Role) func(r *http.Request) (*models.User, error) {
+    return func(r *http.Request) (*models.User, error) {
+        return c.authenticateRequest(r, expectRole)
+    }
+}
+
+// requireSelf makes sure the authenticated user is the one with the id
+func requireSelf(w http.ResponseWriter, r *http.Request, id uint) bool {
+    reqUser := requestctx.User(r.Context())
+    if reqUser == nil || reqUser.ID != id {
+        problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+        return false
     }
 
-    reqUser, err := userService.Fetch(jwtCtt.ID) 
+    return true
+}
+
+// authenticateRequest is the single authentication path of the API. The
+// user is resolved from the X-API-Key header when present, otherwise from
+// the Bearer JWT of the Authorization header.
+func (c *AuthController) authenticateRequest(r *http.Request, expectRole Role) (*models.User, error) {
+    if key := r.Header.Get(apiKeyHeader); key != "" {
+        return c.authenticateAPIKey(r, key, expectRole)
+    }
+
+    jwtCtt, err := c.parseJWT(r)
     if err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
-        return nil, false
+        return nil, err
     }
-  
-    _, err = validateUserRequest(r, reqUser, expectRole) 
+
+    reqUser, err := c.users.Fetch(r.Context(), jwtCtt.ID)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusUnauthorized)
-        return nil, false
+        return nil, fmt.Errorf("Unauthorized")
+    }
+
+    if _, err = c.validateUserRequest(r, reqUser, expectRole); err != nil {
+        return nil, err
+    }
+
+    if err = c.checkSession(r.Context(), jwtCtt, reqUser); err != nil {
+        return nil, err
     }
 
-    return reqUser, true
+    return reqUser, nil
 }
 
-func UserAuthFlow(w http.ResponseWriter, r *http.Request, id uint, expectRole Role) bool {
-    jwtCtt, err := parseJWT(r) 
+func (c *AuthController) authenticateAPIKey(r *http.Request, key string, expectRole Role) (*models.User, error) {
+    prefix, err := security.APIKeyPrefix(key)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusUnauthorized)
-        return false
+        return nil, fmt.Errorf("Unauthorized")
     }
 
-    if jwtCtt.ID != id {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized) 
-        return false
-    } 
+    apiKey, err := c.apiKeys.FetchByPrefix(r.Context(), prefix)
+    if err != nil || !security.VerifyAPIKey(key, apiKey.Hash) {
+        logging.Log.Warn("Security event: invalid api key", slog.String("event", "api_key_invalid"), slog.String("prefix", prefix))
+        return nil, fmt.Errorf("Unauthorized")
+    }
 
-    reqUser, err := userService.Fetch(jwtCtt.ID) 
-    if err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
-        return false
+    now := time.Now()
+    if !apiKey.Active(now) {
+        logging.Log.Warn("Security event: inactive api key used", slog.String("event", "api_key_inactive"), slog.String("prefix", prefix))
+        return nil, fmt.Errorf("Unauthorized")
     }
-  
-    _, err = validateUserRequest(r, reqUser, expectRole) 
+
+    resource, action := requestScope(r)
+    // Keys can't manage the credentials of their user, otherwise a scoped
+    // key could create an unscoped one or revoke the sessions
+    if credentialResources[resource] {
+        logging.Log.Warn(
+            "Security event: api key used to manage credentials",
+            slog.String("event", "api_key_credentials"),
+            slog.String("prefix", prefix),
+            slog.String("resource", resource),
+        )
+        return nil, problem.New(http.StatusForbidden, problem.CodeSessionRequired)
+    }
+    if !security.ScopeAllows(apiKey.ScopeList(), resource, action) {
+        return nil, problem.New(http.StatusForbidden, problem.CodeInsufficientScope).WithArgs(action, resource)
+    }
+
+    reqUser, err := c.users.Fetch(r.Context(), apiKey.UserID)
     if err != nil {
-        http.Error(w, err.Error(), http.StatusUnauthorized)
+        return nil, fmt.Errorf("Unauthorized")
+    }
+
+    if *reqUser.Role < uint(expectRole) {
+        return nil, fmt.Errorf("Unauthorized")
+    }
+
+    // Avoid a write on every request of a busy client
+    if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyTouchInterval {
+        if err := c.apiKeys.Touch(r.Context(), apiKey.ID, now); err != nil {
+            logging.Log.Error("Failed to update api key usage", slog.String("prefix", prefix), slog.String("error", err.Error()))
+        }
+    }
+
+    return reqUser, nil
+}
+
+// Resources only a login session can use, see authenticateAPIKey
+var credentialResources = map[string]bool{
+    "api-keys": true,
+    "sessions": true,
+}
+
+// requestScope maps a request to the scope an API key needs for it, e.g.
+// GET /api/v1/products/1 or GET /products/1 needs "products:read"
+func requestScope(r *http.Request) (string, string) {
+    path := strings.Trim(r.URL.Path, "/")
+    // The resource follows the version of the API, when there is one
+    if rest, ok := strings.CutPrefix(path, "api/"); ok {
+        if version, after, _ := strings.Cut(rest, "/"); apiVersion(version) {
+            path = after
+        }
+    }
+    resource, _, _ := strings.Cut(path, "/")
+
+    switch r.Method {
+    case http.MethodGet, http.MethodHead, http.MethodOptions:
+        return resource, "read"
+    default:
+        return resource, "write"
+    }
+}
+
+// apiVersion reports whether the path segment is a version of the API,
+// e.g. "v1"
+func apiVersion(segment string) bool {
+    digits, ok := strings.CutPrefix(segment, "v")
+    if !ok || digits == "" {
         return false
     }
 
+    for _, c := range digits {
+        if c < '0' || c > '9' {
+            return false
+        }
+    }
+
     return true
 }
 
-func handleLoginUser(w http.ResponseWriter, r *http.Request) {
+func bearerToken(r *http.Request) (string, error) {
+    header := r.Header.Get("Authorization")
+    token, ok := strings.CutPrefix(header, "Bearer ")
+    if !ok || token == "" {
+        return "", fmt.Errorf("Unauthorized")
+    }
+
+    return token, nil
+}
+
+func (c *AuthController) HandleLoginUser(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	var params loginBody
-	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if !decodeBody(w, r, &params) {
 		return
 	}
 
-	if params.Email == nil || params.Password == nil {
-		http.Error(w, "Please provide your e-mail and password", http.StatusBadRequest)
+	accountKey := models.AccountThrottleKey(*params.Email)
+	ipKey := models.AddressThrottleKey(clientIP(r))
+	if !c.reserveLoginAttempt(w, r, accountKey, ipKey) {
 		return
 	}
 
-	userRec, err := userService.FetchUserByEmail(params.Email)
+	userRec, err := c.users.FetchUserByEmail(r.Context(), params.Email)
 	if err != nil {
-		http.Error(w, "Usuário ou senha incorretos", http.StatusNotFound)
+		c.verifyDummyPassword(*params.Password)
+		c.failLoginAttempts(r.Context(), accountKey, ipKey)
+		metrics.LoginFailure(metrics.FailureBadCredentials)
+		// Same answer as a wrong password, so e-mails are not leaked
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials)
 		return
 	}
 
-	passwordsMatch := verifyPassword(*params.Password, *userRec.Password)
+	passwordsMatch, needsRehash, err := c.hasher.Verify(*params.Password, *userRec.Password)
+	if err != nil {
+		logging.Log.Error("Failed to verify password hash", slog.Uint64("user_id", uint64(userRec.ID)), slog.String("error", err.Error()))
+	}
+
 	if !passwordsMatch {
-		http.Error(w, "Usuário ou senha incorretos", http.StatusUnauthorized)
+		c.failLoginAttempts(r.Context(), accountKey, ipKey)
+		metrics.LoginFailure(metrics.FailureBadCredentials)
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeInvalidCredentials)
 		return
 	}
 
-	// TODO: Handle login logic (give token etc)
-	tokenString, err := createJWT(userRec)
+	if needsRehash {
+		c.rehashPassword(r.Context(), userRec.ID, *params.Password)
+	}
+
+	// Only the attempt is taken back from the IP counter, otherwise an
+	// attacker could reset it by logging into an account of their own
+	// between attempts
+	if err := c.throttle.Reset(r.Context(), accountKey); err != nil {
+		logging.Log.Error("Failed to reset login throttle", slog.String("key", accountKey), slog.String("error", err.Error()))
+	}
+	c.releaseLoginAttempts(r.Context(), ipKey)
+
+	tokenString, err := c.startSession(r, userRec)
 	if err != nil {
-		http.Error(w, "Failed to create session token", http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+	metrics.Login(metrics.LoginPassword)
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"token": tokenString,
+	json.NewEncoder(w).Encode(tokenOut{Token: tokenString})
+}
+
+// verifyDummyPassword takes as long as verifying the password of a user,
+// so the time of the answer doesn't tell whether the email is registered
+func (c *AuthController) verifyDummyPassword(password string) {
+	c.dummyHashOnce.Do(func() {
+		hash, err := c.hasher.Hash("not-the-password-of-anyone")
+		if err != nil {
+			logging.Log.Error("Failed to hash the dummy password", slog.String("error", err.Error()))
+		}
+		c.dummyHash = hash
 	})
+
+	c.hasher.Verify(password, c.dummyHash)
+}
+
+// rehashPassword upgrades the stored hash to the current algorithm and
+// parameters. Failing to do so does not prevent the login.
+func (c *AuthController) rehashPassword(ctx context.Context, id uint, password string) {
+	hash, err := hashPassword(c.hasher, password)
+	if err != nil {
+		logging.Log.ErrorContext(ctx, "Failed to rehash password", slog.Uint64("user_id", uint64(id)), slog.String("error", err.Error()))
+		return
+	}
+
+	if err := c.users.Update(ctx, id, &models.User{Password: hash}); err != nil {
+		logging.Log.ErrorContext(ctx, "Failed to store rehashed password", slog.Uint64("user_id", uint64(id)), slog.String("error", err.Error()))
+		return
+	}
+
+	logging.Log.InfoContext(ctx, "Password hash upgraded", slog.Uint64("user_id", uint64(id)), slog.String("algorithm", c.hasher.Algorithm))
 }
 
-func validateUserRequest(r *http.Request, user *models.User, expectRole Role) (*JWTContent, error) {
+// handleUnlockLogin lets an admin clear the failed login counters of an
+// account and/or an IP address
+func (c *AuthController) HandleUnlockLogin(w http.ResponseWriter, r *http.Request) {
+	admin := requestctx.User(r.Context())
+
+	var params unlockBody
+	if !decodeBody(w, r, &params) {
+		return
+	}
+
+	var keys []string
+	if params.Email != nil {
+		keys = append(keys, models.AccountThrottleKey(*params.Email))
+	}
+	if params.IP != nil {
+		keys = append(keys, models.AddressThrottleKey(*params.IP))
+	}
+
+	for _, key := range keys {
+		if err := c.throttle.Reset(r.Context(), key); err != nil {
+			writeError(w, r, err)
+			return
+		}
+
+		logging.Log.Warn(
+			"Security event: login unlocked by admin",
+			slog.String("event", "login_unlock"),
+			slog.String("key", key),
+			slog.Uint64("admin_id", uint64(admin.ID)),
+		)
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// reserveLoginAttempt counts the attempt for every key before the
+// credentials are verified. It writes a 429 response and returns false
+// when any of the keys must wait, taking back the attempt from the others.
+func (c *AuthController) reserveLoginAttempt(w http.ResponseWriter, r *http.Request, keys ...string) bool {
+	var reserved []string
+	var wait time.Duration
+	for _, key := range keys {
+		_, keyWait, err := c.throttle.Reserve(r.Context(), key)
+		if err != nil {
+			logging.Log.Error("Failed to reserve login attempt", slog.String("key", key), slog.String("error", err.Error()))
+			c.releaseLoginAttempts(r.Context(), reserved...)
+			problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal)
+			return false
+		}
+
+		if keyWait > 0 {
+			logging.Log.Warn(
+				"Security event: throttled login attempt",
+				slog.String("event", "login_throttled"),
+				slog.String("key", key),
+				slog.Duration("retry_after", keyWait),
+			)
+			wait = max(wait, keyWait)
+			continue
+		}
+
+		reserved = append(reserved, key)
+	}
+
+	if wait == 0 {
+		return true
+	}
+
+	c.releaseLoginAttempts(r.Context(), reserved...)
+	metrics.LoginFailure(metrics.FailureThrottled)
+
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	problem.Error(w, r, http.StatusTooManyRequests, problem.CodeLoginThrottled)
+	return false
+}
+
+func (c *AuthController) releaseLoginAttempts(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		if err := c.throttle.Release(ctx, key); err != nil {
+			logging.Log.ErrorContext(ctx, "Failed to release login attempt", slog.String("key", key), slog.String("error", err.Error()))
+		}
+	}
+}
+
+// failLoginAttempts records the failure of the attempts reserved for a
+// login, making the keys wait
+func (c *AuthController) failLoginAttempts(ctx context.Context, keys ...string) {
+	for _, key := range keys {
+		attempt, err := c.throttle.Fail(ctx, key)
+		if err != nil {
+			logging.Log.ErrorContext(ctx, "Failed to record login failure", slog.String("key", key), slog.String("error", err.Error()))
+			continue
+		}
+
+		event := "login_failed"
+		if attempt.Locked {
+			event = "login_lockout"
+		}
+
+		logging.Log.WarnContext(
+			ctx,
+			"Security event: failed login attempt",
+			slog.String("event", event),
+			slog.String("key", attempt.Key),
+			slog.Uint64("failures", uint64(attempt.Failures)),
+		)
+	}
+}
+
+// clientIP returns the address of the peer. X-Forwarded-For is ignored since
+// it can be freely set by the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func (c *AuthController) validateUserRequest(r *http.Request, user *models.User, expectRole Role) (*JWTContent, error) {
     if *user.Role < uint(expectRole) {
         return nil, fmt.Errorf("Unauthorized") 
     }
 
-	tokenString := r.Header.Get("Authorization")
-	if tokenString == "" {
-		return nil, fmt.Errorf("Unauthorized")
+	tokenString, err := bearerToken(r)
+	if err != nil {
+		return nil, err
 	}
 
-    tokenString = tokenString[len("Bearer "):]
-	token, err := verifyJWT(user, tokenString)
+	token, err := c.verifyJWT(user, tokenString)
 	if err != nil {
 		logging.Log.Warn("Verification of JWT failed", slog.String("cause", err.Error()))
 		return nil, fmt.Errorf("Unauthorized")
@@ -151,17 +479,16 @@ func validateUserRequest(r *http.Request, user *models.User, expectRole Role) (*
 	return token, nil
 }
 
-func parseJWT(r *http.Request) (*JWTContent, error) {
-	tokenString := r.Header.Get("Authorization")
-	if tokenString == "" {
-		return nil, fmt.Errorf("Unauthorized")
+func (c *AuthController) parseJWT(r *http.Request) (*JWTContent, error) {
+	tokenString, err := bearerToken(r)
+	if err != nil {
+		return nil, err
 	}
 
-    tokenString = tokenString[len("Bearer "):]
     logging.Log.Debug("JWT token being processed", slog.String("token", tokenString))
 
     token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-        return secretKey, nil
+        return c.secret, nil
     })
 
     if err != nil {
@@ -191,6 +518,13 @@ func parseJWT(r *http.Request) (*JWTContent, error) {
 			return nil, fmt.Errorf("invalid user ID")
 		}
         jwtContent.ID = uint(idFloat)
+
+		// Extract and validate "sid" claim
+		sid, ok := claims["sid"].(string)
+		if !ok || sid == "" {
+			return nil, fmt.Errorf("invalid session")
+		}
+        jwtContent.SessionID = sid
     } else {
 		return nil, fmt.Errorf("invalid token claims")
 	}
@@ -198,18 +532,74 @@ func parseJWT(r *http.Request) (*JWTContent, error) {
     return &jwtContent, nil
 }
 
-func createJWT(user *models.User) (string, error) {
-    // Create a one-day token
+// startSession records a new session for the device making the request
+// and returns a token bound to it
+func (c *AuthController) startSession(r *http.Request, user *models.User) (string, error) {
+	sid, err := security.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	userAgent := r.UserAgent()
+	ip := clientIP(r)
+	session := &models.Session{
+		SID:        sid,
+		UserAgent:  &userAgent,
+		IP:         &ip,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(tokenLifetime),
+		UserID:     user.ID,
+	}
+
+	if err := c.sessions.Create(r.Context(), session); err != nil {
+		return "", err
+	}
+
+	return c.createJWT(user, sid, session.ExpiresAt)
+}
+
+// checkSession makes sure the session of the token was not revoked
+func (c *AuthController) checkSession(ctx context.Context, jwtCtt *JWTContent, user *models.User) error {
+	session, err := c.sessions.FetchBySID(ctx, jwtCtt.SessionID)
+	if err != nil || session.UserID != user.ID {
+		return fmt.Errorf("Unauthorized")
+	}
+
+	now := time.Now()
+	if !session.Active(now) {
+		logging.Log.WarnContext(
+			ctx,
+			"Security event: revoked session used",
+			slog.String("event", "session_revoked_use"),
+			slog.Uint64("session_id", uint64(session.ID)),
+			slog.Uint64("user_id", uint64(user.ID)),
+		)
+		return fmt.Errorf("Unauthorized")
+	}
+
+	// Avoid a write on every request
+	if now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		if err := c.sessions.Touch(ctx, session.ID, now); err != nil {
+			logging.Log.ErrorContext(ctx, "Failed to update session activity", slog.Uint64("session_id", uint64(session.ID)), slog.String("error", err.Error()))
+		}
+	}
+
+	return nil
+}
+
+func (c *AuthController) createJWT(user *models.User, sid string, expiresAt time.Time) (string, error) {
 	token := jwt.NewWithClaims(
 		jwt.SigningMethodHS256,
 		jwt.MapClaims{
 			"id":    user.ID,
-			"exp":   time.Now().Add(time.Hour * 24).Unix(),
+			"exp":   expiresAt.Unix(),
 			"email": *user.Email,
+			"sid":   sid,
 		},
 	)
 
-	tokenString, err := token.SignedString(secretKey)
+	tokenString, err := token.SignedString(c.secret)
 	if err != nil {
 		logging.Log.Error("Failed to create JWT token", slog.String("error", err.Error()))
 		return "", err
@@ -219,9 +609,9 @@ func createJWT(user *models.User) (string, error) {
 }
 
 
-func verifyJWT(user *models.User, tokenString string) (*JWTContent, error) {
+func (c *AuthController) verifyJWT(user *models.User, tokenString string) (*JWTContent, error) {
     token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-        return secretKey, nil
+        return c.secret, nil
     })
 
     if err != nil {
@@ -251,6 +641,13 @@ func verifyJWT(user *models.User, tokenString string) (*JWTContent, error) {
 		}
         jwtContent.ID = uint(idFloat)
 
+		// Extract and validate "sid" claim
+		sid, ok := claims["sid"].(string)
+		if !ok || sid == "" {
+			return nil, fmt.Errorf("invalid session")
+		}
+        jwtContent.SessionID = sid
+
 		// Check if token is expired
 		if time.Unix(int64(exp), 0).Before(time.Now()) {
 			return nil, fmt.Errorf("token has expired")
@@ -0,0 +1,318 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+)
+
+func login(controller *AuthController, email string, password string) *httptest.ResponseRecorder {
+	body := `{"email": "` + email + `", "password": "` + password + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	controller.HandleLoginUser(rec, req)
+	return rec
+}
+
+func TestLoginStartsSession(t *testing.T) {
+	users := newFakeUsers()
+	sessions := newFakeSessions()
+	controller := newTestAuthController(users, sessions)
+	user := newTestUser(users, "alice@example.com", "Correct7Horse", ROLE_USER)
+
+	rec := login(controller, "alice@example.com", "Correct7Horse")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	var out map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/users?id=1", nil)
+	req.Header.Set("Authorization", "Bearer "+out["token"])
+
+	authenticated, err := controller.Authenticator(ROLE_USER)(req)
+	if err != nil {
+		t.Fatalf("Expected the token to authenticate, got %v", err)
+	}
+	if authenticated.ID != user.ID {
+		t.Errorf("Expected user %d, got %d", user.ID, authenticated.ID)
+	}
+
+	if _, err := controller.Authenticator(ROLE_ADMIN)(req); err == nil {
+		t.Error("Expected a user token to be refused on admin routes")
+	}
+
+	// Revoking the session invalidates the token right away
+	active, _ := sessions.ListActiveByUser(context.Background(), user.ID)
+	if len(active) != 1 {
+		t.Fatalf("Expected one active session, got %d", len(active))
+	}
+	sessions.Revoke(context.Background(), active[0].ID)
+
+	if _, err := controller.Authenticator(ROLE_USER)(req); err == nil {
+		t.Error("Expected the token of a revoked session to be refused")
+	}
+}
+
+func TestLoginThrottlesFailures(t *testing.T) {
+	users := newFakeUsers()
+	controller := newTestAuthController(users, newFakeSessions())
+	newTestUser(users, "bob@example.com", "Correct7Horse", ROLE_USER)
+
+	rec := login(controller, "bob@example.com", "wrong")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+
+	// Even the right password waits until the delay is over
+	rec = login(controller, "bob@example.com", "Correct7Horse")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if rec.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+}
+
+func TestLoginThrottlesConcurrentGuesses(t *testing.T) {
+	users := newFakeUsers()
+	controller := newTestAuthController(users, newFakeSessions())
+	newTestUser(users, "bob@example.com", "Correct7Horse", ROLE_USER)
+
+	// Each guess reserves an attempt before being verified, the ones past
+	// the lockout wait instead
+	const guesses = 10
+	codes := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for i := 0; i < guesses; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- login(controller, "bob@example.com", "wrong").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	verified := 0
+	for code := range codes {
+		switch code {
+		case http.StatusUnauthorized:
+			verified++
+		case http.StatusTooManyRequests:
+		default:
+			t.Errorf("Unexpected status %d", code)
+		}
+	}
+	if verified == 0 || verified > int(models.DefaultThrottlePolicy.MaxFailures) {
+		t.Errorf("Expected 1 to %d guesses to be verified, got %d", models.DefaultThrottlePolicy.MaxFailures, verified)
+	}
+}
+
+// blockingUsers holds the login of an email until released
+type blockingUsers struct {
+	*fakeUsers
+	email   string
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingUsers) FetchUserByEmail(ctx context.Context, email *string) (*models.User, error) {
+	if strings.EqualFold(*email, b.email) {
+		close(b.entered)
+		<-b.release
+	}
+	return b.fakeUsers.FetchUserByEmail(ctx, email)
+}
+
+func TestLoginInProgressDoesNotThrottleAddress(t *testing.T) {
+	users := newFakeUsers()
+	newTestUser(users, "alice@example.com", "Correct7Horse", ROLE_USER)
+	newTestUser(users, "carol@example.com", "Battery9Staple", ROLE_USER)
+	blocking := &blockingUsers{
+		fakeUsers: users,
+		email:     "alice@example.com",
+		entered:   make(chan struct{}),
+		release:   make(chan struct{}),
+	}
+	controller := NewAuthController(
+		blocking,
+		fakeAPIKeys{},
+		newFakeSessions(),
+		models.NewMemoryLoginThrottle(models.DefaultThrottlePolicy, models.DefaultAddressThrottlePolicy),
+		testHasher,
+		[]byte("test-secret"),
+	)
+
+	aliceCode := make(chan int, 1)
+	go func() {
+		aliceCode <- login(controller, "alice@example.com", "wrong").Code
+	}()
+	<-blocking.entered
+
+	// Same address, other account, while the attempt of alice is reserved
+	if rec := login(controller, "carol@example.com", "Battery9Staple"); rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	close(blocking.release)
+	if code := <-aliceCode; code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, code)
+	}
+
+	// A single failure does not make the address wait either
+	if rec := login(controller, "carol@example.com", "Battery9Staple"); rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d after a failure from the address, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestLoginSuccessDoesNotThrottleAddress(t *testing.T) {
+	users := newFakeUsers()
+	controller := newTestAuthController(users, newFakeSessions())
+	newTestUser(users, "alice@example.com", "Correct7Horse", ROLE_USER)
+	newTestUser(users, "carol@example.com", "Battery9Staple", ROLE_USER)
+
+	if rec := login(controller, "alice@example.com", "Correct7Horse"); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	// The attempt reserved for the address was taken back
+	if rec := login(controller, "carol@example.com", "Battery9Staple"); rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestAuthenticatorRejectsMissingToken(t *testing.T) {
+	controller := newTestAuthController(newFakeUsers(), newFakeSessions())
+
+	req := httptest.NewRequest(http.MethodGet, "/users?id=1", nil)
+	if _, err := controller.Authenticator(ROLE_USER)(req); err == nil {
+		t.Error("Expected a request without token to be refused")
+	}
+}
+
+func TestLoginUnknownEmailLooksLikeWrongPassword(t *testing.T) {
+	// A controller each so the attempts do not affect each other
+	newController := func() *AuthController {
+		users := newFakeUsers()
+		newTestUser(users, "bob@example.com", "Correct7Horse", ROLE_USER)
+		return newTestAuthController(users, newFakeSessions())
+	}
+
+	decode := func(rec *httptest.ResponseRecorder) problem.Problem {
+		var out problem.Problem
+		json.NewDecoder(rec.Body).Decode(&out)
+		return out
+	}
+
+	unknown := login(newController(), "nobody@example.com", "Correct7Horse")
+	wrong := login(newController(), "bob@example.com", "wrong")
+
+	if unknown.Code != wrong.Code {
+		t.Errorf("Expected the same status, got %d and %d", unknown.Code, wrong.Code)
+	}
+	if a, b := decode(unknown), decode(wrong); a.Code != problem.CodeInvalidCredentials || a.Detail != b.Detail {
+		t.Errorf("Expected the same problem, got %+v and %+v", a, b)
+	}
+}
+
+func TestAPIKeyScopesApplyToVersionedRoutes(t *testing.T) {
+	users := newFakeUsers()
+	user := newTestUser(users, "dave@example.com", "Correct7Horse", ROLE_USER)
+	apiKeys := fakeAPIKeys{keys: map[string]*models.APIKey{}}
+	controller := NewAuthController(
+		users,
+		apiKeys,
+		newFakeSessions(),
+		models.NewMemoryLoginThrottle(models.DefaultThrottlePolicy, models.DefaultAddressThrottlePolicy),
+		testHasher,
+		[]byte("test-secret"),
+	)
+	key := newTestAPIKey(apiKeys, user.ID, "products:read")
+
+	cases := []struct {
+		method  string
+		path    string
+		allowed bool
+	}{
+		{http.MethodGet, "/api/v1/products/1", true},
+		{http.MethodGet, "/products/1", true},
+		{http.MethodPut, "/api/v1/products/1", false},
+		{http.MethodGet, "/api/v1/users", false},
+		{http.MethodGet, "/api/v1/users/1", false},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, c.path, nil)
+		req.Header.Set(apiKeyHeader, key)
+
+		_, err := controller.Authenticator(ROLE_USER)(req)
+		if allowed := err == nil; allowed != c.allowed {
+			t.Errorf("%s %s: expected allowed to be %v, got error %v", c.method, c.path, c.allowed, err)
+		}
+	}
+}
+
+func TestCredentialRoutesRequireASession(t *testing.T) {
+	users := newFakeUsers()
+	user := newTestUser(users, "erin@example.com", "Correct7Horse", ROLE_USER)
+	apiKeys := fakeAPIKeys{keys: map[string]*models.APIKey{}}
+	controller := NewAuthController(
+		users,
+		apiKeys,
+		newFakeSessions(),
+		models.NewMemoryLoginThrottle(models.DefaultThrottlePolicy, models.DefaultAddressThrottlePolicy),
+		testHasher,
+		[]byte("test-secret"),
+	)
+
+	// Even an unscoped key can't create keys or manage the sessions, or a
+	// scoped one could create one with more access
+	for _, scopes := range [][]string{nil, {"products:read"}, {"*:*"}} {
+		key := newTestAPIKey(apiKeys, user.ID, scopes...)
+		for _, target := range []string{"POST /api/v1/api-keys", "GET /api-keys", "DELETE /api/v1/sessions/1"} {
+			method, path, _ := strings.Cut(target, " ")
+			req := httptest.NewRequest(method, path, nil)
+			req.Header.Set(apiKeyHeader, key)
+
+			_, err := controller.Authenticator(ROLE_USER)(req)
+			var p *problem.Problem
+			if !errors.As(err, &p) || p.Code != problem.CodeSessionRequired {
+				t.Errorf("%s with scopes %v: expected %s, got %v", target, scopes, problem.CodeSessionRequired, err)
+			}
+		}
+	}
+
+	// A login session can create keys
+	rec := login(controller, "erin@example.com", "Correct7Horse")
+	var out map[string]string
+	json.NewDecoder(rec.Body).Decode(&out)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/api-keys", nil)
+	req.Header.Set("Authorization", "Bearer "+out["token"])
+	if _, err := controller.Authenticator(ROLE_USER)(req); err != nil {
+		t.Errorf("Expected a session to manage the api keys, got %v", err)
+	}
+}
+
+func TestLoginUnknownEmailVerifiesAHash(t *testing.T) {
+	controller := newTestAuthController(newFakeUsers(), newFakeSessions())
+
+	login(controller, "nobody@example.com", "Correct7Horse")
+
+	// The password was checked against a hash of the same cost as the
+	// ones of the users
+	if !strings.HasPrefix(controller.dummyHash, "$2a$04$") {
+		t.Errorf("Expected a bcrypt hash of the test cost, got %q", controller.dummyHash)
+	}
+}
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/security"
+)
+
+// writeError writes err as a problem. Problems are meant for the client, a
+// request whose deadline passed is answered with a 503, anything else is
+// logged and answered with a 500 without the details.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var p *problem.Problem
+	if errors.As(err, &p) {
+		problem.Write(w, r, p)
+		return
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		logging.Log.WarnContext(r.Context(), "Request deadline exceeded", slog.String("error", err.Error()))
+		problem.Error(w, r, http.StatusServiceUnavailable, problem.CodeRequestTimeout)
+		return
+	}
+
+	logging.Log.ErrorContext(r.Context(), "Unexpected error while handling request", slog.String("error", err.Error()))
+	problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal)
+}
+
+// passwordPolicyProblem lists every violated rule as an error of field,
+// the name of the password in the request body
+func passwordPolicyProblem(field string, policyErr *security.PasswordPolicyError) *problem.Problem {
+	fieldErrs := make([]problem.FieldError, len(policyErr.Violations))
+	for i, violation := range policyErr.Violations {
+		fieldErrs[i] = problem.FieldError{
+			Field:   field,
+			Code:    violation.Rule,
+			Message: violation.Message,
+		}
+		if violation.Limit > 0 {
+			fieldErrs[i].Args = []any{violation.Limit}
+		}
+	}
+
+	return problem.Validation(fieldErrs...)
+}
+
+// required is the error of a field left out of the request
+func required(field string) problem.FieldError {
+	return problem.FieldError{
+		Field: field,
+		Code:  "required",
+		Args:  []any{field},
+	}
+}
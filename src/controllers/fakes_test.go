@@ -0,0 +1,350 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/security"
+	"gorm.io/gorm"
+)
+
+// In memory implementations of the stores, so handlers are tested without
+// a database
+
+var errNotFound = fmt.Errorf("record not found")
+
+type fakeProducts struct {
+	products map[uint]*models.Product
+	nextID   uint
+}
+
+func newFakeProducts() *fakeProducts {
+	return &fakeProducts{products: make(map[uint]*models.Product)}
+}
+
+func (f *fakeProducts) Create(ctx context.Context, product *models.Product) error {
+	// Like a query, fails once the deadline of the request passed
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.nextID++
+	product.ID = f.nextID
+	stored := *product
+	f.products[product.ID] = &stored
+	return nil
+}
+
+func (f *fakeProducts) Fetch(ctx context.Context, id uint) (*models.Product, error) {
+	// Like a query, fails once the deadline of the request passed
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	product, ok := f.products[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	out := *product
+	return &out, nil
+}
+
+func (f *fakeProducts) Update(ctx context.Context, id uint, newProduct *models.Product) error {
+	if _, ok := f.products[id]; !ok {
+		return errNotFound
+	}
+	stored := *newProduct
+	f.products[id] = &stored
+	return nil
+}
+
+func (f *fakeProducts) Delete(ctx context.Context, id uint) error {
+	if _, ok := f.products[id]; !ok {
+		return errNotFound
+	}
+	delete(f.products, id)
+	return nil
+}
+
+type fakeUsers struct {
+	users  map[uint]*models.User
+	nextID uint
+}
+
+func newFakeUsers() *fakeUsers {
+	return &fakeUsers{users: make(map[uint]*models.User)}
+}
+
+func (f *fakeUsers) Create(ctx context.Context, user *models.User) error {
+	f.nextID++
+	user.ID = f.nextID
+	stored := *user
+	f.users[user.ID] = &stored
+	return nil
+}
+
+func (f *fakeUsers) Fetch(ctx context.Context, id uint) (*models.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	out := *user
+	return &out, nil
+}
+
+func (f *fakeUsers) Update(ctx context.Context, id uint, newUser *models.User) error {
+	user, ok := f.users[id]
+	if !ok {
+		return errNotFound
+	}
+	// Like gorm Updates, only the set fields are written
+	if newUser.Name != nil {
+		user.Name = newUser.Name
+	}
+	if newUser.Email != nil {
+		user.Email = newUser.Email
+	}
+	if newUser.Password != nil {
+		user.Password = newUser.Password
+	}
+	return nil
+}
+
+func (f *fakeUsers) Delete(ctx context.Context, id uint) error {
+	if _, ok := f.users[id]; !ok {
+		return errNotFound
+	}
+	delete(f.users, id)
+	return nil
+}
+
+func (f *fakeUsers) FetchUserByEmail(ctx context.Context, email *string) (*models.User, error) {
+	for _, user := range f.users {
+		if user.Email != nil && strings.EqualFold(*user.Email, *email) {
+			out := *user
+			return &out, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (f *fakeUsers) FetchPassword(ctx context.Context, id uint) (*string, error) {
+	user, err := f.Fetch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return user.Password, nil
+}
+
+func (f *fakeUsers) FetchEmail(ctx context.Context, id uint) (*string, error) {
+	user, err := f.Fetch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return user.Email, nil
+}
+
+func (f *fakeUsers) CheckEmailExists(ctx context.Context, email *string) (bool, error) {
+	_, err := f.FetchUserByEmail(ctx, email)
+	return err == nil, nil
+}
+
+type fakeSessions struct {
+	mutex    sync.Mutex
+	sessions map[uint]*models.Session
+	nextID   uint
+}
+
+func newFakeSessions() *fakeSessions {
+	return &fakeSessions{sessions: make(map[uint]*models.Session)}
+}
+
+func (f *fakeSessions) Create(ctx context.Context, session *models.Session) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.nextID++
+	session.ID = f.nextID
+	session.CreatedAt = time.Now()
+	stored := *session
+	f.sessions[session.ID] = &stored
+	return nil
+}
+
+func (f *fakeSessions) Fetch(ctx context.Context, id uint) (*models.Session, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	session, ok := f.sessions[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	out := *session
+	return &out, nil
+}
+
+func (f *fakeSessions) FetchBySID(ctx context.Context, sid string) (*models.Session, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	for _, session := range f.sessions {
+		if session.SID == sid {
+			out := *session
+			return &out, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (f *fakeSessions) ListActiveByUser(ctx context.Context, userID uint) ([]models.Session, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	var out []models.Session
+	now := time.Now()
+	for _, session := range f.sessions {
+		if session.UserID == userID && session.Active(now) {
+			out = append(out, *session)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeSessions) Revoke(ctx context.Context, id uint) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	session, ok := f.sessions[id]
+	if !ok {
+		return errNotFound
+	}
+	now := time.Now()
+	session.RevokedAt = &now
+	return nil
+}
+
+func (f *fakeSessions) Touch(ctx context.Context, id uint, seenAt time.Time) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if session, ok := f.sessions[id]; ok {
+		session.LastSeenAt = seenAt
+	}
+	return nil
+}
+
+// fakeAPIKeys only knows the keys given by newTestAPIKey, the other tests
+// authenticate with JWTs
+type fakeAPIKeys struct {
+	keys map[string]*models.APIKey
+}
+
+func (fakeAPIKeys) Create(ctx context.Context, key *models.APIKey) error { return nil }
+func (fakeAPIKeys) Fetch(ctx context.Context, id uint) (*models.APIKey, error) {
+	return nil, errNotFound
+}
+func (f fakeAPIKeys) FetchByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	key, ok := f.keys[prefix]
+	if !ok {
+		return nil, errNotFound
+	}
+	return key, nil
+}
+func (fakeAPIKeys) ListByUser(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	return nil, nil
+}
+func (fakeAPIKeys) UpdateLabel(ctx context.Context, id uint, label string) error { return errNotFound }
+func (fakeAPIKeys) Revoke(ctx context.Context, id uint) error                    { return errNotFound }
+func (fakeAPIKeys) Touch(ctx context.Context, id uint, usedAt time.Time) error   { return nil }
+
+// newTestAPIKey stores a key of the user with the scopes and returns it
+func newTestAPIKey(apiKeys fakeAPIKeys, userID uint, scopes ...string) string {
+	key, prefix, hash, err := security.GenerateAPIKey()
+	if err != nil {
+		panic(err)
+	}
+
+	apiKey := &models.APIKey{Prefix: prefix, Hash: hash, UserID: userID}
+	if len(scopes) > 0 {
+		joined := strings.Join(scopes, " ")
+		apiKey.Scopes = &joined
+	}
+	apiKeys.keys[prefix] = apiKey
+	return key
+}
+
+// Cheap parameters so the tests run fast
+var testHasher = security.PasswordHasher{
+	Algorithm:  security.AlgorithmBcrypt,
+	BcryptCost: 4,
+}
+
+func newTestUser(users *fakeUsers, email string, password string, role Role) *models.User {
+	hash, err := hashPassword(testHasher, password)
+	if err != nil {
+		panic(err)
+	}
+
+	name := "Test"
+	roleValue := uint(role)
+	user := &models.User{Name: &name, Email: &email, Password: hash, Role: &roleValue}
+	users.Create(context.Background(), user)
+	return user
+}
+
+func newTestAuthController(users *fakeUsers, sessions *fakeSessions) *AuthController {
+	return NewAuthController(
+		users,
+		fakeAPIKeys{},
+		sessions,
+		models.NewMemoryLoginThrottle(models.DefaultThrottlePolicy, models.DefaultAddressThrottlePolicy),
+		testHasher,
+		[]byte("test-secret"),
+	)
+}
+
+type fakeOutbox struct {
+	events map[uint]*models.OutboxEvent
+}
+
+func (f *fakeOutbox) List(ctx context.Context, status string, limit int) ([]models.OutboxEvent, error) {
+	var out []models.OutboxEvent
+	for _, event := range f.events {
+		if event.Status == status && len(out) < limit {
+			out = append(out, *event)
+		}
+	}
+	return out, nil
+}
+
+func (f *fakeOutbox) Fetch(ctx context.Context, id uint) (*models.OutboxEvent, error) {
+	event, ok := f.events[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	out := *event
+	return &out, nil
+}
+
+func (f *fakeOutbox) Replay(ctx context.Context, id uint) (*models.OutboxEvent, error) {
+	event, ok := f.events[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	if event.Status != models.OutboxFailed {
+		return nil, models.ErrOutboxEventNotFailed
+	}
+	event.Status = models.OutboxPending
+	event.Attempts = 0
+	out := *event
+	return &out, nil
+}
+
+// withPathID sets the {id} path parameter the router would have matched
+func withPathID(r *http.Request, id string) *http.Request {
+	r.SetPathValue("id", id)
+	return r
+}
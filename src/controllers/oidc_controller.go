@@ -0,0 +1,288 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/metrics"
+	"github.com/alexbsec/MiniMarketplace/src/oidc"
+)
+
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowMaxAge = 10 * time.Minute
+)
+
+// oidcFlow is kept in a signed cookie between the redirect to the issuer
+// and the callback, so any replica can finish the login
+type oidcFlow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Expires  int64  `json:"expires"`
+}
+
+type OIDCController struct {
+	// nil when external login is disabled
+	provider   OIDCProvider
+	identities IdentityStore
+	users      UserStore
+	auth       *AuthController
+	// Set from the configuration, r.TLS is nil behind a proxy
+	// terminating TLS
+	secureCookies bool
+}
+
+func NewOIDCController(provider OIDCProvider, identities IdentityStore, users UserStore, auth *AuthController, secureCookies bool) *OIDCController {
+	return &OIDCController{provider: provider, identities: identities, users: users, auth: auth, secureCookies: secureCookies}
+}
+
+func (c *OIDCController) HandleOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if c.provider == nil {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeExternalLoginDisabled)
+		return
+	}
+
+	var flow oidcFlow
+	for _, field := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		value, err := oidc.RandomString(32)
+		if err != nil {
+			problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal)
+			return
+		}
+		*field = value
+	}
+	flow.Expires = time.Now().Add(oidcFlowMaxAge).Unix()
+
+	authURL, err := c.provider.AuthCodeURL(r.Context(), flow.State, flow.Nonce, flow.Verifier)
+	if err != nil {
+		logging.Log.Error("Failed to build OIDC authorization URL", slog.String("error", err.Error()))
+		problem.Error(w, r, http.StatusBadGateway, problem.CodeExternalLoginUnavailable)
+		return
+	}
+
+	cookie, err := c.encodeOIDCFlow(flow)
+	if err != nil {
+		problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal)
+		return
+	}
+
+	// The callback is a sibling of the login route, e.g. /api/v1/oidc/callback
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    cookie,
+		Path:     path.Dir(r.URL.Path),
+		MaxAge:   int(oidcFlowMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   c.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (c *OIDCController) HandleOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if c.provider == nil {
+		problem.Error(w, r, http.StatusNotFound, problem.CodeExternalLoginDisabled)
+		return
+	}
+
+	// The flow cookie is single use
+	http.SetCookie(w, &http.Cookie{Name: oidcFlowCookie, Path: path.Dir(r.URL.Path), MaxAge: -1, Secure: c.secureCookies})
+
+	query := r.URL.Query()
+	if errCode := query.Get("error"); errCode != "" {
+		logging.Log.Warn("OIDC login refused by issuer", slog.String("error", errCode), slog.String("description", query.Get("error_description")))
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeExternalLoginFailed)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcFlowCookie)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeLoginSessionExpired)
+		return
+	}
+
+	flow, err := c.decodeOIDCFlow(cookie.Value)
+	if err != nil {
+		logging.Log.Warn("Security event: invalid OIDC flow cookie", slog.String("event", "oidc_invalid_flow"), slog.String("error", err.Error()))
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeLoginSessionExpired)
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+		logging.Log.Warn("Security event: OIDC state mismatch", slog.String("event", "oidc_state_mismatch"))
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeExternalLoginFailed)
+		return
+	}
+
+	code := query.Get("code")
+	if code == "" {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeExternalLoginFailed)
+		return
+	}
+
+	tokens, err := c.provider.Exchange(r.Context(), code, flow.Verifier)
+	if err != nil {
+		logging.Log.Error("Failed to exchange OIDC authorization code", slog.String("error", err.Error()))
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeExternalLoginFailed)
+		return
+	}
+
+	claims, err := c.provider.VerifyIDToken(r.Context(), tokens.IDToken, flow.Nonce)
+	if err != nil {
+		logging.Log.Warn("Security event: invalid OIDC id token", slog.String("event", "oidc_invalid_token"), slog.String("error", err.Error()))
+		problem.Error(w, r, http.StatusUnauthorized, problem.CodeExternalLoginFailed)
+		return
+	}
+
+	user, err := c.linkOIDCIdentity(r.Context(), claims)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	tokenString, err := c.auth.startSession(r, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	metrics.Login(metrics.LoginOIDC)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tokenOut{Token: tokenString})
+}
+
+// linkOIDCIdentity returns the user of an external identity. Unknown
+// identities are linked to the user with the same verified e-mail, or to a
+// new user if there is none.
+func (c *OIDCController) linkOIDCIdentity(ctx context.Context, claims *oidc.Claims) (*models.User, error) {
+	identity, err := c.identities.FetchBySubject(ctx, claims.Issuer, claims.Subject)
+	if err != nil {
+		return nil, problem.New(http.StatusInternalServerError, problem.CodeInternal)
+	}
+
+	if identity != nil {
+		user, err := c.users.Fetch(ctx, identity.UserID)
+		if err != nil {
+			return nil, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized)
+		}
+		return user, nil
+	}
+
+	// Linking by an unverified e-mail would let anyone take over an account
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, problem.New(http.StatusForbidden, problem.CodeVerifiedEmailRequired)
+	}
+
+	email := strings.ToLower(claims.Email)
+	identity = &models.UserIdentity{
+		Issuer:  claims.Issuer,
+		Subject: claims.Subject,
+		Email:   &email,
+	}
+
+	exists, err := c.users.CheckEmailExists(ctx, &email)
+	if err != nil {
+		return nil, problem.New(http.StatusInternalServerError, problem.CodeInternal)
+	}
+
+	if exists {
+		user, err := c.users.FetchUserByEmail(ctx, &email)
+		if err != nil {
+			return nil, problem.New(http.StatusInternalServerError, problem.CodeInternal)
+		}
+
+		identity.UserID = user.ID
+		if err := c.identities.Create(ctx, identity); err != nil {
+			return nil, problem.New(http.StatusInternalServerError, problem.CodeInternal)
+		}
+
+		logging.Log.InfoContext(ctx, "External identity linked", slog.String("issuer", claims.Issuer), slog.Uint64("user_id", uint64(user.ID)))
+		return user, nil
+	}
+
+	// The user can only login through the issuer until a password is set
+	randomPassword, err := oidc.RandomString(32)
+	if err != nil {
+		return nil, problem.New(http.StatusInternalServerError, problem.CodeInternal)
+	}
+
+	hash, err := hashPassword(c.auth.hasher, randomPassword)
+	if err != nil {
+		return nil, problem.New(http.StatusInternalServerError, problem.CodeInternal)
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = email
+	}
+
+	role := uint(ROLE_USER)
+	user := &models.User{
+		Name:     &name,
+		Email:    &email,
+		Password: hash,
+		Role:     &role,
+	}
+
+	if err := c.identities.CreateWithUser(ctx, user, identity); err != nil {
+		return nil, problem.New(http.StatusInternalServerError, problem.CodeInternal)
+	}
+
+	logging.Log.InfoContext(ctx, "User created from external identity", slog.String("issuer", claims.Issuer), slog.Uint64("user_id", uint64(user.ID)))
+	return user, nil
+}
+
+func (c *OIDCController) encodeOIDCFlow(flow oidcFlow) (string, error) {
+	payload, err := json.Marshal(flow)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + c.signOIDCFlow(encoded), nil
+}
+
+func (c *OIDCController) decodeOIDCFlow(value string) (*oidcFlow, error) {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(c.signOIDCFlow(encoded))) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	var flow oidcFlow
+	if err := json.Unmarshal(payload, &flow); err != nil {
+		return nil, err
+	}
+
+	if time.Now().Unix() > flow.Expires {
+		return nil, fmt.Errorf("flow expired")
+	}
+
+	return &flow, nil
+}
+
+func (c *OIDCController) signOIDCFlow(encoded string) string {
+	mac := hmac.New(sha256.New, c.auth.secret)
+	mac.Write([]byte(oidcFlowCookie + ":" + encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,49 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/oidc"
+)
+
+// fakeOIDCProvider only builds the authorization URL
+type fakeOIDCProvider struct{}
+
+func (fakeOIDCProvider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	return "https://issuer.example.com/authorize?state=" + state, nil
+}
+
+func (fakeOIDCProvider) Exchange(ctx context.Context, code string, verifier string) (*oidc.TokenResponse, error) {
+	return nil, errNotFound
+}
+
+func (fakeOIDCProvider) VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*oidc.Claims, error) {
+	return nil, errNotFound
+}
+
+func TestOIDCFlowCookieSecureFromConfig(t *testing.T) {
+	users := newFakeUsers()
+	auth := newTestAuthController(users, newFakeSessions())
+
+	for _, secure := range []bool{true, false} {
+		controller := NewOIDCController(fakeOIDCProvider{}, nil, users, auth, secure)
+
+		// Plain HTTP, as seen behind a proxy terminating TLS
+		rec := httptest.NewRecorder()
+		controller.HandleOIDCLogin(rec, httptest.NewRequest(http.MethodGet, "/api/v1/oidc/login", nil))
+		if rec.Code != http.StatusFound {
+			t.Fatalf("Expected status %d, got %d", http.StatusFound, rec.Code)
+		}
+
+		cookies := rec.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != oidcFlowCookie {
+			t.Fatalf("Expected the flow cookie, got %+v", cookies)
+		}
+		if cookies[0].Secure != secure {
+			t.Errorf("Expected Secure to be %v, got %v", secure, cookies[0].Secure)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/openapi"
+)
+
+// Operations documents the handlers of the controllers for the OpenAPI
+// document, keyed by handler name. A handler registered on a route must
+// have an entry here, see the tests of the app.
+func Operations() map[string]openapi.Operation {
+	return map[string]openapi.Operation{
+		"ProductController.HandleFetchProduct": {
+			Summary:  "Fetch a product",
+			Tag:      "Products",
+			Response: models.Product{},
+			ID:       true,
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+		"ProductController.HandleCreateProduct": {
+			Summary:  "Create a product",
+			Tag:      "Products",
+			Access:   openapi.Admin,
+			Request:  productBody{},
+			Status:   http.StatusCreated,
+			Response: models.Product{},
+		},
+		"ProductController.HandleUpdateProduct": {
+			Summary:  "Update the fields of a product given in the body",
+			Tag:      "Products",
+			Access:   openapi.Admin,
+			Request:  productUpdateBody{},
+			Response: models.Product{},
+			ID:       true,
+			Errors:   []int{http.StatusNotFound},
+		},
+		"ProductController.HandleDeleteProduct": {
+			Summary: "Delete a product",
+			Tag:     "Products",
+			Access:  openapi.Admin,
+			ID:      true,
+			Errors:  []int{http.StatusNotFound},
+		},
+
+		"UserController.HandleCreateUser": {
+			Summary:  "Sign up",
+			Tag:      "Users",
+			Request:  userBody{},
+			Status:   http.StatusCreated,
+			Response: userOut{},
+			Errors:   []int{http.StatusConflict},
+		},
+		"UserController.HandleFetchUser": {
+			Summary:  "Fetch the authenticated user",
+			Tag:      "Users",
+			Access:   openapi.User,
+			Response: userOut{},
+			ID:       true,
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+		"UserController.HandleUpdateUser": {
+			Summary:  "Update the authenticated user",
+			Tag:      "Users",
+			Access:   openapi.User,
+			Request:  userUpdateBody{},
+			Response: userOut{},
+			ID:       true,
+			Errors:   []int{http.StatusNotFound, http.StatusConflict},
+		},
+		"UserController.HandleDeleteUser": {
+			Summary: "Delete the authenticated user",
+			Tag:     "Users",
+			Access:  openapi.User,
+			ID:      true,
+			Errors:  []int{http.StatusNotFound},
+		},
+
+		"AuthController.HandleLoginUser": {
+			Summary:  "Log in with e-mail and password",
+			Tag:      "Auth",
+			Request:  loginBody{},
+			Response: tokenOut{},
+			Errors:   []int{http.StatusUnauthorized, http.StatusTooManyRequests},
+		},
+		"AuthController.HandleUnlockLogin": {
+			Summary: "Clear the failed login counters of an account or an IP address",
+			Tag:     "Auth",
+			Access:  openapi.Admin,
+			Request: unlockBody{},
+		},
+		"OIDCController.HandleOIDCLogin": {
+			Summary: "Start a login with the external identity provider",
+			Tag:     "Auth",
+			Status:  http.StatusFound,
+			Errors:  []int{http.StatusNotFound, http.StatusBadGateway},
+		},
+		"OIDCController.HandleOIDCCallback": {
+			Summary:  "Finish a login with the external identity provider",
+			Tag:      "Auth",
+			Response: tokenOut{},
+			Query: []openapi.Query{
+				{Name: "code", Description: "Authorization code", Type: ""},
+				{Name: "state", Description: "State of the login", Type: ""},
+				{Name: "error", Description: "Error of the provider", Type: ""},
+			},
+			Errors: []int{http.StatusBadRequest, http.StatusUnauthorized, http.StatusNotFound},
+		},
+
+		"WalletController.HandleCreateWallet": {
+			Summary:  "Create a wallet, admins may create them for other users",
+			Tag:      "Wallets",
+			Access:   openapi.User,
+			Request:  walletBody{},
+			Status:   http.StatusCreated,
+			Response: walletOut{},
+		},
+		"WalletController.HandleFetchWallet": {
+			Summary:  "Fetch a wallet",
+			Tag:      "Wallets",
+			Access:   openapi.User,
+			Response: walletOut{},
+			ID:       true,
+			Errors:   []int{http.StatusBadRequest},
+		},
+		"WalletController.HandleUpdateWallet": {
+			Summary:  "Update a wallet, only admins change the amount and points",
+			Tag:      "Wallets",
+			Access:   openapi.User,
+			Request:  walletUpdateBody{},
+			Response: walletOut{},
+			ID:       true,
+		},
+
+		"APIKeyController.HandleCreateAPIKey": {
+			Summary:  "Create an API key, the key is only sent in this response",
+			Tag:      "API keys",
+			Access:   openapi.User,
+			Request:  apiKeyBody{},
+			Status:   http.StatusCreated,
+			Response: apiKeyOut{},
+		},
+		"APIKeyController.HandleListAPIKeys": {
+			Summary:  "List the API keys of a user",
+			Tag:      "API keys",
+			Access:   openapi.User,
+			Response: []apiKeyOut{},
+			Query: []openapi.Query{
+				{Name: "user_id", Description: "Owner of the keys, admins only, the authenticated user by default", Type: uint(0)},
+			},
+			Errors: []int{http.StatusBadRequest},
+		},
+		"APIKeyController.HandleUpdateAPIKey": {
+			Summary:  "Rename an API key",
+			Tag:      "API keys",
+			Access:   openapi.User,
+			Request:  apiKeyUpdateBody{},
+			Response: apiKeyOut{},
+			ID:       true,
+			Errors:   []int{http.StatusNotFound},
+		},
+		"APIKeyController.HandleRevokeAPIKey": {
+			Summary: "Revoke an API key",
+			Tag:     "API keys",
+			Access:  openapi.User,
+			ID:      true,
+			Errors:  []int{http.StatusNotFound},
+		},
+
+		"SessionController.HandleListSessions": {
+			Summary:  "List the active sessions of the authenticated user",
+			Tag:      "Sessions",
+			Access:   openapi.User,
+			Response: []sessionOut{},
+		},
+		"SessionController.HandleRevokeSession": {
+			Summary: "Revoke a session",
+			Tag:     "Sessions",
+			Access:  openapi.User,
+			ID:      true,
+			Errors:  []int{http.StatusNotFound},
+		},
+
+		"OutboxController.HandleListOutboxEvents": {
+			Summary:  "List the latest events of the outbox",
+			Tag:      "Outbox",
+			Access:   openapi.Admin,
+			Response: []outboxEventOut{},
+			Query: []openapi.Query{
+				{Name: "status", Description: "pending, delivered or failed, failed by default", Type: ""},
+				{Name: "limit", Description: "Events returned, 50 by default and at most 500", Type: 0},
+			},
+		},
+		"OutboxController.HandleFetchOutboxEvent": {
+			Summary:  "Fetch an event of the outbox",
+			Tag:      "Outbox",
+			Access:   openapi.Admin,
+			Response: outboxEventOut{},
+			ID:       true,
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound},
+		},
+		"OutboxController.HandleReplayOutboxEvent": {
+			Summary:  "Publish a failed event again, its attempts are reset",
+			Tag:      "Outbox",
+			Access:   openapi.Admin,
+			Response: outboxEventOut{},
+			ID:       true,
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+		},
+	}
+}
@@ -0,0 +1,168 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"gorm.io/gorm"
+)
+
+// Events listed when the request gives no limit, and the most it may ask
+const (
+	defaultOutboxLimit = 50
+	maxOutboxLimit     = 500
+)
+
+type outboxEventOut struct {
+	ID            uint   `json:"id"`
+	Type          string `json:"type"`
+	AggregateType string `json:"aggregate_type"`
+	AggregateID   string `json:"aggregate_id"`
+	// The JSON payload of the event, as published
+	Payload     any        `json:"payload"`
+	Status      string     `json:"status"`
+	Attempts    uint       `json:"attempts"`
+	LastError   *string    `json:"last_error"`
+	AvailableAt time.Time  `json:"available_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+}
+
+func newOutboxEventOut(event *models.OutboxEvent) outboxEventOut {
+	return outboxEventOut{
+		ID:            event.ID,
+		Type:          event.Type,
+		AggregateType: event.AggregateType,
+		AggregateID:   event.AggregateID,
+		Payload:       event.Payload,
+		Status:        event.Status,
+		Attempts:      event.Attempts,
+		LastError:     event.LastError,
+		AvailableAt:   event.AvailableAt,
+		CreatedAt:     event.CreatedAt,
+		DeliveredAt:   event.DeliveredAt,
+	}
+}
+
+// OutboxController lets the admins inspect the events of the outbox and
+// replay the ones whose delivery failed
+type OutboxController struct {
+	outbox OutboxStore
+}
+
+func NewOutboxController(outbox OutboxStore) *OutboxController {
+	return &OutboxController{outbox: outbox}
+}
+
+func (c *OutboxController) HandleListOutboxEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var fieldErrs []problem.FieldError
+	status := query.Get("status")
+	switch status {
+	case "":
+		status = models.OutboxFailed
+	case models.OutboxPending, models.OutboxDelivered, models.OutboxFailed:
+	default:
+		fieldErrs = append(fieldErrs, problem.FieldError{
+			Field: "status",
+			Code:  "not_one_of",
+			Args:  []any{"pending delivered failed"},
+		})
+	}
+
+	limit := defaultOutboxLimit
+	if limitStr := query.Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		switch {
+		case err != nil:
+			fieldErrs = append(fieldErrs, problem.FieldError{Field: "limit", Code: "invalid_type"})
+		case parsed < 1:
+			fieldErrs = append(fieldErrs, problem.FieldError{Field: "limit", Code: "too_small", Args: []any{"1"}})
+		case parsed > maxOutboxLimit:
+			fieldErrs = append(fieldErrs, problem.FieldError{Field: "limit", Code: "too_large", Args: []any{strconv.Itoa(maxOutboxLimit)}})
+		default:
+			limit = parsed
+		}
+	}
+
+	if len(fieldErrs) > 0 {
+		problem.Write(w, r, problem.Validation(fieldErrs...))
+		return
+	}
+
+	events, err := c.outbox.List(r.Context(), status, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	out := make([]outboxEventOut, len(events))
+	for i := range events {
+		out[i] = newOutboxEventOut(&events[i])
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (c *OutboxController) HandleFetchOutboxEvent(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID)
+		return
+	}
+
+	event, err := c.outbox.Fetch(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			problem.Error(w, r, http.StatusNotFound, problem.CodeOutboxEventNotFound)
+			return
+		}
+		writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newOutboxEventOut(event))
+}
+
+func (c *OutboxController) HandleReplayOutboxEvent(w http.ResponseWriter, r *http.Request) {
+	user := requestctx.User(r.Context())
+
+	id, err := idParam(r)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID)
+		return
+	}
+
+	event, err := c.outbox.Replay(r.Context(), id)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		problem.Error(w, r, http.StatusNotFound, problem.CodeOutboxEventNotFound)
+		return
+	case errors.Is(err, models.ErrOutboxEventNotFailed):
+		problem.Error(w, r, http.StatusConflict, problem.CodeOutboxEventNotFailed)
+		return
+	case err != nil:
+		writeError(w, r, err)
+		return
+	}
+
+	logging.Log.InfoContext(r.Context(), "Outbox event replayed",
+		slog.Uint64("event_id", uint64(event.ID)),
+		slog.String("type", event.Type),
+		slog.Uint64("replayed_by", uint64(user.ID)),
+	)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newOutboxEventOut(event))
+}
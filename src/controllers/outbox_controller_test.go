@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+)
+
+func newTestOutbox() *fakeOutbox {
+	return &fakeOutbox{events: map[uint]*models.OutboxEvent{
+		1: {ID: 1, Type: models.WalletCreated, Status: models.OutboxFailed, Attempts: 10, Payload: []byte(`{"id":3}`)},
+		2: {ID: 2, Type: models.WalletUpdated, Status: models.OutboxDelivered, Attempts: 1, Payload: []byte(`{"id":3}`)},
+	}}
+}
+
+func TestReplayOutboxEvent(t *testing.T) {
+	outbox := newTestOutbox()
+	controller := NewOutboxController(outbox)
+	admin := newTestUser(newFakeUsers(), "admin@example.com", "Correct7Horse", ROLE_ADMIN)
+
+	replay := func(id string) *httptest.ResponseRecorder {
+		req := withPathID(httptest.NewRequest(http.MethodPost, "/outbox/"+id+"/replay", nil), id)
+		req = req.WithContext(requestctx.WithUser(req.Context(), admin))
+		rec := httptest.NewRecorder()
+		controller.HandleReplayOutboxEvent(rec, req)
+		return rec
+	}
+
+	rec := replay("1")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	var out outboxEventOut
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if out.Status != models.OutboxPending || out.Attempts != 0 {
+		t.Errorf("Expected the event back in the queue, got %+v", out)
+	}
+	if payload, ok := out.Payload.(map[string]any); !ok || payload["id"] != float64(3) {
+		t.Errorf("Expected the payload as JSON, got %#v", out.Payload)
+	}
+
+	cases := []struct {
+		id     string
+		status int
+		code   problem.Code
+	}{
+		{"2", http.StatusConflict, problem.CodeOutboxEventNotFailed},
+		{"9", http.StatusNotFound, problem.CodeOutboxEventNotFound},
+	}
+	for _, c := range cases {
+		rec := replay(c.id)
+		if rec.Code != c.status {
+			t.Errorf("%s: expected status %d, got %d", c.id, c.status, rec.Code)
+		}
+		if out := decodeProblem(t, rec); out.Code != c.code {
+			t.Errorf("%s: expected code %s, got %s", c.id, c.code, out.Code)
+		}
+	}
+}
+
+func TestListOutboxEventsValidatesQuery(t *testing.T) {
+	controller := NewOutboxController(newTestOutbox())
+
+	rec := httptest.NewRecorder()
+	controller.HandleListOutboxEvents(rec, httptest.NewRequest(http.MethodGet, "/outbox", nil))
+	var events []outboxEventOut
+	if err := json.NewDecoder(rec.Body).Decode(&events); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(events) != 1 || events[0].ID != 1 {
+		t.Errorf("Expected the failed events by default, got %+v", events)
+	}
+
+	rec = httptest.NewRecorder()
+	controller.HandleListOutboxEvents(rec, httptest.NewRequest(http.MethodGet, "/outbox?status=lost&limit=1000", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+	if out := decodeProblem(t, rec); len(out.Errors) != 2 || out.Errors[0].Field != "status" || out.Errors[1].Code != "too_large" {
+		t.Errorf("Expected the status and the limit to be reported, got %+v", out.Errors)
+	}
+}
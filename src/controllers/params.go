@@ -0,0 +1,19 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// idParam returns the id of the resource the request is about, the {id}
+// parameter of the path. The deprecated routes without it pass the id as
+// the ?id= query parameter, which is read instead.
+func idParam(r *http.Request) (uint, error) {
+	idStr := r.PathValue("id")
+	if idStr == "" {
+		idStr = r.URL.Query().Get("id")
+	}
+
+	id, err := strconv.ParseUint(idStr, 10, 0)
+	return uint(id), err
+}
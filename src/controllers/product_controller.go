@@ -2,41 +2,58 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
-	"strconv"
 
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
 	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"gorm.io/gorm"
 )
 
-// Handle 
-func HandleProducts(w http.ResponseWriter, r *http.Request) {
-    switch r.Method {
-    case http.MethodPost:
-        handleCreateProduct(w, r)
-    case http.MethodGet:
-        handleFetchProduct(w, r)
-    case http.MethodPut:
-        handleUpdateProduct(w, r)
-    case http.MethodDelete:
-        handleDeleteProduct(w, r)
-    default:
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-    }
+type productBody struct {
+    Name        *string  `json:"name" validate:"required,notblank,max=120"`
+    Description *string  `json:"description" validate:"omitnil,max=2000"`
+    Price       *float64 `json:"price" validate:"required,gte=0"`
+    Points      *uint    `json:"points"`
+    Category    *string  `json:"category" validate:"omitnil,max=60"`
+    Stock       *uint    `json:"stock"`
+}
+
+// Fields left out are not changed
+type productUpdateBody struct {
+    Name        *string  `json:"name" validate:"omitnil,notblank,max=120"`
+    Description *string  `json:"description" validate:"omitnil,max=2000"`
+    Price       *float64 `json:"price" validate:"omitnil,gte=0"`
+    Points      *uint    `json:"points"`
+    Category    *string  `json:"category" validate:"omitnil,max=60"`
+    Stock       *uint    `json:"stock"`
+}
+
+type ProductController struct {
+    products ProductStore
+}
+
+func NewProductController(products ProductStore) *ProductController {
+    return &ProductController{products: products}
 }
 
-func handleCreateProduct(w http.ResponseWriter, r *http.Request) {
-    _, result := UserAuthFlowLax(w, r, ROLE_ADMIN)
-    if !result {
+func (c *ProductController) HandleCreateProduct(w http.ResponseWriter, r *http.Request) {
+    var productIn productBody
+    if !decodeBody(w, r, &productIn) {
         return
     }
-    var product models.Product
-    if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-        http.Error(w, "Invalid request payload", http.StatusBadRequest)
-        return
+
+    product := models.Product{
+        Name:        productIn.Name,
+        Description: productIn.Description,
+        Price:       productIn.Price,
+        Points:      productIn.Points,
+        Category:    productIn.Category,
+        Stock:       productIn.Stock,
     }
 
-    if err := productService.Create(&product); err != nil {
-        http.Error(w, "Failed to create product", http.StatusInternalServerError)
+    if err := c.products.Create(r.Context(), &product); err != nil {
+        writeError(w, r, err)
         return
     }
     
@@ -44,22 +61,15 @@ func handleCreateProduct(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(product)
 }
 
-func handleFetchProduct(w http.ResponseWriter, r *http.Request) {
-    idStr := r.URL.Query().Get("id")
-    id, err := strconv.Atoi(idStr)
-    if err != nil {
-        http.Error(w, "Invalid product ID", http.StatusBadRequest)
-        return
-    }
-
-    product, err := productService.Fetch(uint(id)) 
+func (c *ProductController) HandleFetchProduct(w http.ResponseWriter, r *http.Request) {
+    id, err := idParam(r)
     if err != nil {
-        http.Error(w, "Failed to fetch product", http.StatusNotFound)
+        problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID)
         return
     }
 
-    if product == nil {
-        http.Error(w, "Produto não encontrado", http.StatusNotFound)
+    product, ok := c.fetchProduct(w, r, id)
+    if !ok {
         return
     }
 
@@ -67,32 +77,20 @@ func handleFetchProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 
-func handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
-    _, result := UserAuthFlowLax(w, r, ROLE_ADMIN)
-    if !result {
-        return
-    }
-    idStr := r.URL.Path[len("/products/"):]
-    id, err := strconv.Atoi(idStr)
+func (c *ProductController) HandleUpdateProduct(w http.ResponseWriter, r *http.Request) {
+    id, err := idParam(r)
     if err != nil {
-        http.Error(w, "Invalid product ID", http.StatusBadRequest)
+        problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID)
         return
     }
 
-    product, err := productService.Fetch(uint(id))
-    if err != nil {
-        http.Error(w, "Failed to find product", http.StatusNotFound)
-        return
-    }
-    
-    if product == nil {
-        http.Error(w, "Produto não encontrado", http.StatusNotFound)
+    product, ok := c.fetchProduct(w, r, id)
+    if !ok {
         return
     }
 
-    var newProduct models.Product
-    if err = json.NewDecoder(r.Body).Decode(&newProduct); err != nil {
-        http.Error(w, "Invalid parameters when updating", http.StatusBadRequest)
+    var newProduct productUpdateBody
+    if !decodeBody(w, r, &newProduct) {
         return
     }
 
@@ -112,42 +110,49 @@ func handleUpdateProduct(w http.ResponseWriter, r *http.Request) {
     if newProduct.Category != nil {
         product.Category = newProduct.Category
     }
+    if newProduct.Stock != nil {
+        product.Stock = newProduct.Stock
+    }
 
-    if err = productService.Update(uint(id), product); err != nil {
-        http.Error(w, "Failed to update product", http.StatusInternalServerError)
+    if err = c.products.Update(r.Context(), id, product); err != nil {
+        writeError(w, r, err)
         return
     }
 
     json.NewEncoder(w).Encode(product)
 }
 
-func handleDeleteProduct(w http.ResponseWriter, r *http.Request) {
-    _, result := UserAuthFlowLax(w, r, ROLE_ADMIN)
-    if !result {
-        return
-    }
-    idStr := r.URL.Path[len("/products/"):]
-    id, err := strconv.Atoi(idStr)
+func (c *ProductController) HandleDeleteProduct(w http.ResponseWriter, r *http.Request) {
+    id, err := idParam(r)
     if err != nil {
-        http.Error(w, "Invalid product ID", http.StatusBadRequest)
+        problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID)
         return
     }
 
-    product, err := productService.Fetch(uint(id))
-    if err != nil {
-        http.Error(w, "Failed to find product", http.StatusNotFound)
-        return
-    }
-    
-    if product == nil {
-        http.Error(w, "Produto não encontrado", http.StatusNotFound)
+    if _, ok := c.fetchProduct(w, r, id); !ok {
         return
     }
 
-    if err = productService.Delete(uint(id)); err != nil {
-        http.Error(w, "Failed to delete product", http.StatusInternalServerError)
+    if err = c.products.Delete(r.Context(), id); err != nil {
+        writeError(w, r, err)
         return
     }
 
     w.WriteHeader(http.StatusOK)
 }
+
+// fetchProduct writes the problem and returns false when the product
+// cannot be fetched
+func (c *ProductController) fetchProduct(w http.ResponseWriter, r *http.Request, id uint) (*models.Product, bool) {
+    product, err := c.products.Fetch(r.Context(), id)
+    if err != nil {
+        if errors.Is(err, gorm.ErrRecordNotFound) {
+            problem.Error(w, r, http.StatusNotFound, problem.CodeProductNotFound)
+            return nil, false
+        }
+        writeError(w, r, err)
+        return nil, false
+    }
+
+    return product, true
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+)
+
+func TestProductLifecycle(t *testing.T) {
+	products := newFakeProducts()
+	controller := NewProductController(products)
+
+	rec := httptest.NewRecorder()
+	body := `{"name": "Mug", "price": 10.5, "points": 3}`
+	controller.HandleCreateProduct(rec, httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+
+	var created models.Product
+	if err := json.NewDecoder(rec.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if created.ID == 0 || created.Name == nil || *created.Name != "Mug" {
+		t.Fatalf("Unexpected product %+v", created)
+	}
+
+	rec = httptest.NewRecorder()
+	controller.HandleUpdateProduct(rec, withPathID(httptest.NewRequest(http.MethodPut, "/products/1", strings.NewReader(`{"price": 12}`)), "1"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	stored, _ := products.Fetch(context.Background(), created.ID)
+	if *stored.Price != 12 || *stored.Name != "Mug" {
+		t.Errorf("Expected only the price to change, got %+v", stored)
+	}
+
+	rec = httptest.NewRecorder()
+	controller.HandleDeleteProduct(rec, withPathID(httptest.NewRequest(http.MethodDelete, "/products/1", nil), "1"))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	controller.HandleFetchProduct(rec, httptest.NewRequest(http.MethodGet, "/products?id=1", nil))
+	out := decodeProblem(t, rec)
+	if rec.Code != http.StatusNotFound || out.Code != problem.CodeProductNotFound {
+		t.Errorf("Expected status %d with %s after delete, got %d with %s", http.StatusNotFound, problem.CodeProductNotFound, rec.Code, out.Code)
+	}
+}
+
+func TestFetchProductDeadlineExceeded(t *testing.T) {
+	products := newFakeProducts()
+	products.Create(context.Background(), &models.Product{})
+	controller := NewProductController(products)
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	// A failing query is not reported as a missing product
+	for name, handle := range map[string]http.HandlerFunc{
+		"fetch":  controller.HandleFetchProduct,
+		"update": controller.HandleUpdateProduct,
+		"delete": controller.HandleDeleteProduct,
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/products?id=1", strings.NewReader(`{"price": 12}`))
+		handle(rec, req.WithContext(ctx))
+
+		out := decodeProblem(t, rec)
+		if rec.Code != http.StatusServiceUnavailable || out.Code != problem.CodeRequestTimeout {
+			t.Errorf("%s: expected status %d with %s, got %d with %s", name, http.StatusServiceUnavailable, problem.CodeRequestTimeout, rec.Code, out.Code)
+		}
+	}
+}
+
+func TestFetchProductInvalidID(t *testing.T) {
+	controller := NewProductController(newFakeProducts())
+
+	rec := httptest.NewRecorder()
+	controller.HandleFetchProduct(rec, httptest.NewRequest(http.MethodGet, "/products?id=abc", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestCreateProductDeadlineExceeded(t *testing.T) {
+	controller := NewProductController(newFakeProducts())
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(`{"name": "Mug", "price": 10.5}`))
+	controller.HandleCreateProduct(rec, req.WithContext(ctx))
+
+	out := decodeProblem(t, rec)
+	if rec.Code != http.StatusServiceUnavailable || out.Code != problem.CodeRequestTimeout {
+		t.Errorf("Expected a 503 %s, got %d %+v", problem.CodeRequestTimeout, rec.Code, out)
+	}
+}
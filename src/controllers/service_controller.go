@@ -1,24 +1,86 @@
 package controllers
 
 import (
-	"fmt"
+	"context"
+	"time"
 
-	"github.com/alexbsec/MiniMarketplace/src/db/config"
 	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/oidc"
 )
 
-var (
-    productService  *models.ProductService
-    userService     *models.UserService
-    walletService   *models.WalletService
-)
+// The controllers depend on these interfaces instead of the models
+// services, so handlers can be tested without a database. Each one only
+// lists the methods the controllers use.
+
+type ProductStore interface {
+    Create(ctx context.Context, product *models.Product) error
+    Fetch(ctx context.Context, id uint) (*models.Product, error)
+    Update(ctx context.Context, id uint, newProduct *models.Product) error
+    Delete(ctx context.Context, id uint) error
+}
+
+type UserStore interface {
+    Create(ctx context.Context, user *models.User) error
+    Fetch(ctx context.Context, id uint) (*models.User, error)
+    Update(ctx context.Context, id uint, newUser *models.User) error
+    Delete(ctx context.Context, id uint) error
+    FetchUserByEmail(ctx context.Context, email *string) (*models.User, error)
+    FetchPassword(ctx context.Context, id uint) (*string, error)
+    FetchEmail(ctx context.Context, id uint) (*string, error)
+    CheckEmailExists(ctx context.Context, email *string) (bool, error)
+}
+
+type WalletStore interface {
+    Create(ctx context.Context, wallet *models.Wallet) error
+    Fetch(ctx context.Context, id uint) (*models.Wallet, error)
+    Update(ctx context.Context, id uint, newWallet *models.Wallet) error
+}
+
+type APIKeyStore interface {
+    Create(ctx context.Context, key *models.APIKey) error
+    Fetch(ctx context.Context, id uint) (*models.APIKey, error)
+    FetchByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+    ListByUser(ctx context.Context, userID uint) ([]models.APIKey, error)
+    UpdateLabel(ctx context.Context, id uint, label string) error
+    Revoke(ctx context.Context, id uint) error
+    Touch(ctx context.Context, id uint, usedAt time.Time) error
+}
 
-func init() {
-	service, err := config.InitService()
-	if err != nil {
-		panic(fmt.Sprintf("Failed to initialize database service: %v", err))
-	}
-    productService = &models.ProductService{Service: service}
-	userService = &models.UserService{Service: service}
-    walletService = &models.WalletService{Service: service}
+type IdentityStore interface {
+    Create(ctx context.Context, identity *models.UserIdentity) error
+    CreateWithUser(ctx context.Context, user *models.User, identity *models.UserIdentity) error
+    FetchBySubject(ctx context.Context, issuer string, subject string) (*models.UserIdentity, error)
 }
+
+type SessionStore interface {
+    Create(ctx context.Context, session *models.Session) error
+    Fetch(ctx context.Context, id uint) (*models.Session, error)
+    FetchBySID(ctx context.Context, sid string) (*models.Session, error)
+    ListActiveByUser(ctx context.Context, userID uint) ([]models.Session, error)
+    Revoke(ctx context.Context, id uint) error
+    Touch(ctx context.Context, id uint, seenAt time.Time) error
+}
+
+type OutboxStore interface {
+    List(ctx context.Context, status string, limit int) ([]models.OutboxEvent, error)
+    Fetch(ctx context.Context, id uint) (*models.OutboxEvent, error)
+    Replay(ctx context.Context, id uint) (*models.OutboxEvent, error)
+}
+
+// OIDCProvider is implemented by *oidc.Provider
+type OIDCProvider interface {
+    AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error)
+    Exchange(ctx context.Context, code string, verifier string) (*oidc.TokenResponse, error)
+    VerifyIDToken(ctx context.Context, rawIDToken string, nonce string) (*oidc.Claims, error)
+}
+
+var (
+    _ ProductStore  = (*models.ProductService)(nil)
+    _ UserStore     = (*models.UserService)(nil)
+    _ WalletStore   = (*models.WalletService)(nil)
+    _ APIKeyStore   = (*models.APIKeyService)(nil)
+    _ IdentityStore = (*models.UserIdentityService)(nil)
+    _ SessionStore  = (*models.SessionService)(nil)
+    _ OutboxStore   = (*models.OutboxService)(nil)
+    _ OIDCProvider  = (*oidc.Provider)(nil)
+)
@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+)
+
+type sessionOut struct {
+	ID         uint      `json:"id"`
+	UserAgent  *string   `json:"user_agent"`
+	IP         *string   `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// True for the session of the token used in the request
+	Current bool `json:"current"`
+}
+
+type SessionController struct {
+	sessions SessionStore
+	auth     *AuthController
+}
+
+func NewSessionController(sessions SessionStore, auth *AuthController) *SessionController {
+	return &SessionController{sessions: sessions, auth: auth}
+}
+
+func (c *SessionController) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	user := requestctx.User(r.Context())
+
+	sessions, err := c.sessions.ListActiveByUser(r.Context(), user.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// Requests made with an API key have no current session
+	var currentSID string
+	if jwtCtt, err := c.auth.parseJWT(r); err == nil {
+		currentSID = jwtCtt.SessionID
+	}
+
+	out := make([]sessionOut, len(sessions))
+	for i, session := range sessions {
+		out[i] = sessionOut{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.SID == currentSID,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func (c *SessionController) HandleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	user := requestctx.User(r.Context())
+
+	id, err := idParam(r)
+	if err != nil {
+		problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID)
+		return
+	}
+
+	session, err := c.sessions.Fetch(r.Context(), id)
+	if err != nil || !canManageSession(user, session) {
+		// Same answer as a missing session, so ids of other users are not leaked
+		problem.Error(w, r, http.StatusNotFound, problem.CodeSessionNotFound)
+		return
+	}
+
+	if err := c.sessions.Revoke(r.Context(), session.ID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	logging.Log.Warn(
+		"Security event: session revoked",
+		slog.String("event", "session_revoked"),
+		slog.Uint64("session_id", uint64(session.ID)),
+		slog.Uint64("user_id", uint64(session.UserID)),
+		slog.Uint64("revoked_by", uint64(user.ID)),
+	)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func canManageSession(user *models.User, session *models.Session) bool {
+	return session.UserID == user.ID || *user.Role == uint(ROLE_ADMIN)
+}
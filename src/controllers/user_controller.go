@@ -1,69 +1,78 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"strconv"
 
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
 	"github.com/alexbsec/MiniMarketplace/src/db/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/alexbsec/MiniMarketplace/src/security"
 )
 
-// This is used to validate password
+// The password rules are checked by the password policy
 type userBody struct {
-	Name            *string `json:"name"`
-	Email           *string `json:"email"`
-	Password        *string `json:"password"`
-	ConfirmPassword *string `json:"confirm_password"`
+	Name            *string `json:"name" validate:"required,notblank,max=100"`
+	Email           *string `json:"email" validate:"required,email,max=254"`
+	Password        *string `json:"password" validate:"required"`
+	ConfirmPassword *string `json:"confirm_password" validate:"required"`
+	Language        *string `json:"language" validate:"omitnil,language"`
 }
 
 type userUpdateBody struct {
-	Name            *string `json:"name"`
-	Email           *string `json:"email"`
+	Name            *string `json:"name" validate:"omitnil,notblank,max=100"`
+	Email           *string `json:"email" validate:"omitnil,email,max=254"`
 	OldPassword     *string `json:"old_password"`
 	NewPassword     *string `json:"new_password"`
 	ConfirmPassword *string `json:"confirm_password"`
+	Language        *string `json:"language" validate:"omitnil,language"`
 }
 
 type userOut struct {
-	ID    uint    `json:"id"`
-	Name  *string `json:"name"`
-	Email *string `json:"email"`
-    Role  *uint   `json:"role"`
+	ID       uint    `json:"id"`
+	Name     *string `json:"name"`
+	Email    *string `json:"email"`
+    Role     *uint   `json:"role"`
+	Language *string `json:"language"`
 }
 
-// Handles
-func HandleUsers(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		handleCreateUser(w, r)
-	case http.MethodGet:
-		handleFetchUser(w, r)
-	case http.MethodPut:
-		handleUpdateUser(w, r)
-	case http.MethodDelete:
-		handleDeleteUser(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
+type UserController struct {
+	users  UserStore
+	policy security.PasswordPolicy
+	hasher security.PasswordHasher
+}
+
+func NewUserController(users UserStore, policy security.PasswordPolicy, hasher security.PasswordHasher) *UserController {
+	return &UserController{users: users, policy: policy, hasher: hasher}
 }
 
-func handleCreateUser(w http.ResponseWriter, r *http.Request) {
+// Handles
+func (c *UserController) HandleCreateUser(w http.ResponseWriter, r *http.Request) {
 	var userIn userBody
-	if err := json.NewDecoder(r.Body).Decode(&userIn); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if p := decodeJSON(r, &userIn); p != nil {
+		problem.Write(w, r, p)
 		return
 	}
 
-	if userIn.Name == nil || userIn.Email == nil || userIn.Password == nil || userIn.ConfirmPassword == nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	// The password policy is reported along with the other fields, so the
+	// client gets every error at once
+	fieldErrs := validateBody(&userIn)
+	if userIn.Password != nil && userIn.ConfirmPassword != nil && userIn.Email != nil {
+		var policyErr *security.PasswordPolicyError
+		if errors.As(c.policy.Validate(*userIn.Password, *userIn.ConfirmPassword, *userIn.Email), &policyErr) {
+			fieldErrs = append(fieldErrs, passwordPolicyProblem("password", policyErr).Errors...)
+		}
+	}
+	if len(fieldErrs) > 0 {
+		problem.Write(w, r, problem.Validation(fieldErrs...))
 		return
 	}
 
-	hash, err := validateAndHashPassword(*userIn.Password, *userIn.ConfirmPassword)
+	hash, err := hashPassword(c.hasher, *userIn.Password)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to validate passwords: %v", err), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
@@ -75,10 +84,11 @@ func handleCreateUser(w http.ResponseWriter, r *http.Request) {
 		Email:    userIn.Email,
 		Password: hash,
         Role:     inRole,
+		Language: userIn.Language,
 	}
 
-	if err := userService.Create(user); err != nil {
-		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+	if err := c.users.Create(r.Context(), user); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -87,31 +97,31 @@ func handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	out.Name = user.Name
 	out.Email = user.Email
     out.Role = user.Role
+	out.Language = user.Language
 
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(out)
 }
 
-func handleFetchUser(w http.ResponseWriter, r *http.Request) { 
-    idStr := r.URL.Query().Get("id")
-    id, err := strconv.Atoi(idStr)
+func (c *UserController) HandleFetchUser(w http.ResponseWriter, r *http.Request) { 
+    id, err := idParam(r)
     if err != nil {
-        http.Error(w, "Invalid user", http.StatusBadRequest)
+        problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID)
         return
     }
 
-    if (!UserAuthFlow(w, r, uint(id), ROLE_USER)) {
+    if !requireSelf(w, r, id) {
         return
     }
 
-	user, err := userService.Fetch(uint(id))
+	user, err := c.users.Fetch(r.Context(), id)
 	if err != nil {
-		http.Error(w, "User does not exist", http.StatusNotFound)
+		problem.Error(w, r, http.StatusNotFound, problem.CodeUserNotFound)
 		return
 	}
 
 	if user == nil {
-		http.Error(w, "User does not exist", http.StatusNotFound)
+		problem.Error(w, r, http.StatusNotFound, problem.CodeUserNotFound)
 		return
 	}
 
@@ -120,113 +130,106 @@ func handleFetchUser(w http.ResponseWriter, r *http.Request) {
 	out.Name = user.Name
 	out.Email = user.Email
     out.Role = user.Role
+	out.Language = user.Language
 
 	json.NewEncoder(w).Encode(out)
 }
 
-func handleUpdateUser(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Path[len("/users/"):]
-	id, err := strconv.Atoi(idStr)
+func (c *UserController) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusNotFound)
+		problem.Error(w, r, http.StatusNotFound, problem.CodeInvalidID)
 		return
 	}
 
-    if (!UserAuthFlow(w, r, uint(id), ROLE_USER)) {
+    if !requireSelf(w, r, id) {
         return
     }
 
-	user, err := userService.Fetch(uint(id))
+	user, err := c.users.Fetch(r.Context(), id)
 	if err != nil {
-		http.Error(w, "Usuário não existe", http.StatusNotFound)
+		problem.Error(w, r, http.StatusNotFound, problem.CodeUserNotFound)
 		return
 	}
 
 	if user == nil {
-		http.Error(w, "Usuário não existe", http.StatusNotFound)
+		problem.Error(w, r, http.StatusNotFound, problem.CodeUserNotFound)
 		return
 	}
 
 	var updatedUser userUpdateBody
-	if err = json.NewDecoder(r.Body).Decode(&updatedUser); err != nil {
-		http.Error(w, "Invalid parameters when updating", http.StatusBadRequest)
+	if !decodeBody(w, r, &updatedUser) {
 		return
 	}
 
-	result, status, err := checkUpdatePasswordFlow(uint(id), &updatedUser)
-	if result != nil || status != http.StatusOK || err != nil {
-		if err != nil && result == nil {
-			// In this case we wont output the error message and say something instead
-			http.Error(w, "Something unexpected happened", status)
-			return
-		} else if err != nil {
-			// Here the result is a message but we got an error
-			http.Error(w, *result, status)
-			return
-		}
-
-		// It means we do not get any errors, so we can go and
-		// update the password
-		user.Password = result
+	hash, err := c.checkUpdatePasswordFlow(r.Context(), id, &updatedUser)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if hash != nil {
+		user.Password = hash
 	}
 
 	if updatedUser.Name != nil {
 		user.Name = updatedUser.Name
 	}
 
+	if updatedUser.Language != nil {
+		user.Language = updatedUser.Language
+	}
+
 	if updatedUser.Email != nil {
-		result, status, err := checkUpdateEmailFlow(&updatedUser)
-		if result == nil && status == http.StatusOK && err == nil {
-			user.Email = updatedUser.Email
-		} else {
-			http.Error(w, *result, status)
+		if err := c.checkUpdateEmailFlow(r.Context(), &updatedUser); err != nil {
+			writeError(w, r, err)
 			return
 		}
+		user.Email = updatedUser.Email
 	}
 
-	if err = userService.Update(uint(id), user); err != nil {
-		http.Error(w, "Falha ao atualizar usuário", http.StatusInternalServerError)
+	if err = c.users.Update(r.Context(), id, user); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	out := &userOut{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
-        Role:  user.Role,
+		ID:       user.ID,
+		Name:     user.Name,
+		Email:    user.Email,
+        Role:     user.Role,
+		Language: user.Language,
 	}
 
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(out)
 }
 
-func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
-	idStr := r.URL.Path[len("/users/"):]
-	id, err := strconv.Atoi(idStr)
+func (c *UserController) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := idParam(r)
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusNotFound)
+		problem.Error(w, r, http.StatusNotFound, problem.CodeInvalidID)
 		return
 	}
     
-    if (!UserAuthFlow(w, r, uint(id), ROLE_USER)) {
+    if !requireSelf(w, r, id) {
         return
     }
 
-	user, err := userService.Fetch(uint(id))
+	user, err := c.users.Fetch(r.Context(), id)
 	if err != nil {
         // This should never be written, but here regardless
-		http.Error(w, "Usuário não existe", http.StatusNotFound)
+		problem.Error(w, r, http.StatusNotFound, problem.CodeUserNotFound)
 		return
 	}
 
 	if user == nil {
         // This should never be written, but here regardless
-		http.Error(w, "Usuário não existe", http.StatusNotFound)
+		problem.Error(w, r, http.StatusNotFound, problem.CodeUserNotFound)
 		return
 	}
 
-	if err = userService.Delete(uint(id)); err != nil {
-		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
+	if err = c.users.Delete(r.Context(), id); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
@@ -235,100 +238,107 @@ func handleDeleteUser(w http.ResponseWriter, r *http.Request) {
 
 
 
-func hashPassword(password string) (*string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
+func hashPassword(hasher security.PasswordHasher, password string) (*string, error) {
+	hash, err := hasher.Hash(password)
 	out := new(string)
-	*out = string(bytes)
+	*out = hash
 	return out, err
 }
 
-func validateAndHashPassword(password string, confirmPassword string) (*string, error) {
-	if password != confirmPassword {
-		return nil, fmt.Errorf("passwords do not match")
+// validateAndHashPassword hashes the password if it meets the password
+// policy, otherwise the violations are returned as a problem of field
+func (c *UserController) validateAndHashPassword(field string, password string, confirmPassword string, email string) (*string, error) {
+	err := c.policy.Validate(password, confirmPassword, email)
+	var policyErr *security.PasswordPolicyError
+	if errors.As(err, &policyErr) {
+		return nil, passwordPolicyProblem(field, policyErr)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	return hashPassword(password)
+	return hashPassword(c.hasher, password)
 }
 
-func verifyPassword(password string, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil
+func (c *UserController) verifyPassword(password string, hash string) bool {
+	match, _, err := c.hasher.Verify(password, hash)
+	return err == nil && match
 }
 
-func checkUpdatePasswordFlow(id uint, updateBody *userUpdateBody) (*string, int, error) {
+// checkUpdatePasswordFlow returns the hash of the new password, or nil
+// when the password is not updated
+func (c *UserController) checkUpdatePasswordFlow(ctx context.Context, id uint, updateBody *userUpdateBody) (*string, error) {
 	if updateBody == nil {
-		errMsg := "Something unexpected happened"
-		return &errMsg, http.StatusBadRequest, fmt.Errorf("updateBody is a nullptr")
+		return nil, fmt.Errorf("updateBody is a nullptr")
 	}
 
     // No update
     if updateBody.OldPassword == nil && updateBody.NewPassword == nil && updateBody.ConfirmPassword == nil {
-        return nil, http.StatusOK, nil
+        return nil, nil
     }
 
 	// If old password is not provided but new/confirm password is, return error
-	if updateBody.OldPassword == nil && (updateBody.NewPassword != nil || updateBody.ConfirmPassword != nil) {
-		errMsg := "Old password is required to update to a new password"
-		return &errMsg, http.StatusBadRequest, fmt.Errorf("Old password is required to update to a new password")
+	if updateBody.OldPassword == nil {
+		return nil, problem.Validation(
+			required("old_password"),
+		)
 	}
 
 	// If old password is provided but one of the new passwords is missing, return error
-	if updateBody.OldPassword != nil && (updateBody.NewPassword == nil || updateBody.ConfirmPassword == nil) {
-		errMsg := "Both 'new_password' and 'confirm_password' are required when updating a password"
-		return &errMsg, http.StatusBadRequest, fmt.Errorf("Fields are required")
+	if updateBody.NewPassword == nil || updateBody.ConfirmPassword == nil {
+		var fieldErrs []problem.FieldError
+		if updateBody.NewPassword == nil {
+			fieldErrs = append(fieldErrs, required("new_password"))
+		}
+		if updateBody.ConfirmPassword == nil {
+			fieldErrs = append(fieldErrs, required("confirm_password"))
+		}
+		return nil, problem.Validation(
+			fieldErrs...,
+		)
 	}
 
-	// Handle the password update flow
-	if updateBody.OldPassword != nil && updateBody.NewPassword != nil && updateBody.ConfirmPassword != nil {
-		// Fetch the user's current hashed password
-		oldHash, err := userService.FetchPassword(id)
-		if err != nil {
-			errMsg := "Failed to fetch user password"
-			return &errMsg, http.StatusInternalServerError, err
-		}
+	// Fetch the user's current hashed password
+	oldHash, err := c.users.FetchPassword(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch user password: %w", err)
+	}
 
-		// Check if the old password matches
-		if oldHash == nil || !verifyPassword(*updateBody.OldPassword, *oldHash) {
-			errMsg := "Old password does not match our records"
-			return &errMsg, http.StatusBadRequest, fmt.Errorf("Old password does not match our records")
-		}
+	// Check if the old password matches
+	if oldHash == nil || !c.verifyPassword(*updateBody.OldPassword, *oldHash) {
+		return nil, problem.New(http.StatusBadRequest, problem.CodeWrongPassword)
+	}
 
-		// Validate and hash the new password
-		newHash, err := validateAndHashPassword(*updateBody.NewPassword, *updateBody.ConfirmPassword)
+	// The password must not match the current nor the new e-mail
+	email := updateBody.Email
+	if email == nil {
+		email, err = c.users.FetchEmail(ctx, id)
 		if err != nil {
-			errMsg := "New passwords do not match"
-			return &errMsg, http.StatusBadRequest, fmt.Errorf("New passwords do not match")
+			return nil, fmt.Errorf("failed to fetch user e-mail: %w", err)
 		}
-
-		// Return the new password hash if everything is valid
-		return newHash, http.StatusOK, nil
 	}
 
-	// This should never be reached if the input validation is correct
-	errMsg := "Unexpected password update flow"
-	return &errMsg, http.StatusInternalServerError, fmt.Errorf("unexpected password update flow")
+	// Validate and hash the new password, policy errors are the client's
+	return c.validateAndHashPassword("new_password", *updateBody.NewPassword, *updateBody.ConfirmPassword, *email)
 }
 
-func checkUpdateEmailFlow(updateBody *userUpdateBody) (*string, int, error) {
+func (c *UserController) checkUpdateEmailFlow(ctx context.Context, updateBody *userUpdateBody) error {
 	if updateBody == nil {
-		return nil, http.StatusBadRequest, fmt.Errorf("updateBody is a nullptr")
+		return fmt.Errorf("updateBody is a nullptr")
 	}
 
 	if updateBody.Email == nil {
-		return nil, http.StatusOK, nil
+		return nil
 	}
 
-	userExists, err := userService.CheckEmailExists(updateBody.Email)
+	userExists, err := c.users.CheckEmailExists(ctx, updateBody.Email)
 	if err != nil {
-		errMsg := "Something unexpected happen"
-		return &errMsg, http.StatusInternalServerError, err
+		return err
 	}
 
 	if userExists {
-		errMsg := "Impossível alterar email pois email já existe"
-		return &errMsg, http.StatusBadRequest, fmt.Errorf("Failed to update")
+		return problem.New(http.StatusConflict, problem.CodeEmailTaken)
 	}
 
-	return nil, http.StatusOK, nil
+	return nil
 }
-
@@ -0,0 +1,106 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	"github.com/alexbsec/MiniMarketplace/src/security"
+)
+
+func TestCreateUserHashesPassword(t *testing.T) {
+	users := newFakeUsers()
+	controller := NewUserController(users, security.DefaultPasswordPolicy, testHasher)
+
+	body := `{"name": "Carol", "email": "carol@example.com", "password": "Correct7Horse!", "confirm_password": "Correct7Horse!"}`
+	rec := httptest.NewRecorder()
+	controller.HandleCreateUser(rec, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+
+	stored, err := users.Fetch(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("Expected the user to be stored, got %v", err)
+	}
+	if *stored.Password == "Correct7Horse!" {
+		t.Fatal("Expected the password to be hashed")
+	}
+	if match, _, _ := testHasher.Verify("Correct7Horse!", *stored.Password); !match {
+		t.Error("Expected the stored hash to match the password")
+	}
+	if strings.Contains(rec.Body.String(), "password") {
+		t.Errorf("Expected the response not to carry the password, got %s", rec.Body)
+	}
+}
+
+func TestCreateUserPolicyViolations(t *testing.T) {
+	users := newFakeUsers()
+	controller := NewUserController(users, security.DefaultPasswordPolicy, testHasher)
+
+	body := `{"name": "Dave", "email": "dave@example.com", "password": "short", "confirm_password": "short"}`
+	rec := httptest.NewRecorder()
+	controller.HandleCreateUser(rec, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != problem.ContentType {
+		t.Errorf("Expected content type %q, got %q", problem.ContentType, ct)
+	}
+
+	var out problem.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil || len(out.Errors) == 0 {
+		t.Fatalf("Expected the violated rules in the response, got %v", err)
+	}
+	if out.Code != problem.CodeValidationFailed || out.Errors[0].Field != "password" || out.Errors[0].Code != security.RuleMinLength {
+		t.Errorf("Expected the rules as errors of the password field, got %+v", out)
+	}
+	if len(users.users) != 0 {
+		t.Error("Expected no user to be created")
+	}
+}
+
+func TestFetchUserRequiresSelf(t *testing.T) {
+	users := newFakeUsers()
+	controller := NewUserController(users, security.DefaultPasswordPolicy, testHasher)
+	alice := newTestUser(users, "alice@example.com", "Correct7Horse", ROLE_USER)
+	newTestUser(users, "bob@example.com", "Correct7Horse", ROLE_USER)
+
+	req := httptest.NewRequest(http.MethodGet, "/users?id=2", nil)
+	req = req.WithContext(requestctx.WithUser(req.Context(), alice))
+
+	rec := httptest.NewRecorder()
+	controller.HandleFetchUser(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestUpdateUserPolicyViolationsOfNewPassword(t *testing.T) {
+	users := newFakeUsers()
+	controller := NewUserController(users, security.DefaultPasswordPolicy, testHasher)
+	alice := newTestUser(users, "alice@example.com", "Correct7Horse", ROLE_USER)
+
+	body := `{"old_password": "Correct7Horse", "new_password": "short", "confirm_password": "short"}`
+	req := httptest.NewRequest(http.MethodPut, "/users?id=1", strings.NewReader(body))
+	req = req.WithContext(requestctx.WithUser(req.Context(), alice))
+
+	rec := httptest.NewRecorder()
+	controller.HandleUpdateUser(rec, req)
+
+	out := decodeProblem(t, rec)
+	if rec.Code != http.StatusBadRequest || out.Code != problem.CodeValidationFailed || len(out.Errors) == 0 {
+		t.Fatalf("Expected the violated rules, got %d: %+v", rec.Code, out)
+	}
+	for _, fieldErr := range out.Errors {
+		if fieldErr.Field != "new_password" {
+			t.Errorf("Expected the rules as errors of the new_password field, got %+v", fieldErr)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/i18n"
+	"github.com/alexbsec/MiniMarketplace/src/security"
+	"github.com/go-playground/validator/v10"
+)
+
+// validate checks the `validate` tags of the request bodies. Besides the
+// builtin rules of the validator it knows:
+//
+//	notblank  string with something other than spaces
+//	scope     well formed api key scope, e.g. products:read
+//	future    time after now
+//	language  supported language, rewritten to its canonical form
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Fields are named as the clients send them
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+
+	v.RegisterValidation("notblank", func(fl validator.FieldLevel) bool {
+		return strings.TrimSpace(fl.Field().String()) != ""
+	})
+	v.RegisterValidation("scope", func(fl validator.FieldLevel) bool {
+		return security.ValidScope(fl.Field().String())
+	})
+	v.RegisterValidation("future", func(fl validator.FieldLevel) bool {
+		t, ok := fl.Field().Interface().(time.Time)
+		return ok && t.After(time.Now())
+	})
+	v.RegisterValidation("language", func(fl validator.FieldLevel) bool {
+		canonical, ok := i18n.Canonical(fl.Field().String())
+		if ok && fl.Field().CanSet() {
+			fl.Field().SetString(canonical)
+		}
+		return ok
+	})
+
+	return v
+}
+
+// decodeBody decodes the JSON body of the request into dst and checks its
+// rules. Unknown fields are rejected. On failure the problem is written,
+// with every invalid field, and false is returned.
+func decodeBody(w http.ResponseWriter, r *http.Request, dst any) bool {
+	if p := decodeJSON(r, dst); p != nil {
+		problem.Write(w, r, p)
+		return false
+	}
+
+	if fieldErrs := validateBody(dst); len(fieldErrs) > 0 {
+		problem.Write(w, r, problem.Validation(fieldErrs...))
+		return false
+	}
+
+	return true
+}
+
+// decodeJSON decodes the body into dst, returning the problem of a body
+// that is not JSON of the expected shape
+func decodeJSON(r *http.Request, dst any) *problem.Problem {
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+
+	err := decoder.Decode(dst)
+	if err == nil {
+		// A single value is expected
+		if decoder.More() {
+			return problem.New(http.StatusBadRequest, problem.CodeInvalidPayload)
+		}
+		return nil
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return problem.New(http.StatusRequestEntityTooLarge, problem.CodePayloadTooLarge)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return problem.Validation(problem.FieldError{
+			Field: typeErr.Field,
+			Code:  "invalid_type",
+		})
+	}
+
+	// The decoder has no typed error for unknown fields
+	if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		return problem.Validation(problem.FieldError{
+			Field: strings.Trim(field, `"`),
+			Code:  "unknown_field",
+		})
+	}
+
+	return problem.New(http.StatusBadRequest, problem.CodeInvalidPayload)
+}
+
+// validateBody returns an error for each rule of dst that is broken
+func validateBody(dst any) []problem.FieldError {
+	err := validate.Struct(dst)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		// Only happens when dst is not a struct, a bug of the handler
+		panic(err)
+	}
+
+	fieldErrs := make([]problem.FieldError, len(validationErrs))
+	for i, validationErr := range validationErrs {
+		fieldErrs[i] = fieldError(validationErr)
+	}
+	return fieldErrs
+}
+
+// fieldError translates a broken rule to the error sent to the client. The
+// codes have an entry "field.<code>" in the i18n catalog.
+func fieldError(err validator.FieldError) problem.FieldError {
+	// Namespace is "<struct>.<field>[index]...", the struct is left out
+	_, field, _ := strings.Cut(err.Namespace(), ".")
+	param := err.Param()
+
+	isString := err.Kind() == reflect.String
+	switch err.Tag() {
+	case "required", "required_without", "required_with", "notblank":
+		return required(field)
+	case "min", "gte":
+		if isString {
+			return problem.FieldError{Field: field, Code: "too_short", Args: []any{param}}
+		}
+		return problem.FieldError{Field: field, Code: "too_small", Args: []any{param}}
+	case "max", "lte":
+		if isString {
+			return problem.FieldError{Field: field, Code: "too_long", Args: []any{param}}
+		}
+		return problem.FieldError{Field: field, Code: "too_large", Args: []any{param}}
+	case "email":
+		return problem.FieldError{Field: field, Code: "invalid_email"}
+	case "oneof":
+		return problem.FieldError{Field: field, Code: "not_one_of", Args: []any{strings.Join(strings.Fields(param), ", ")}}
+	case "ip":
+		return problem.FieldError{Field: field, Code: "invalid_ip"}
+	case "scope":
+		return problem.FieldError{Field: field, Code: "invalid_scope", Args: []any{err.Value()}}
+	case "future":
+		return problem.FieldError{Field: field, Code: "not_in_future"}
+	case "language":
+		return problem.FieldError{Field: field, Code: "unsupported_language", Args: []any{err.Value()}}
+	}
+
+	return problem.FieldError{Field: field, Code: "invalid"}
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/security"
+)
+
+func decodeProblem(t *testing.T, rec *httptest.ResponseRecorder) problem.Problem {
+	t.Helper()
+
+	var out problem.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("Failed to decode problem: %v", err)
+	}
+	return out
+}
+
+func TestValidationRejectsUnknownFields(t *testing.T) {
+	controller := NewProductController(newFakeProducts())
+
+	rec := httptest.NewRecorder()
+	body := `{"name": "Mug", "price": 10, "id": 99}`
+	controller.HandleCreateProduct(rec, httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	out := decodeProblem(t, rec)
+	if len(out.Errors) != 1 || out.Errors[0].Field != "id" || out.Errors[0].Code != "unknown_field" {
+		t.Errorf("Expected the unknown field to be reported, got %+v", out.Errors)
+	}
+}
+
+func TestValidationReportsEveryViolation(t *testing.T) {
+	users := newFakeUsers()
+	controller := NewUserController(users, security.DefaultPasswordPolicy, testHasher)
+
+	body := `{"name": "  ", "email": "not an e-mail", "password": "short", "confirm_password": "short", "language": "fr"}`
+	rec := httptest.NewRecorder()
+	controller.HandleCreateUser(rec, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	got := make(map[string]string)
+	for _, fieldErr := range decodeProblem(t, rec).Errors {
+		if _, ok := got[fieldErr.Field]; !ok {
+			got[fieldErr.Field] = fieldErr.Code
+		}
+	}
+
+	want := map[string]string{
+		"name":     "required",
+		"email":    "invalid_email",
+		"language": "unsupported_language",
+		"password": security.RuleMinLength,
+	}
+	for field, code := range want {
+		if got[field] != code {
+			t.Errorf("Expected %s to fail with %q, got %q", field, code, got[field])
+		}
+	}
+	if len(users.users) != 0 {
+		t.Error("Expected no user to be created")
+	}
+}
+
+func TestValidationRanges(t *testing.T) {
+	controller := NewProductController(newFakeProducts())
+
+	rec := httptest.NewRecorder()
+	body := `{"price": -1, "category": "` + strings.Repeat("x", 61) + `"}`
+	controller.HandleCreateProduct(rec, httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(body)))
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	out := decodeProblem(t, rec)
+	want := []problem.FieldError{
+		{Field: "name", Code: "required", Message: "name is required"},
+		{Field: "price", Code: "too_small", Message: "Must be at least 0"},
+		{Field: "category", Code: "too_long", Message: "Must have at most 60 characters"},
+	}
+	if len(out.Errors) != len(want) {
+		t.Fatalf("Expected %d errors, got %+v", len(want), out.Errors)
+	}
+	for i := range want {
+		got := out.Errors[i]
+		if got.Field != want[i].Field || got.Code != want[i].Code || got.Message != want[i].Message {
+			t.Errorf("Expected %+v, got %+v", want[i], got)
+		}
+	}
+}
+
+func TestValidationTypeAndSize(t *testing.T) {
+	controller := NewProductController(newFakeProducts())
+
+	rec := httptest.NewRecorder()
+	controller.HandleCreateProduct(rec, httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(`{"name": "Mug", "price": "ten"}`)))
+	if out := decodeProblem(t, rec); len(out.Errors) != 1 || out.Errors[0].Field != "price" || out.Errors[0].Code != "invalid_type" {
+		t.Errorf("Expected a type error on price, got %+v", out)
+	}
+
+	rec = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/products", strings.NewReader(`{"name": "`+strings.Repeat("x", 64)+`"}`))
+	req.Body = http.MaxBytesReader(rec, req.Body, 16)
+	controller.HandleCreateProduct(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+}
+
+func TestValidationCanonicalizesLanguage(t *testing.T) {
+	users := newFakeUsers()
+	controller := NewUserController(users, security.DefaultPasswordPolicy, testHasher)
+
+	body := `{"name": "Erin", "email": "erin@example.com", "password": "Correct7Horse!", "confirm_password": "Correct7Horse!", "language": "pt-br"}`
+	rec := httptest.NewRecorder()
+	controller.HandleCreateUser(rec, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(body)))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusCreated, rec.Code, rec.Body)
+	}
+
+	stored, _ := users.Fetch(context.Background(), 1)
+	if stored.Language == nil || *stored.Language != "pt-BR" {
+		t.Errorf("Expected language pt-BR, got %v", stored.Language)
+	}
+}
@@ -3,9 +3,11 @@ package controllers
 import (
 	"encoding/json"
 	"net/http"
-	"strconv"
 
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
 	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/metrics"
 )
 
 type walletOut struct {
@@ -16,45 +18,54 @@ type walletOut struct {
     UserID  uint
 }
 
-func HandleWallets(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodPost:
-		handleCreateWallet(w, r)
-    case http.MethodGet:
-        handleFetchWallet(w, r)
-    case http.MethodPut:
-        handleUpdateWallet(w, r)
-	default:
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	}
+type walletBody struct {
+    Name   *string  `json:"name" validate:"required,notblank,max=100"`
+    Amount *float64 `json:"amount" validate:"required,gte=0"`
+    Points *float64 `json:"points" validate:"required,gte=0"`
+    // Only read for admins
+    UserID uint `json:"user_id"`
 }
 
-func handleCreateWallet(w http.ResponseWriter, r *http.Request) {
-    var wallet models.Wallet
-    if err := json.NewDecoder(r.Body).Decode(&wallet); err != nil {
-        http.Error(w, "Invalid request payload", http.StatusBadRequest)
-        return
-    }
+// Amount and points are only changed by admins
+type walletUpdateBody struct {
+    Name   *string  `json:"name" validate:"omitnil,notblank,max=100"`
+    Amount *float64 `json:"amount" validate:"omitnil,gte=0"`
+    Points *float64 `json:"points" validate:"omitnil,gte=0"`
+    UserID uint     `json:"user_id"`
+}
+
+type WalletController struct {
+    wallets WalletStore
+    users   UserStore
+}
+
+func NewWalletController(wallets WalletStore, users UserStore) *WalletController {
+    return &WalletController{wallets: wallets, users: users}
+}
 
-    // These fields must not be empty
-    if wallet.Name == nil || wallet.Amount == nil || wallet.Points == nil {
-        http.Error(w, "Invalid request paylaod", http.StatusBadRequest)
+func (c *WalletController) HandleCreateWallet(w http.ResponseWriter, r *http.Request) {
+    var walletIn walletBody
+    if !decodeBody(w, r, &walletIn) {
         return
     }
 
-    // Authentication step
-    user, result := UserAuthFlowLax(w, r, ROLE_USER)
-    if !result {
-        return
+    wallet := models.Wallet{
+        Name:   walletIn.Name,
+        Amount: walletIn.Amount,
+        Points: walletIn.Points,
+        UserID: walletIn.UserID,
     }
 
+    // Set by the auth middleware of the route
+    user := requestctx.User(r.Context())
+
     // If user is not admin, the parameter 'user_id' must
     // not be parsed
     if *user.Role == uint(ROLE_ADMIN) {
         // Just assigns the User correctly
-        assignedUser, err := userService.Fetch(wallet.UserID)
+        assignedUser, err := c.users.Fetch(r.Context(), wallet.UserID)
         if err != nil {
-            http.Error(w, "User does not exist", http.StatusBadRequest)
+            problem.Error(w, r, http.StatusBadRequest, problem.CodeUserNotFound)
             return
         }
         wallet.User = *assignedUser
@@ -73,10 +84,11 @@ func handleCreateWallet(w http.ResponseWriter, r *http.Request) {
         } 
     }
 
-    if err := walletService.Create(&wallet); err != nil {
-        http.Error(w, "Failed to create wallet", http.StatusInternalServerError)
+    if err := c.wallets.Create(r.Context(), &wallet); err != nil {
+        writeError(w, r, err)
         return
     }
+    metrics.WalletMovement(*wallet.Amount)
 
     var out walletOut
     out.ID = wallet.ID
@@ -89,27 +101,23 @@ func handleCreateWallet(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(out)
 }
 
-func handleFetchWallet(w http.ResponseWriter, r *http.Request) {
-    idStr := r.URL.Query().Get("id")
-    id, err := strconv.Atoi(idStr)
+func (c *WalletController) HandleFetchWallet(w http.ResponseWriter, r *http.Request) {
+    id, err := idParam(r)
     if err != nil {
-        http.Error(w, "Invalid wallet", http.StatusBadRequest) 
+        problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID) 
         return
     }
 
-    user, result := UserAuthFlowLax(w, r, ROLE_USER)
-    if !result {
-        return
-    }
+    user := requestctx.User(r.Context())
 
-    wallet, err := walletService.Fetch(uint(id))
+    wallet, err := c.wallets.Fetch(r.Context(), id)
     if err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
         return
     }
 
     if *user.Role != uint(ROLE_ADMIN) && wallet.UserID != user.ID {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
         return
     }
 
@@ -124,41 +132,41 @@ func handleFetchWallet(w http.ResponseWriter, r *http.Request) {
     json.NewEncoder(w).Encode(out)
 }
 
-func handleUpdateWallet(w http.ResponseWriter, r *http.Request) {
-    idStr := r.URL.Query().Get("id")
-    id, err := strconv.Atoi(idStr)
+func (c *WalletController) HandleUpdateWallet(w http.ResponseWriter, r *http.Request) {
+    id, err := idParam(r)
     if err != nil {
-        http.Error(w, "Invalid wallet", http.StatusBadRequest) 
+        problem.Error(w, r, http.StatusBadRequest, problem.CodeInvalidID) 
         return
     }
 
-    user, result := UserAuthFlowLax(w, r, ROLE_USER)
-    if !result {
-        return
-    }
+    user := requestctx.User(r.Context())
 
-    var newWallet models.Wallet
-    if err := json.NewDecoder(r.Body).Decode(&newWallet); err != nil {
-        http.Error(w, "Invalid request payload", http.StatusBadRequest)
+    var newWallet walletUpdateBody
+    if !decodeBody(w, r, &newWallet) {
         return
     }
 
-    wallet, err := walletService.Fetch(uint(id))
+    wallet, err := c.wallets.Fetch(r.Context(), id)
     if err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
         return
     }
 
     if newWallet.UserID != wallet.UserID {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
         return
     }
 
     if *user.Role != uint(ROLE_ADMIN) && wallet.UserID != user.ID {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+        problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
         return
     }
 
+    var oldAmount float64
+    if wallet.Amount != nil {
+        oldAmount = *wallet.Amount
+    }
+
     // Only allow amount and points update if request is made by admin
     if *user.Role == uint(ROLE_ADMIN) {
         if newWallet.Amount != nil {
@@ -175,10 +183,13 @@ func handleUpdateWallet(w http.ResponseWriter, r *http.Request) {
     }
 
 
-    if err := walletService.Update(uint(id), wallet); err != nil {
-        http.Error(w, "Unauthorized", http.StatusUnauthorized)
+    if err := c.wallets.Update(r.Context(), id, wallet); err != nil {
+        writeError(w, r, err)
         return
     }
+    if wallet.Amount != nil {
+        metrics.WalletMovement(*wallet.Amount - oldAmount)
+    }
 
     var out walletOut
     out.ID = wallet.ID
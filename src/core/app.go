@@ -1,39 +1,339 @@
 package app
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/alexbsec/MiniMarketplace/src/config"
 	"github.com/alexbsec/MiniMarketplace/src/controllers"
+	dbconfig "github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/metrics"
+	"github.com/alexbsec/MiniMarketplace/src/oidc"
+	"github.com/alexbsec/MiniMarketplace/src/openapi"
+	"github.com/alexbsec/MiniMarketplace/src/security"
 )
 
+// Request bodies larger than this are rejected
+const maxBodySize = 1 << 20
+
+// The paths from before /api/v1 are aliases of its routes until Sunset.
+// So are the routes taking the id as a query parameter, e.g.
+// GET /products?id=1, replaced by /api/v1/products/{id}.
+var unversionedDeprecation = Deprecation{
+    Since:  time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+    Sunset: time.Date(2027, time.April, 19, 0, 0, 0, 0, time.UTC),
+}
 
 type App struct {
-    Router *http.ServeMux
+    Router  *Router
+    HTTP    config.HTTPConfig
+    Metrics config.MetricsConfig
+    handler http.Handler
+    closers []func(context.Context) error
+    probes  []Probe
+    // Set once a shutdown starts, /readyz fails from then on
+    draining atomic.Bool
+    started  time.Time
+    // The OpenAPI document, encoded once the routes are registered
+    openAPI  []byte
+
+    auth     *controllers.AuthController
+    products *controllers.ProductController
+    users    *controllers.UserController
+    wallets  *controllers.WalletController
+    apiKeys  *controllers.APIKeyController
+    sessions *controllers.SessionController
+    oidc     *controllers.OIDCController
+    outbox   *controllers.OutboxController
+}
+
+// New wires the controllers to the services on top of the database
+func New(cfg *config.AppConfig, service *dbconfig.Service) (*App, error) {
+    passwordPolicy, err := security.NewPasswordPolicy(cfg.Password)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load password policy: %w", err)
+    }
+
+    passwordHasher, err := security.NewPasswordHasher(cfg.Password.Hash)
+    if err != nil {
+        return nil, fmt.Errorf("failed to load password hasher: %w", err)
+    }
+
+    // Left nil when disabled, a nil *oidc.Provider would not be a nil interface
+    var oidcProvider controllers.OIDCProvider
+    if cfg.OIDC.Enabled() {
+        oidcProvider = oidc.NewProvider(oidc.ConfigOf(cfg.OIDC), nil)
+    }
+
+    userService := &models.UserService{Service: service}
+    apiKeyService := &models.APIKeyService{Service: service}
+    sessionService := &models.SessionService{Service: service}
+    loginThrottle := &models.PostgresLoginThrottle{
+        Service: service,
+        Policy: models.ThrottlePolicy{
+            MaxFailures:     uint(cfg.Throttle.MaxFailures),
+            BaseDelay:       cfg.Throttle.BaseDelay,
+            MaxDelay:        cfg.Throttle.MaxDelay,
+            LockoutDuration: cfg.Throttle.Lockout,
+            Window:          cfg.Throttle.Window,
+        },
+        // Never locked, see models.DefaultAddressThrottlePolicy
+        AddressPolicy: models.ThrottlePolicy{
+            FreeFailures: uint(cfg.Throttle.AddressFailures),
+            BaseDelay:    cfg.Throttle.BaseDelay,
+            MaxDelay:     cfg.Throttle.MaxDelay,
+            Window:       cfg.Throttle.Window,
+        },
+    }
+
+    app := &App{HTTP: cfg.HTTP, Metrics: cfg.Metrics, started: time.Now()}
+    app.AddProbe(PostgresProbe(service))
+    app.AddProbe(MigrationsProbe(service))
+
+    if cfg.Metrics.Enabled {
+        db, err := service.Db()
+        if err != nil {
+            return nil, err
+        }
+        sqlDB, err := db.DB()
+        if err != nil {
+            return nil, err
+        }
+        if err := metrics.RegisterDB(sqlDB, cfg.Database.Name); err != nil {
+            return nil, fmt.Errorf("failed to register database metrics: %w", err)
+        }
+    }
+
+    app.auth = controllers.NewAuthController(
+        userService,
+        apiKeyService,
+        sessionService,
+        loginThrottle,
+        passwordHasher,
+        []byte(cfg.Auth.JWTSecret),
+    )
+    app.products = controllers.NewProductController(&models.ProductService{Service: service})
+    app.users = controllers.NewUserController(userService, passwordPolicy, passwordHasher)
+    app.wallets = controllers.NewWalletController(&models.WalletService{Service: service}, userService)
+    app.apiKeys = controllers.NewAPIKeyController(apiKeyService, userService)
+    app.sessions = controllers.NewSessionController(sessionService, app.auth)
+    app.oidc = controllers.NewOIDCController(
+        oidcProvider,
+        &models.UserIdentityService{Service: service},
+        userService,
+        app.auth,
+        cfg.Auth.SecureCookies,
+    )
+    app.outbox = controllers.NewOutboxController(&models.OutboxService{Service: service})
+
+    app.initialize()
+    return app, nil
+}
+
+// OnShutdown registers a function releasing a resource once the server
+// stopped serving requests. They run in the reverse order of registration.
+func (app *App) OnShutdown(closer func(context.Context) error) {
+    app.closers = append(app.closers, closer)
+}
+
+// Group returns a route group registering on the app router
+func (app *App) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+    return app.Router.Group(prefix, middlewares...)
+}
+
+// apiGroups are the groups of a version of the API, by who may call the
+// routes
+type apiGroups struct {
+    public *RouteGroup
+    users  *RouteGroup
+    admins *RouteGroup
+}
+
+func (app *App) apiGroups(base *RouteGroup) apiGroups {
+    return apiGroups{
+        public: base,
+        users:  base.Group("", Auth(app.auth.Authenticator(controllers.ROLE_USER))),
+        admins: base.Group("", Auth(app.auth.Authenticator(controllers.ROLE_ADMIN))),
+    }
 }
 
 func (app *App) initializeRoutes() {
-    app.Router.HandleFunc("/products", controllers.HandleProducts)
-    app.Router.HandleFunc("/products/", controllers.HandleProducts)
+    // Operational routes are not part of the versioned API
+    ops := app.apiGroups(app.Group(""))
+    ops.public.HandleFunc("GET /healthz", app.handleHealthz)
+    ops.public.HandleFunc("GET /readyz", app.handleReadyz)
+    ops.admins.HandleFunc("GET /admin/status", app.handleStatus)
+    if app.Metrics.Enabled {
+        ops.public.Handle("GET /metrics", metrics.Handler(), RequireToken(app.Metrics.Token))
+    }
+    ops.public.HandleFunc("GET /openapi.json", app.handleOpenAPI)
+    ops.public.Handle("GET /docs", openapi.DocsHandler("/openapi.json"))
+
+    // A new version gets its own group, e.g. /api/v2, with the handlers
+    // of its own while v1 keeps being served
+    app.initializeV1(app.apiGroups(app.Group("/api/v1")))
+
+    unversioned := app.apiGroups(app.Group("").Deprecated(unversionedDeprecation))
+    app.initializeV1(unversioned)
+    app.initializeLegacyRoutes(unversioned)
+}
 
-    app.Router.HandleFunc("/users", controllers.HandleUsers)
-    app.Router.HandleFunc("/users/", controllers.HandleUsers)
+func (app *App) initializeV1(api apiGroups) {
+    api.public.HandleFunc("GET /products/{id:uint}", app.products.HandleFetchProduct)
+    api.admins.HandleFunc("POST /products", app.products.HandleCreateProduct)
+    api.admins.HandleFunc("PUT /products/{id:uint}", app.products.HandleUpdateProduct)
+    api.admins.HandleFunc("DELETE /products/{id:uint}", app.products.HandleDeleteProduct)
 
-    app.Router.HandleFunc("/login", controllers.HandleLogin)
+    api.public.HandleFunc("POST /users", app.users.HandleCreateUser)
+    api.users.HandleFunc("GET /users/{id:uint}", app.users.HandleFetchUser)
+    api.users.HandleFunc("PUT /users/{id:uint}", app.users.HandleUpdateUser)
+    api.users.HandleFunc("DELETE /users/{id:uint}", app.users.HandleDeleteUser)
 
-    app.Router.HandleFunc("/wallets", controllers.HandleWallets)
-    app.Router.HandleFunc("/wallets/", controllers.HandleWallets)
+    api.public.HandleFunc("POST /login", app.auth.HandleLoginUser)
+    api.admins.HandleFunc("POST /login/unlock", app.auth.HandleUnlockLogin)
+    api.public.HandleFunc("GET /oidc/login", app.oidc.HandleOIDCLogin)
+    api.public.HandleFunc("GET /oidc/callback", app.oidc.HandleOIDCCallback)
+
+    api.users.HandleFunc("POST /wallets", app.wallets.HandleCreateWallet)
+    api.users.HandleFunc("GET /wallets/{id:uint}", app.wallets.HandleFetchWallet)
+    api.users.HandleFunc("PUT /wallets/{id:uint}", app.wallets.HandleUpdateWallet)
+
+    api.users.HandleFunc("POST /api-keys", app.apiKeys.HandleCreateAPIKey)
+    api.users.HandleFunc("GET /api-keys", app.apiKeys.HandleListAPIKeys)
+    api.users.HandleFunc("PUT /api-keys/{id:uint}", app.apiKeys.HandleUpdateAPIKey)
+    api.users.HandleFunc("DELETE /api-keys/{id:uint}", app.apiKeys.HandleRevokeAPIKey)
+
+    api.users.HandleFunc("GET /sessions", app.sessions.HandleListSessions)
+    api.users.HandleFunc("DELETE /sessions/{id:uint}", app.sessions.HandleRevokeSession)
+
+    api.admins.HandleFunc("GET /outbox", app.outbox.HandleListOutboxEvents)
+    api.admins.HandleFunc("GET /outbox/{id:uint}", app.outbox.HandleFetchOutboxEvent)
+    api.admins.HandleFunc("POST /outbox/{id:uint}/replay", app.outbox.HandleReplayOutboxEvent)
+}
+
+// initializeLegacyRoutes keeps the routes from before the path parameters
+// working for the clients that did not move yet. The handlers read the id
+// from the query when the path has none.
+func (app *App) initializeLegacyRoutes(api apiGroups) {
+    api.public.HandleFunc("GET /products", app.products.HandleFetchProduct)
+    api.public.HandleFunc("GET /products/{$}", app.products.HandleFetchProduct)
+    api.admins.HandleFunc("POST /products/{$}", app.products.HandleCreateProduct)
+
+    api.public.HandleFunc("POST /users/{$}", app.users.HandleCreateUser)
+    api.users.HandleFunc("GET /users", app.users.HandleFetchUser)
+    api.users.HandleFunc("GET /users/{$}", app.users.HandleFetchUser)
+
+    api.users.HandleFunc("POST /wallets/{$}", app.wallets.HandleCreateWallet)
+    api.users.HandleFunc("GET /wallets", app.wallets.HandleFetchWallet)
+    api.users.HandleFunc("GET /wallets/{$}", app.wallets.HandleFetchWallet)
+    api.users.HandleFunc("PUT /wallets", app.wallets.HandleUpdateWallet)
+    api.users.HandleFunc("PUT /wallets/{$}", app.wallets.HandleUpdateWallet)
 }
 
 // Initialize the app with the router and services
 func (app *App) initialize() {
-    app.Router = http.NewServeMux()
+    app.Router = NewRouter()
     app.initializeRoutes()
+    if _, err := app.buildOpenAPI(); err != nil {
+        logging.Log.Error("Failed to build the OpenAPI document", slog.String("error", err.Error()))
+    }
+
+    cors := DefaultCORSConfig
+    cors.AllowedOrigins = app.HTTP.CORSOrigins
+
+    // These apply to every request, including the ones no route matches
+    app.handler = Chain(
+        app.Router,
+        RequestID(),
+        Language(),
+        Tracing(),
+        AccessLog(),
+        Metrics(),
+        Recover(),
+        Timeout(app.HTTP.RequestTimeout),
+        ReadYourWrites(),
+        CORS(cors),
+        MaxBodySize(maxBodySize),
+        RouterProblems(),
+    )
 }
 
-func (app *App) Run(port string) {
-    app.initialize()
-    fmt.Printf("Servidor iniciado na porta %s...\n", port)
-    log.Fatal(http.ListenAndServe(port, app.Router))
+// Run serves until SIGINT or SIGTERM is received, then drains the
+// in-flight requests within the shutdown timeout and releases the
+// resources registered with OnShutdown
+func (app *App) Run() error {
+
+    server := &http.Server{
+        Addr:              app.HTTP.Addr(),
+        Handler:           app.handler,
+        ReadTimeout:       app.HTTP.ReadTimeout,
+        ReadHeaderTimeout: app.HTTP.ReadHeaderTimeout,
+        WriteTimeout:      app.HTTP.WriteTimeout,
+        IdleTimeout:       app.HTTP.IdleTimeout,
+    }
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    serveErr := make(chan error, 1)
+    go func() {
+        logging.Log.Info("Server started", slog.Int("port", app.HTTP.Port))
+        serveErr <- server.ListenAndServe()
+    }()
+
+    select {
+    case err := <-serveErr:
+        // The server never started or died, there is nothing to drain
+        return errors.Join(err, app.close(context.Background()))
+    case <-ctx.Done():
+    }
+
+    logging.Log.Info("Shutdown signal received, draining connections", slog.Duration("timeout", app.HTTP.ShutdownTimeout))
+
+    // Keep serving while the load balancer notices /readyz failing
+    app.draining.Store(true)
+    if app.HTTP.DrainDelay > 0 {
+        select {
+        case <-time.After(app.HTTP.DrainDelay):
+        case err := <-serveErr:
+            return errors.Join(err, app.close(context.Background()))
+        }
+    }
+
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), app.HTTP.ShutdownTimeout)
+    defer cancel()
+
+    err := server.Shutdown(shutdownCtx)
+    if err != nil {
+        logging.Log.Error("Failed to drain connections", slog.String("error", err.Error()))
+    }
+
+    err = errors.Join(err, app.close(shutdownCtx))
+    if err == nil {
+        logging.Log.Info("Server stopped")
+    }
+
+    return err
+}
+
+func (app *App) close(ctx context.Context) error {
+    var errs []error
+    for i := len(app.closers) - 1; i >= 0; i-- {
+        if err := app.closers[i](ctx); err != nil {
+            logging.Log.Error("Failed to release resource on shutdown", slog.String("error", err.Error()))
+            errs = append(errs, err)
+        }
+    }
+
+    return errors.Join(errs...)
 }
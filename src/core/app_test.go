@@ -0,0 +1,106 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/controllers"
+	"github.com/alexbsec/MiniMarketplace/src/security"
+)
+
+// newTestApp registers the routes of the app. The controllers are not
+// wired to stores, only requests rejected before the handlers can be sent.
+func newTestApp() *App {
+	app := &App{}
+	app.Metrics.Enabled = true
+	app.auth = controllers.NewAuthController(nil, nil, nil, nil, security.PasswordHasher{}, nil)
+	app.initialize()
+	return app
+}
+
+func TestUnversionedRoutesAreDeprecatedAliases(t *testing.T) {
+	app := newTestApp()
+
+	cases := []struct {
+		path       string
+		deprecated bool
+	}{
+		{"/api/v1/products/abc", false},
+		{"/products/abc", true},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		app.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", c.path, http.StatusBadRequest, rec.Code)
+		}
+
+		deprecation, sunset := rec.Header().Get("Deprecation"), rec.Header().Get("Sunset")
+		if c.deprecated && (deprecation == "" || sunset == "") {
+			t.Errorf("%s: expected the Deprecation and Sunset headers", c.path)
+		}
+		if !c.deprecated && (deprecation != "" || sunset != "") {
+			t.Errorf("%s: expected no deprecation, got %q %q", c.path, deprecation, sunset)
+		}
+	}
+
+	// Every route of v1 has its alias
+	aliases := make(map[string]bool)
+	for _, route := range app.Router.Routes() {
+		if route.Deprecated {
+			aliases[route.Method+" /api/v1"+route.Pattern] = true
+		}
+	}
+	for _, route := range app.Router.Routes() {
+		if strings.HasPrefix(route.Pattern, "/api/v1/") && !aliases[route.Method+" "+route.Pattern] {
+			t.Errorf("Expected an unversioned alias of %s %s", route.Method, route.Pattern)
+		}
+	}
+}
+
+func TestOperationalRoutesAreNotVersioned(t *testing.T) {
+	app := newTestApp()
+
+	for _, route := range app.Router.Routes() {
+		if route.Pattern == "/healthz" && route.Deprecated {
+			t.Error("Expected /healthz not to be deprecated")
+		}
+		if route.Pattern == "/api/v1/healthz" {
+			t.Error("Expected /healthz to stay out of the versioned API")
+		}
+	}
+}
+
+func TestCORSOriginsFromConfig(t *testing.T) {
+	app := &App{}
+	app.HTTP.CORSOrigins = []string{"https://shop.example.com"}
+	app.auth = controllers.NewAuthController(nil, nil, nil, nil, security.PasswordHasher{}, nil)
+	app.initialize()
+
+	cases := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://shop.example.com", true},
+		{"https://evil.example.com", false},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodOptions, "/api/v1/products/", nil)
+		req.Header.Set("Origin", c.origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		rec := httptest.NewRecorder()
+		app.handler.ServeHTTP(rec, req)
+
+		got := rec.Header().Get("Access-Control-Allow-Origin")
+		if c.allowed && got != c.origin {
+			t.Errorf("%s: expected the origin to be allowed, got %q", c.origin, got)
+		}
+		if !c.allowed && got != "" {
+			t.Errorf("%s: expected the origin to be refused, got %q", c.origin, got)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package app
+
+import (
+	"net/http"
+	"reflect"
+	"runtime"
+	"strings"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+)
+
+// RouteGroup registers routes sharing a path prefix and middlewares.
+// Patterns are a method and a path, e.g. "PUT /products/{id:uint}", see
+// Router for the parameters.
+type RouteGroup struct {
+	router      *Router
+	prefix      string
+	middlewares []Middleware
+	// Nil unless the routes are deprecated
+	deprecation *Deprecation
+}
+
+// Use adds middlewares to the routes registered after the call
+func (g *RouteGroup) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// Group returns a sub group inheriting the prefix and middlewares
+func (g *RouteGroup) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	inherited := make([]Middleware, 0, len(g.middlewares)+len(middlewares))
+	inherited = append(inherited, g.middlewares...)
+	inherited = append(inherited, middlewares...)
+
+	return &RouteGroup{
+		router:      g.router,
+		prefix:      g.prefix + prefix,
+		middlewares: inherited,
+		deprecation: g.deprecation,
+	}
+}
+
+// Deprecated returns a sub group whose routes answer with the Deprecation
+// and Sunset headers. They keep working, clients are expected to move to
+// their replacement.
+func (g *RouteGroup) Deprecated(deprecation Deprecation) *RouteGroup {
+	group := g.Group("")
+	group.deprecation = &deprecation
+	return group
+}
+
+// Handle registers the handler wrapped by the group middlewares and then by
+// the route's own middlewares
+func (g *RouteGroup) Handle(pattern string, handler http.Handler, middlewares ...Middleware) {
+	method, path, found := strings.Cut(pattern, " ")
+	if !found {
+		method, path = "", pattern
+	}
+
+	// The method is recorded apart, the route is the path pattern only
+	routePattern, params := parsePath(g.prefix + path)
+	muxPattern := routePattern
+	if method != "" {
+		muxPattern = method + " " + routePattern
+	}
+
+	all := make([]Middleware, 0, len(g.middlewares)+len(middlewares))
+	all = append(all, g.middlewares...)
+	all = append(all, middlewares...)
+
+	// The controller span covers the handler only, after the middlewares
+	// such as authentication
+	name := handlerName(handler, routePattern)
+	traced := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := tracing.Start(r.Context(), name)
+		defer span.End()
+		handler.ServeHTTP(w, r.WithContext(ctx))
+	})
+
+	// Bad parameters are rejected before the middlewares, there is no
+	// point in authenticating a request that cannot be served
+	chained := checkParams(params, Chain(traced, all...))
+	if g.deprecation != nil {
+		chained = deprecated(*g.deprecation, chained)
+	}
+
+	// Middlewares wrapping the router learn which route matched
+	g.router.mux.Handle(muxPattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := requestctx.RouteOf(r.Context()); route != nil {
+			route.Pattern = routePattern
+		}
+		chained.ServeHTTP(w, r)
+	}))
+
+	g.router.routes = append(g.router.routes, Route{
+		Method:     method,
+		Pattern:    routePattern,
+		Params:     params,
+		Name:       name,
+		Deprecated: g.deprecation != nil,
+	})
+}
+
+// handlerName names the controller span after the handler method, e.g.
+// ProductController.HandleGetProduct, or the route for other handlers
+func handlerName(handler http.Handler, fallback string) string {
+	fn, ok := handler.(http.HandlerFunc)
+	if !ok {
+		return fallback
+	}
+
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	// Method values are named like pkg.(*ProductController).HandleGetProduct-fm
+	name = strings.TrimSuffix(name, "-fm")
+	name = name[strings.LastIndex(name, "/")+1:]
+	if _, method, found := strings.Cut(name, "."); found {
+		name = method
+	}
+	name = strings.NewReplacer("(*", "", ")", "").Replace(name)
+
+	// Closures have no meaningful name
+	if strings.Contains(name, "func") {
+		return fallback
+	}
+
+	return name
+}
+
+func (g *RouteGroup) HandleFunc(pattern string, handler http.HandlerFunc, middlewares ...Middleware) {
+	g.Handle(pattern, handler, middlewares...)
+}
@@ -0,0 +1,204 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	dbconfig "github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/migrations"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Probes taking longer than this count as failing
+const probeTimeout = 2 * time.Second
+
+// Probe checks a dependency the app needs to serve requests
+type Probe struct {
+	Name string
+	// Check returns the version of the dependency, or an error when it
+	// can't be used
+	Check func(ctx context.Context) (string, error)
+}
+
+type probeResult struct {
+	Name      string  `json:"name"`
+	Healthy   bool    `json:"healthy"`
+	Version   string  `json:"version,omitempty"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// AddProbe adds a dependency checked by /readyz and /admin/status
+func (app *App) AddProbe(probe Probe) {
+	app.probes = append(app.probes, probe)
+}
+
+// PostgresProbe pings the database and reports the server version
+func PostgresProbe(service *dbconfig.Service) Probe {
+	return Probe{
+		Name: "postgres",
+		Check: func(ctx context.Context) (string, error) {
+			if err := service.Ping(ctx); err != nil {
+				return "", err
+			}
+
+			return service.Version(ctx)
+		},
+	}
+}
+
+// MongoProbe pings the deployment and reports its version
+func MongoProbe(client *mongo.Client) Probe {
+	return Probe{
+		Name: "mongo",
+		Check: func(ctx context.Context) (string, error) {
+			return dbconfig.MongoVersion(ctx, client)
+		},
+	}
+}
+
+// MigrationsProbe fails while the database lags behind the migrations
+// embedded in the binary. A newer database is fine, it happens while a
+// rollout is in progress.
+func MigrationsProbe(service *dbconfig.Service) Probe {
+	return Probe{
+		Name: "migrations",
+		Check: func(ctx context.Context) (string, error) {
+			db, err := service.Db()
+			if err != nil {
+				return "", err
+			}
+
+			applied, err := migrations.Applied(ctx, db)
+			if err != nil {
+				return "", err
+			}
+
+			if latest := migrations.Latest(); applied < latest {
+				return applied, fmt.Errorf("pending migrations, database at %q, expected %q", applied, latest)
+			}
+
+			return applied, nil
+		},
+	}
+}
+
+// runProbes checks every dependency concurrently
+func (app *App) runProbes(ctx context.Context) ([]probeResult, bool) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+
+	results := make([]probeResult, len(app.probes))
+	var wg sync.WaitGroup
+	for i, probe := range app.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			start := time.Now()
+			version, err := probe.Check(ctx)
+			results[i] = probeResult{
+				Name:      probe.Name,
+				Healthy:   err == nil,
+				Version:   version,
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	healthy := true
+	for _, result := range results {
+		healthy = healthy && result.Healthy
+	}
+
+	return results, healthy
+}
+
+// handleHealthz only tells the process is alive, dependencies are not
+// checked so an outage of the database does not get the process restarted
+func (app *App) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz tells whether requests can be routed to this process. It
+// fails while draining or when a dependency fails, without the details
+// since it is public.
+func (app *App) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if app.draining.Load() {
+		writeHealth(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+
+	results, healthy := app.runProbes(r.Context())
+
+	checks := make(map[string]string, len(results))
+	for _, result := range results {
+		checks[result.Name] = "ok"
+		if !result.Healthy {
+			checks[result.Name] = "failing"
+		}
+	}
+
+	status, code := "ok", http.StatusOK
+	if !healthy {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+
+	writeHealth(w, code, map[string]any{"status": status, "checks": checks})
+}
+
+type statusOut struct {
+	Status        string        `json:"status"`
+	Draining      bool          `json:"draining"`
+	UptimeSeconds int64         `json:"uptime_seconds"`
+	GoVersion     string        `json:"go_version"`
+	Version       string        `json:"version,omitempty"`
+	Revision      string        `json:"revision,omitempty"`
+	Checks        []probeResult `json:"checks"`
+}
+
+// handleStatus details every dependency for the admins. It always answers
+// 200, the status field tells whether the app is ready.
+func (app *App) handleStatus(w http.ResponseWriter, r *http.Request) {
+	results, healthy := app.runProbes(r.Context())
+
+	out := statusOut{
+		Status:        "ok",
+		Draining:      app.draining.Load(),
+		UptimeSeconds: int64(time.Since(app.started).Seconds()),
+		GoVersion:     runtime.Version(),
+		Checks:        results,
+	}
+	if !healthy {
+		out.Status = "degraded"
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		out.Version = info.Main.Version
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				out.Revision = setting.Value
+			}
+		}
+	}
+
+	writeHealth(w, http.StatusOK, out)
+}
+
+func writeHealth(w http.ResponseWriter, status int, body any) {
+	// Probes must always see the current state
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func probe(name string, err error) Probe {
+	return Probe{
+		Name: name,
+		Check: func(ctx context.Context) (string, error) {
+			return "1.0", err
+		},
+	}
+}
+
+func readyz(app *App) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	app.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	return rec
+}
+
+func TestReadyz(t *testing.T) {
+	app := &App{}
+	app.AddProbe(probe("postgres", nil))
+
+	if rec := readyz(app); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	app.AddProbe(probe("mongo", fmt.Errorf("connection refused")))
+
+	rec := readyz(app)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	var out struct {
+		Checks map[string]string `json:"checks"`
+	}
+	json.NewDecoder(rec.Body).Decode(&out)
+	if out.Checks["postgres"] != "ok" || out.Checks["mongo"] != "failing" {
+		t.Errorf("Unexpected checks %v", out.Checks)
+	}
+}
+
+func TestReadyzFailsWhileDraining(t *testing.T) {
+	app := &App{}
+	app.AddProbe(probe("postgres", nil))
+	app.draining.Store(true)
+
+	if rec := readyz(app); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d while draining, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+
+	// Liveness is not affected
+	rec := httptest.NewRecorder()
+	app.handleHealthz(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestProbeTimeout(t *testing.T) {
+	app := &App{}
+	app.AddProbe(Probe{
+		Name: "slow",
+		Check: func(ctx context.Context) (string, error) {
+			select {
+			case <-ctx.Done():
+				return "", ctx.Err()
+			case <-time.After(time.Minute):
+				return "", nil
+			}
+		},
+	})
+
+	results, healthy := app.runProbes(context.Background())
+	if healthy || results[0].Error == "" {
+		t.Errorf("Expected a probe past the timeout to fail, got %+v", results)
+	}
+}
+
+func TestStatusDetails(t *testing.T) {
+	app := &App{started: time.Now()}
+	app.AddProbe(probe("postgres", nil))
+	app.AddProbe(probe("mongo", fmt.Errorf("connection refused")))
+
+	rec := httptest.NewRecorder()
+	app.handleStatus(rec, httptest.NewRequest(http.MethodGet, "/admin/status", nil))
+
+	var out statusOut
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	if out.Status != "degraded" || len(out.Checks) != 2 {
+		t.Fatalf("Unexpected status %+v", out)
+	}
+	if out.Checks[0].Version != "1.0" || out.Checks[1].Error != "connection refused" {
+		t.Errorf("Expected versions and errors per dependency, got %+v", out.Checks)
+	}
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/metrics"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	return string(body)
+}
+
+func TestMetricsLabelRoutePattern(t *testing.T) {
+	router := NewRouter()
+	group := router.Group("/metrics-test")
+	group.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	handler := Chain(router, Metrics())
+
+	for _, path := range []string{"/metrics-test/items/1", "/metrics-test/items/2", "/metrics-test/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	body := scrape(t)
+
+	want := `minimarketplace_http_requests_total{method="GET",route="/metrics-test/items/{id}",status="418"} 2`
+	if !strings.Contains(body, want) {
+		t.Errorf("Expected %q in\n%s", want, body)
+	}
+
+	// Raw paths never become labels
+	if strings.Contains(body, "/metrics-test/items/1") || strings.Contains(body, "/metrics-test/nowhere") {
+		t.Error("Expected raw paths not to be used as labels")
+	}
+	if !strings.Contains(body, `route="unmatched",status="404"`) {
+		t.Error("Expected unmatched requests to be grouped")
+	}
+}
+
+func TestRequireToken(t *testing.T) {
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), RequireToken("secret"))
+
+	cases := map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	}
+
+	for header, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("%q: expected status %d, got %d", header, want, rec.Code)
+		}
+	}
+}
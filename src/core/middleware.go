@@ -0,0 +1,480 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	dbconfig "github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/i18n"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/metrics"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware wraps a handler with extra behaviour
+type Middleware func(http.Handler) http.Handler
+
+// Chain wraps the handler with the middlewares, the first one being the
+// outermost
+func Chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+
+	return handler
+}
+
+const requestIDHeader = "X-Request-ID"
+
+// RequestID reuses the X-Request-ID of the client when it looks sane and
+// generates one otherwise. The id is sent back and put into the context.
+func RequestID() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(requestIDHeader)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+
+			w.Header().Set(requestIDHeader, id)
+			next.ServeHTTP(w, r.WithContext(requestctx.WithRequestID(r.Context(), id)))
+		})
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func newRequestID() string {
+	bytes := make([]byte, 16)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)
+}
+
+// Recover turns a panic in a handler into a 500 instead of killing the
+// connection, logging the stack trace
+func Recover() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				err := recover()
+				if err == nil {
+					return
+				}
+
+				// Let the server abort the response as usual
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
+
+				logging.Log.ErrorContext(
+					r.Context(),
+					"Panic while handling request",
+					slog.String("error", fmt.Sprint(err)),
+					slog.String("request_id", requestctx.RequestID(r.Context())),
+					slog.String("stack", string(debug.Stack())),
+				)
+				problem.Error(w, r, http.StatusInternalServerError, problem.CodeInternal)
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// statusRecorder captures what a handler writes for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	if sr.status == 0 {
+		sr.status = status
+	}
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func (sr *statusRecorder) Write(b []byte) (int, error) {
+	if sr.status == 0 {
+		sr.status = http.StatusOK
+	}
+	n, err := sr.ResponseWriter.Write(b)
+	sr.bytes += n
+	return n, err
+}
+
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
+// Polled every few seconds by the orchestrator, logged at debug level only
+var quietPaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// AccessLog logs every request once it is handled
+func AccessLog() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(recorder, r)
+
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+
+			level := slog.LevelInfo
+			if quietPaths[r.URL.Path] && recorder.status < http.StatusInternalServerError {
+				level = slog.LevelDebug
+			}
+
+			logging.Log.Log(
+				r.Context(),
+				level,
+				"Request handled",
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Int("status", recorder.status),
+				slog.Int("bytes", recorder.bytes),
+				slog.Duration("duration", time.Since(start)),
+				slog.String("remote_addr", r.RemoteAddr),
+				slog.String("request_id", requestctx.RequestID(r.Context())),
+			)
+		})
+	}
+}
+
+// Route label of the requests no route matched, e.g. 404s
+const unmatchedRoute = "unmatched"
+
+// Metrics records the count and latency of requests by route pattern. It
+// must wrap the router to see the requests no route matched.
+func Metrics() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			recorder := &statusRecorder{ResponseWriter: w}
+			ctx, route := withRoute(r.Context())
+
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+
+			pattern := route.Pattern
+			if pattern == "" {
+				pattern = unmatchedRoute
+			}
+
+			metrics.ObserveRequest(pattern, r.Method, recorder.status, time.Since(start))
+		})
+	}
+}
+
+// withRoute reuses the route of an outer middleware, if any
+func withRoute(ctx context.Context) (context.Context, *requestctx.Route) {
+	if route := requestctx.RouteOf(ctx); route != nil {
+		return ctx, route
+	}
+
+	return requestctx.WithRoute(ctx)
+}
+
+// Tracing continues the trace of the W3C traceparent header, or starts
+// one, with a server span per request. The span is named after the route
+// pattern once the router matched it.
+func Tracing() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+			ctx, span := tracing.Tracer().Start(ctx, r.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(
+					semconv.HTTPRequestMethodKey.String(r.Method),
+					semconv.URLPath(r.URL.Path),
+					semconv.ClientAddress(clientAddress(r)),
+					attribute.String("request_id", requestctx.RequestID(r.Context())),
+				),
+			)
+			defer span.End()
+
+			ctx, route := withRoute(ctx)
+			recorder := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(recorder, r.WithContext(ctx))
+
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+			if route.Pattern != "" {
+				span.SetName(r.Method + " " + route.Pattern)
+				span.SetAttributes(semconv.HTTPRoute(route.Pattern))
+			}
+			span.SetAttributes(semconv.HTTPResponseStatusCode(recorder.status))
+			// Client errors are the client's, only server errors fail the span
+			if recorder.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(recorder.status))
+			}
+		})
+	}
+}
+
+func clientAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// routerProblemWriter replaces the plain text 404 and 405 of the router
+// with problems. Routes write their own errors, so only the responses of
+// requests no route matched are replaced.
+type routerProblemWriter struct {
+	http.ResponseWriter
+	r        *http.Request
+	route    *requestctx.Route
+	replaced bool
+}
+
+func (pw *routerProblemWriter) WriteHeader(status int) {
+	if pw.route.Pattern == "" && !pw.replaced {
+		switch status {
+		case http.StatusNotFound:
+			pw.replaced = true
+			problem.Error(pw.ResponseWriter, pw.r, status, problem.CodeRouteNotFound)
+			return
+		case http.StatusMethodNotAllowed:
+			pw.replaced = true
+			problem.Error(pw.ResponseWriter, pw.r, status, problem.CodeMethodNotAllowed)
+			return
+		}
+	}
+
+	pw.ResponseWriter.WriteHeader(status)
+}
+
+func (pw *routerProblemWriter) Write(b []byte) (int, error) {
+	if pw.replaced {
+		// Drop the text body of the router
+		return len(b), nil
+	}
+	return pw.ResponseWriter.Write(b)
+}
+
+func (pw *routerProblemWriter) Unwrap() http.ResponseWriter {
+	return pw.ResponseWriter
+}
+
+// RouterProblems makes the errors of the router itself problems, like the
+// errors of the handlers. It must wrap the router.
+func RouterProblems() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, route := withRoute(r.Context())
+			r = r.WithContext(ctx)
+			next.ServeHTTP(&routerProblemWriter{ResponseWriter: w, r: r, route: route}, r)
+		})
+	}
+}
+
+type CORSConfig struct {
+	// "*" allows any origin
+	AllowedOrigins []string
+	AllowedMethods []string
+	AllowedHeaders []string
+	MaxAge         time.Duration
+}
+
+var DefaultCORSConfig = CORSConfig{
+	AllowedOrigins: []string{"*"},
+	AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
+	AllowedHeaders: []string{"Authorization", "Content-Type", "X-API-Key", requestIDHeader, consistencyHeader},
+	MaxAge:         10 * time.Minute,
+}
+
+// CORS sets the CORS headers for allowed origins and answers preflight
+// requests. It must wrap the whole router since preflights use OPTIONS.
+func CORS(config CORSConfig) Middleware {
+	methods := strings.Join(config.AllowedMethods, ", ")
+	headers := strings.Join(config.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(config.MaxAge.Seconds()))
+
+	allowed := func(origin string) bool {
+		for _, o := range config.AllowedOrigins {
+			if o == "*" || strings.EqualFold(o, origin) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || !allowed(origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Add("Vary", "Origin")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Timeout sets a deadline on the context of the request. The services pass
+// the context to their queries, so they are cancelled once it passes. A
+// zero timeout leaves the request without a deadline.
+func Timeout(timeout time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Sent as "strong" by the clients reading what they just wrote
+const consistencyHeader = "X-Consistency"
+
+// ReadYourWrites sends the reads of a request to the primary database
+// instead of a replica, which may lag behind, when the request writes or
+// when the client asks for it with "X-Consistency: strong"
+func ReadYourWrites() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			safe := r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions
+			if !safe || strings.EqualFold(r.Header.Get(consistencyHeader), "strong") {
+				r = r.WithContext(dbconfig.WithPrimary(r.Context()))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MaxBodySize makes reading more than limit bytes of the body fail
+func MaxBodySize(limit int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireToken rejects the requests not carrying the token as a Bearer
+// token. An empty token lets every request through.
+func RequireToken(token string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" {
+				sent, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if !ok || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+					problem.Error(w, r, http.StatusUnauthorized, problem.CodeUnauthorized)
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Language picks the language of the responses from the Accept-Language
+// header. Auth overrides it with the preference of the user, if any.
+func Language() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lang := i18n.Negotiate(r.Header.Get("Accept-Language"))
+			next.ServeHTTP(w, r.WithContext(requestctx.WithLanguage(r.Context(), lang)))
+		})
+	}
+}
+
+// Authenticator resolves the user making the request
+type Authenticator func(r *http.Request) (*models.User, error)
+
+// Auth rejects the requests the authenticator can't resolve to a user and
+// puts the user into the request context otherwise
+func Auth(authenticate Authenticator) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := authenticate(r)
+			if err != nil {
+				// The reason is only told when it is a problem, other errors
+				// may hold details the client must not see
+				var p *problem.Problem
+				if !errors.As(err, &p) {
+					p = problem.New(http.StatusUnauthorized, problem.CodeUnauthorized)
+				}
+				problem.Write(w, r, p)
+				return
+			}
+
+			ctx := requestctx.WithUser(r.Context(), user)
+			// The language picked by the user wins over the browser's
+			if user.Language != nil {
+				if lang, ok := i18n.Canonical(*user.Language); ok {
+					ctx = requestctx.WithLanguage(ctx, lang)
+				}
+			}
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	dbconfig "github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/i18n"
+)
+
+func TestRouterProblems(t *testing.T) {
+	router := NewRouter()
+	group := router.Group("")
+	group.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		// Errors of the routes are left alone
+		http.NotFound(w, r)
+	})
+	handler := Chain(router, RouterProblems())
+
+	cases := []struct {
+		method string
+		path   string
+		code   problem.Code
+	}{
+		{http.MethodGet, "/nowhere", problem.CodeRouteNotFound},
+		{http.MethodDelete, "/items/1", problem.CodeMethodNotAllowed},
+	}
+
+	for _, c := range cases {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(c.method, c.path, nil))
+
+		var out problem.Problem
+		if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+			t.Fatalf("%s %s: expected a problem, got %v", c.method, c.path, err)
+		}
+		if out.Code != c.code || rec.Header().Get("Content-Type") != problem.ContentType {
+			t.Errorf("%s %s: expected code %q, got %+v", c.method, c.path, c.code, out)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items/1", nil))
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") == problem.ContentType {
+		t.Errorf("Expected the response of the route, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+}
+
+func TestLanguagePreference(t *testing.T) {
+	portuguese := "pt-br"
+	users := map[string]*models.User{
+		"browser":   {ID: 1},
+		"preferred": {ID: 2, Language: &portuguese},
+	}
+	authenticate := func(r *http.Request) (*models.User, error) {
+		user, ok := users[r.Header.Get("X-Test-User")]
+		if !ok {
+			return nil, fmt.Errorf("Unauthorized")
+		}
+		return user, nil
+	}
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(requestctx.Language(r.Context())))
+	}), Language(), Auth(authenticate))
+
+	cases := []struct {
+		user           string
+		acceptLanguage string
+		want           string
+	}{
+		{"browser", "", i18n.English},
+		{"browser", "pt-BR,pt;q=0.9", i18n.Portuguese},
+		{"preferred", "en-US", i18n.Portuguese},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Test-User", c.user)
+		req.Header.Set("Accept-Language", c.acceptLanguage)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Body.String(); got != c.want {
+			t.Errorf("%s with %q: expected %q, got %q", c.user, c.acceptLanguage, c.want, got)
+		}
+	}
+
+	// Errors before the user is known follow the header
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Language", "pt-BR")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	var out problem.Problem
+	json.NewDecoder(rec.Body).Decode(&out)
+	if out.Code != problem.CodeUnauthorized || out.Detail != "Não autorizado" {
+		t.Errorf("Expected the problem in pt-BR, got %+v", out)
+	}
+}
+
+func TestReadYourWrites(t *testing.T) {
+	var primary bool
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		primary = dbconfig.UsesPrimary(r.Context())
+	}), ReadYourWrites())
+
+	cases := []struct {
+		method      string
+		consistency string
+		primary     bool
+	}{
+		{http.MethodGet, "", false},
+		{http.MethodGet, "strong", true},
+		{http.MethodPut, "", true},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/", nil)
+		if c.consistency != "" {
+			req.Header.Set("X-Consistency", c.consistency)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if primary != c.primary {
+			t.Errorf("%s %q: expected primary %t, got %t", c.method, c.consistency, c.primary, primary)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var deadline time.Time
+	var hasDeadline bool
+	handler := Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, hasDeadline = r.Context().Deadline()
+	}), Timeout(time.Minute))
+
+	start := time.Now()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if !hasDeadline || deadline.Before(start.Add(time.Minute)) || deadline.After(time.Now().Add(time.Minute)) {
+		t.Errorf("Expected a deadline a minute from now, got %v (set: %t)", deadline, hasDeadline)
+	}
+
+	handler = Chain(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, hasDeadline = r.Context().Deadline()
+	}), Timeout(0))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if hasDeadline {
+		t.Error("Expected no deadline with a zero timeout")
+	}
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"github.com/alexbsec/MiniMarketplace/src/controllers"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/openapi"
+)
+
+var openAPIInfo = openapi.Info{
+	Title:       "MiniMarketplace API",
+	Description: "Errors are answered with RFC 7807 problems, see the Problem schema.",
+	Version:     "1",
+}
+
+// operations documents the handlers of the app and of the controllers
+func (app *App) operations() map[string]openapi.Operation {
+	operations := controllers.Operations()
+
+	operations["App.handleHealthz"] = openapi.Operation{
+		Summary:  "Liveness probe",
+		Tag:      "Operations",
+		Response: map[string]string{},
+	}
+	operations["App.handleReadyz"] = openapi.Operation{
+		Summary:  "Readiness probe, fails while a dependency is down",
+		Tag:      "Operations",
+		Response: map[string]any{},
+		Errors:   []int{http.StatusServiceUnavailable},
+	}
+	operations["App.handleStatus"] = openapi.Operation{
+		Summary:  "Status of the app and its dependencies",
+		Tag:      "Operations",
+		Access:   openapi.Admin,
+		Response: statusOut{},
+	}
+	operations["/metrics"] = openapi.Operation{
+		Summary:     "Prometheus metrics, behind a bearer token when one is configured",
+		Tag:         "Operations",
+		Response:    "",
+		ContentType: "text/plain",
+		Errors:      []int{http.StatusUnauthorized},
+	}
+	operations["App.handleOpenAPI"] = openapi.Operation{
+		Summary:  "This document",
+		Tag:      "Operations",
+		Response: map[string]any{},
+	}
+	operations["/docs"] = openapi.Operation{
+		Summary:     "Documentation of the API rendered from this document",
+		Tag:         "Operations",
+		Response:    "",
+		ContentType: "text/html",
+	}
+
+	return operations
+}
+
+// buildOpenAPI documents the registered routes, it runs once every route
+// is registered
+func (app *App) buildOpenAPI() (*openapi.Document, error) {
+	routes := app.Router.Routes()
+	documented := make([]openapi.Route, len(routes))
+	for i, route := range routes {
+		params := make([]openapi.Param, len(route.Params))
+		for j, param := range route.Params {
+			params[j] = openapi.Param{Name: param.Name, Type: param.Type}
+		}
+
+		documented[i] = openapi.Route{
+			Method:     route.Method,
+			Pattern:    route.Pattern,
+			Params:     params,
+			Name:       route.Name,
+			Deprecated: route.Deprecated,
+		}
+	}
+
+	doc, missing := openapi.Build(openAPIInfo, documented, app.operations())
+	for _, route := range missing {
+		logging.Log.Warn("Route missing from the OpenAPI document", slog.String("method", route.Method), slog.String("route", route.Pattern), slog.String("handler", route.Name))
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	app.openAPI = body
+
+	return doc, nil
+}
+
+func (app *App) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(app.openAPI)
+}
@@ -0,0 +1,65 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/openapi"
+)
+
+func TestOpenAPIDocumentsEveryRoute(t *testing.T) {
+	app := newTestApp()
+
+	rec := httptest.NewRecorder()
+	app.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var doc openapi.Document
+	if err := json.NewDecoder(rec.Body).Decode(&doc); err != nil {
+		t.Fatalf("Failed to decode the document: %v", err)
+	}
+	if doc.OpenAPI != openapi.Version {
+		t.Errorf("Expected OpenAPI %s, got %q", openapi.Version, doc.OpenAPI)
+	}
+
+	// Document every new route in the operations of its controller
+	for _, route := range app.Router.Routes() {
+		if !doc.Has(route.Method, route.Pattern) {
+			t.Errorf("Route %s %s of %s is missing from the document", route.Method, route.Pattern, route.Name)
+		}
+	}
+
+	product := doc.Components.Schemas["ProductBody"]
+	if product == nil || product.Properties["price"] == nil || *product.Properties["price"].Minimum != 0 {
+		t.Fatalf("Expected the rules of productBody in its schema, got %+v", product)
+	}
+	if strings.Join(product.Required, ",") != "name,price" {
+		t.Errorf("Expected name and price to be required, got %v", product.Required)
+	}
+
+	fetch := doc.Paths["/api/v1/products/{id}"]["get"]
+	if fetch == nil || fetch.Deprecated || fetch.Responses["200"].Content["application/json"].Schema.Ref != "#/components/schemas/Product" {
+		t.Errorf("Expected GET /api/v1/products/{id} to answer a Product, got %+v", fetch)
+	}
+	if legacy := doc.Paths["/products"]["get"]; legacy == nil || !legacy.Deprecated || legacy.Parameters[0].In != "query" {
+		t.Errorf("Expected GET /products?id= to be deprecated, got %+v", legacy)
+	}
+}
+
+func TestDocsPage(t *testing.T) {
+	app := newTestApp()
+
+	rec := httptest.NewRecorder()
+	app.handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("Expected an HTML page, got %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "openapi.json") {
+		t.Error("Expected the page to load the document")
+	}
+}
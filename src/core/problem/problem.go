@@ -0,0 +1,146 @@
+// Package problem writes the errors of the API as RFC 7807 problem details,
+// served as application/problem+json
+package problem
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	"github.com/alexbsec/MiniMarketplace/src/i18n"
+)
+
+const ContentType = "application/problem+json"
+
+// Code identifies the kind of error for the clients. Codes are part of the
+// API, never rename one. The messages are the entries of the i18n catalog
+// with the same key.
+type Code string
+
+const (
+	CodeInvalidPayload    Code = "invalid_payload"
+	CodeInvalidID         Code = "invalid_id"
+	CodeInvalidPathParam  Code = "invalid_path_parameter"
+	CodeValidationFailed  Code = "validation_failed"
+	CodeUnauthorized      Code = "unauthorized"
+	CodeForbidden         Code = "forbidden"
+	CodeInsufficientScope Code = "insufficient_scope"
+	CodeSessionRequired   Code = "session_required"
+	CodeInternal          Code = "internal_error"
+	CodeRouteNotFound     Code = "route_not_found"
+	CodeMethodNotAllowed  Code = "method_not_allowed"
+	CodePayloadTooLarge   Code = "payload_too_large"
+	CodeRequestTimeout    Code = "request_timeout"
+
+	CodeProductNotFound Code = "product_not_found"
+	CodeUserNotFound    Code = "user_not_found"
+	CodeWalletNotFound  Code = "wallet_not_found"
+	CodeAPIKeyNotFound  Code = "api_key_not_found"
+	CodeSessionNotFound Code = "session_not_found"
+
+	CodeOutboxEventNotFound  Code = "outbox_event_not_found"
+	CodeOutboxEventNotFailed Code = "outbox_event_not_failed"
+
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeLoginThrottled     Code = "login_throttled"
+	CodeWrongPassword      Code = "wrong_password"
+	CodeEmailTaken         Code = "email_taken"
+
+	CodeExternalLoginDisabled    Code = "external_login_disabled"
+	CodeExternalLoginUnavailable Code = "external_login_unavailable"
+	CodeExternalLoginFailed      Code = "external_login_failed"
+	CodeLoginSessionExpired      Code = "login_session_expired"
+	CodeVerifiedEmailRequired    Code = "verified_email_required"
+)
+
+// FieldError tells what is wrong with one field of the request. The
+// message is rendered from the "field.<code>" entry of the catalog.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Arguments of the message
+	Args []any `json:"-"`
+}
+
+// Problem is the body of every error response
+type Problem struct {
+	// URI naming the kind of problem, derived from the code
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	// Human readable explanation, in the language of the request
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      Code         `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	// Arguments of the detail message
+	Args []any `json:"-"`
+}
+
+func New(status int, code Code) *Problem {
+	return &Problem{
+		Type:   "urn:minimarketplace:problem:" + string(code),
+		Title:  http.StatusText(status),
+		Status: status,
+		Code:   code,
+	}
+}
+
+// WithArgs sets the arguments of the detail message
+func (p *Problem) WithArgs(args ...any) *Problem {
+	p.Args = args
+	return p
+}
+
+// Validation is a 400 listing the invalid fields
+func Validation(errs ...FieldError) *Problem {
+	problem := New(http.StatusBadRequest, CodeValidationFailed)
+	problem.Errors = errs
+	return problem
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%d %s", p.Status, p.Code)
+}
+
+// Write sends the problem in the language of the request, filling the
+// instance and the request id from the request
+func Write(w http.ResponseWriter, r *http.Request, problem *Problem) {
+	lang := i18n.Default
+	out := *problem
+	if r != nil {
+		if requested := requestctx.Language(r.Context()); requested != "" {
+			lang = requested
+		}
+		out.Instance = r.URL.Path
+		out.RequestID = requestctx.RequestID(r.Context())
+	}
+
+	if out.Detail == "" {
+		out.Detail = i18n.Message(lang, string(out.Code), out.Args...)
+	}
+	out.Errors = make([]FieldError, len(problem.Errors))
+	for i, fieldErr := range problem.Errors {
+		if key := "field." + fieldErr.Code; i18n.Has(key) {
+			fieldErr.Message = i18n.Message(lang, key, fieldErr.Args...)
+		}
+		out.Errors[i] = fieldErr
+	}
+
+	header := w.Header()
+	header.Del("Content-Length")
+	header.Set("Content-Type", ContentType)
+	header.Set("Content-Language", lang)
+	header.Add("Vary", "Accept-Language")
+	header.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(out.Status)
+	json.NewEncoder(w).Encode(out)
+}
+
+// Error replaces http.Error for the handlers of the API
+func Error(w http.ResponseWriter, r *http.Request, status int, code Code) {
+	Write(w, r, New(status, code))
+}
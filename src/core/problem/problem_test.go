@@ -0,0 +1,89 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/requestctx"
+	"github.com/alexbsec/MiniMarketplace/src/i18n"
+)
+
+func TestWrite(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/products/7", nil)
+	req = req.WithContext(requestctx.WithRequestID(req.Context(), "req-1"))
+	rec := httptest.NewRecorder()
+
+	Error(rec, req, http.StatusNotFound, CodeProductNotFound)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Expected content type %q, got %q", ContentType, ct)
+	}
+
+	var out Problem
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("Failed to decode the problem: %v", err)
+	}
+
+	want := Problem{
+		Type:      "urn:minimarketplace:problem:product_not_found",
+		Title:     "Not Found",
+		Status:    http.StatusNotFound,
+		Detail:    "Product does not exist",
+		Instance:  "/products/7",
+		Code:      CodeProductNotFound,
+		RequestID: "req-1",
+	}
+	if out.Type != want.Type || out.Title != want.Title || out.Status != want.Status ||
+		out.Detail != want.Detail || out.Instance != want.Instance || out.Code != want.Code ||
+		out.RequestID != want.RequestID {
+		t.Errorf("Expected %+v, got %+v", want, out)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Write(rec, httptest.NewRequest(http.MethodPost, "/users", nil), Validation(
+		FieldError{Field: "email", Code: "required", Args: []any{"email"}},
+	))
+
+	var out Problem
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("Failed to decode the problem: %v", err)
+	}
+	if out.Status != http.StatusBadRequest || out.Code != CodeValidationFailed {
+		t.Errorf("Expected a validation problem, got %+v", out)
+	}
+	if len(out.Errors) != 1 || out.Errors[0].Field != "email" || out.Errors[0].Message != "email is required" {
+		t.Errorf("Expected the field errors, got %+v", out.Errors)
+	}
+}
+
+func TestWriteInRequestLanguage(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/products/7", nil)
+	req = req.WithContext(requestctx.WithLanguage(req.Context(), i18n.Portuguese))
+	rec := httptest.NewRecorder()
+
+	problem := Validation(FieldError{Field: "password", Code: "min_length", Args: []any{12}})
+	Write(rec, req, problem)
+
+	var out Problem
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("Failed to decode the problem: %v", err)
+	}
+	if out.Detail != "Alguns campos são inválidos" || out.Errors[0].Message != "A senha deve ter pelo menos 12 caracteres" {
+		t.Errorf("Expected the messages in pt-BR, got %+v", out)
+	}
+	if lang := rec.Header().Get("Content-Language"); lang != i18n.Portuguese {
+		t.Errorf("Expected Content-Language %q, got %q", i18n.Portuguese, lang)
+	}
+
+	// The problem is left untouched for the next requests
+	if problem.Detail != "" || problem.Errors[0].Message != "" {
+		t.Errorf("Expected Write not to modify the problem, got %+v", problem)
+	}
+}
@@ -0,0 +1,66 @@
+// Package requestctx holds the values middlewares attach to a request
+// context, so both the core middlewares and the controllers can reach them
+package requestctx
+
+import (
+	"context"
+
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+)
+
+type key int
+
+const (
+	userKey key = iota
+	requestIDKey
+	routeKey
+	languageKey
+)
+
+func WithUser(ctx context.Context, user *models.User) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// User returns the authenticated user, or nil on routes without auth
+func User(ctx context.Context) *models.User {
+	user, _ := ctx.Value(userKey).(*models.User)
+	return user
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func WithLanguage(ctx context.Context, lang string) context.Context {
+	return context.WithValue(ctx, languageKey, lang)
+}
+
+// Language returns the language the response must be written in, or an
+// empty string when none was picked
+func Language(ctx context.Context) string {
+	lang, _ := ctx.Value(languageKey).(string)
+	return lang
+}
+
+// Route is filled with the pattern of the route matching the request, once
+// the router picked it. Middlewares running before the router keep a
+// pointer to read it afterwards.
+type Route struct {
+	Pattern string
+}
+
+func WithRoute(ctx context.Context) (context.Context, *Route) {
+	route := &Route{}
+	return context.WithValue(ctx, routeKey, route), route
+}
+
+// RouteOf returns the route of the request, or nil outside of WithRoute
+func RouteOf(ctx context.Context) *Route {
+	route, _ := ctx.Value(routeKey).(*Route)
+	return route
+}
@@ -0,0 +1,143 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+)
+
+// Router dispatches the requests on their method and path, on top of
+// http.ServeMux. Paths take parameters such as /products/{id}, optionally
+// typed as in /products/{id:uint}: a request whose parameter does not
+// parse never reaches the handler. A path registered for other methods
+// only is answered with a 405 and an Allow header.
+type Router struct {
+	mux    *http.ServeMux
+	routes []Route
+}
+
+// Route describes a registered route
+type Route struct {
+	Method string
+	// Path pattern without the parameter types, e.g. /products/{id}
+	Pattern string
+	Params  []Param
+	// Name of the handler, e.g. ProductController.HandleFetchProduct
+	Name       string
+	Deprecated bool
+}
+
+// Param is a parameter of the path of a route
+type Param struct {
+	Name string
+	Type string
+}
+
+// Types of the path parameters, a parameter without type is a string
+var paramTypes = map[string]func(string) bool{
+	"string": func(string) bool { return true },
+	"uint": func(value string) bool {
+		_, err := strconv.ParseUint(value, 10, 0)
+		return err == nil
+	},
+	"int": func(value string) bool {
+		_, err := strconv.ParseInt(value, 10, 0)
+		return err == nil
+	},
+}
+
+var paramRegexp = regexp.MustCompile(`\{([A-Za-z_][A-Za-z0-9_]*)(:[a-z]+)?(\.\.\.)?\}`)
+
+func NewRouter() *Router {
+	return &Router{mux: http.NewServeMux()}
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rt.mux.ServeHTTP(w, r)
+}
+
+// Group returns a route group registering on the router
+func (rt *Router) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return &RouteGroup{router: rt, prefix: prefix, middlewares: middlewares}
+}
+
+// Routes lists the registered routes in the order of registration
+func (rt *Router) Routes() []Route {
+	return append([]Route(nil), rt.routes...)
+}
+
+// parsePath strips the types of the parameters of the path, returning the
+// pattern for http.ServeMux and the parameters. It panics on an unknown
+// type, like http.ServeMux does on invalid patterns.
+func parsePath(path string) (string, []Param) {
+	var params []Param
+	pattern := paramRegexp.ReplaceAllStringFunc(path, func(match string) string {
+		groups := paramRegexp.FindStringSubmatch(match)
+		name, typ, rest := groups[1], "string", groups[3]
+		if groups[2] != "" {
+			typ = groups[2][1:]
+		}
+		if _, ok := paramTypes[typ]; !ok {
+			panic(fmt.Sprintf("route %q: unknown type %q of parameter %q", path, typ, name))
+		}
+
+		params = append(params, Param{Name: name, Type: typ})
+		return "{" + name + rest + "}"
+	})
+
+	return pattern, params
+}
+
+// checkParams answers 400 to the requests with a path parameter that does
+// not parse as its type
+func checkParams(params []Param, next http.Handler) http.Handler {
+	var typed []Param
+	for _, param := range params {
+		if param.Type != "string" {
+			typed = append(typed, param)
+		}
+	}
+	if len(typed) == 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, param := range typed {
+			if !paramTypes[param.Type](r.PathValue(param.Name)) {
+				problem.Write(w, r, problem.New(http.StatusBadRequest, problem.CodeInvalidPathParam).WithArgs(param.Name))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Deprecation marks routes that still work but have a replacement
+type Deprecation struct {
+	// When the routes were deprecated
+	Since time.Time
+	// When the routes stop working, zero while not decided
+	Sunset time.Time
+}
+
+// deprecated tells the clients the route is going away, with the
+// Deprecation header of RFC 9745 and the Sunset header of RFC 8594
+func deprecated(deprecation Deprecation, next http.Handler) http.Handler {
+	since := "@" + strconv.FormatInt(deprecation.Since.Unix(), 10)
+	var sunset string
+	if !deprecation.Sunset.IsZero() {
+		sunset = deprecation.Sunset.UTC().Format(http.TimeFormat)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", since)
+		if sunset != "" {
+			w.Header().Set("Sunset", sunset)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+)
+
+func TestRouterTypedParams(t *testing.T) {
+	router := NewRouter()
+	var got string
+	router.Group("").HandleFunc("GET /items/{id:uint}", func(w http.ResponseWriter, r *http.Request) {
+		got = r.PathValue("id")
+	})
+	handler := Chain(router, RouterProblems())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items/42", nil))
+	if rec.Code != http.StatusOK || got != "42" {
+		t.Fatalf("Expected the handler to get id 42, got %d %q", rec.Code, got)
+	}
+
+	got = ""
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/items/abc", nil))
+	if rec.Code != http.StatusBadRequest || got != "" {
+		t.Fatalf("Expected status %d without calling the handler, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	var out problem.Problem
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil || out.Code != problem.CodeInvalidPathParam {
+		t.Errorf("Expected code %q, got %+v (%v)", problem.CodeInvalidPathParam, out, err)
+	}
+}
+
+func TestRouterUnknownParamType(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering an unknown parameter type to panic")
+		}
+	}()
+
+	NewRouter().Group("").HandleFunc("GET /items/{id:uuid}", func(w http.ResponseWriter, r *http.Request) {})
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	router := NewRouter()
+	group := router.Group("")
+	noop := func(w http.ResponseWriter, r *http.Request) {}
+	group.HandleFunc("GET /items/{id}", noop)
+	group.HandleFunc("PUT /items/{id}", noop)
+	handler := Chain(router, RouterProblems())
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/items/1", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+	if allow := rec.Header().Get("Allow"); allow != "GET, HEAD, PUT" {
+		t.Errorf("Expected the methods of the path in Allow, got %q", allow)
+	}
+}
+
+func TestRouterGroups(t *testing.T) {
+	router := NewRouter()
+	tag := func(value string) Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Tag", value)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	api := router.Group("/api", tag("api"))
+	items := api.Group("/items", tag("items"))
+	items.HandleFunc("GET /{id:uint}", func(w http.ResponseWriter, r *http.Request) {})
+	legacy := items.Deprecated(Deprecation{
+		Since:  time.Date(2026, time.January, 1, 0, 0, 0, 0, time.UTC),
+		Sunset: time.Date(2026, time.July, 1, 0, 0, 0, 0, time.UTC),
+	})
+	legacy.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/items/1", nil))
+	if tags := rec.Header().Values("X-Tag"); len(tags) != 2 || tags[0] != "api" || tags[1] != "items" {
+		t.Errorf("Expected the middlewares of both groups in order, got %v", tags)
+	}
+	if rec.Header().Get("Deprecation") != "" {
+		t.Error("Expected no Deprecation header on the current route")
+	}
+
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/items/", nil))
+	if deprecation := rec.Header().Get("Deprecation"); deprecation != "@1767225600" {
+		t.Errorf("Expected the Deprecation header, got %q", deprecation)
+	}
+	if sunset := rec.Header().Get("Sunset"); sunset != "Wed, 01 Jul 2026 00:00:00 GMT" {
+		t.Errorf("Expected the Sunset header, got %q", sunset)
+	}
+
+	routes := router.Routes()
+	if len(routes) != 2 {
+		t.Fatalf("Expected 2 routes, got %+v", routes)
+	}
+	want := Route{Method: http.MethodGet, Pattern: "/api/items/{id}", Params: []Param{{Name: "id", Type: "uint"}}}
+	if got := routes[0]; got.Method != want.Method || got.Pattern != want.Pattern || len(got.Params) != 1 || got.Params[0] != want.Params[0] || got.Deprecated {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if !routes[1].Deprecated {
+		t.Errorf("Expected the route of the deprecated group to be marked, got %+v", routes[1])
+	}
+}
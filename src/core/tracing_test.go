@@ -0,0 +1,101 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	return recorder
+}
+
+type tracedController struct{}
+
+func (c *tracedController) HandleGetItem(w http.ResponseWriter, r *http.Request) {
+	if !trace.SpanContextFromContext(r.Context()).IsValid() {
+		http.Error(w, "no span", http.StatusInternalServerError)
+	}
+}
+
+func TestTracingContinuesTraceparent(t *testing.T) {
+	recorder := recordSpans(t)
+
+	router := NewRouter()
+	group := router.Group("/tracing-test")
+	controller := &tracedController{}
+	group.HandleFunc("GET /items/{id}", controller.HandleGetItem)
+	handler := Chain(router, Tracing(), Metrics())
+
+	req := httptest.NewRequest(http.MethodGet, "/tracing-test/items/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected the handler to see a span, got status %d", rec.Code)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Expected a server and a controller span, got %d", len(spans))
+	}
+	controllerSpan, serverSpan := spans[0], spans[1]
+
+	if serverSpan.Name() != "GET /tracing-test/items/{id}" {
+		t.Errorf("Expected the server span to be named after the route, got %q", serverSpan.Name())
+	}
+	if serverSpan.SpanKind() != trace.SpanKindServer {
+		t.Errorf("Expected a server span, got %v", serverSpan.SpanKind())
+	}
+	if got := serverSpan.SpanContext().TraceID().String(); got != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the trace of the caller, got %s", got)
+	}
+	if got := serverSpan.Parent().SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("Expected the caller span as parent, got %s", got)
+	}
+
+	if controllerSpan.Name() != "tracedController.HandleGetItem" {
+		t.Errorf("Expected the controller span to be named after the handler, got %q", controllerSpan.Name())
+	}
+	if controllerSpan.Parent().SpanID() != serverSpan.SpanContext().SpanID() {
+		t.Error("Expected the controller span to be a child of the server span")
+	}
+}
+
+func TestTracingStartsTrace(t *testing.T) {
+	recorder := recordSpans(t)
+
+	handler := Chain(http.NotFoundHandler(), Tracing())
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected one span, got %d", len(spans))
+	}
+	if spans[0].Parent().IsValid() {
+		t.Error("Expected a new trace without traceparent")
+	}
+	if spans[0].Name() != http.MethodGet {
+		t.Errorf("Expected unmatched requests to be named after the method only, got %q", spans[0].Name())
+	}
+}
@@ -1,17 +1,31 @@
 package config
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"time"
 
+	appconfig "github.com/alexbsec/MiniMarketplace/src/config"
 	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// Service holds the connections to the primary, which Db returns, and to
+// the read replicas, which Reader picks from
 type Service struct {
-	db *gorm.DB
+	db       *gorm.DB
+	replicas []*replica
+	// Round robin over the replicas
+	next atomic.Uint64
+	// Stops the health checks of the replicas
+	stopChecks context.CancelFunc
 }
 
 func (s *Service) Db() (*gorm.DB, error) {
@@ -22,22 +36,90 @@ func (s *Service) Db() (*gorm.DB, error) {
     return s.db, nil
 }
 
+// Close closes the connections of the database pools
+func (s *Service) Close() error {
+    if s.stopChecks != nil {
+        s.stopChecks()
+    }
+
+    var errs []error
+    for _, r := range s.replicas {
+        errs = append(errs, closeDB(r.db))
+    }
+    if s.db != nil {
+        errs = append(errs, closeDB(s.db))
+    }
+
+    return errors.Join(errs...)
+}
+
+func closeDB(db *gorm.DB) error {
+    sqlDB, err := db.DB()
+    if err != nil {
+        return err
+    }
+
+    return sqlDB.Close()
+}
+
+// Ping checks the database answers within the context
+func (s *Service) Ping(ctx context.Context) error {
+    db, err := s.Db()
+    if err != nil {
+        return err
+    }
+
+    sqlDB, err := db.DB()
+    if err != nil {
+        return err
+    }
+
+    return sqlDB.PingContext(ctx)
+}
+
+// Version returns the version reported by the database server
+func (s *Service) Version(ctx context.Context) (string, error) {
+    db, err := s.Db()
+    if err != nil {
+        return "", err
+    }
+
+    var version string
+    err = db.WithContext(ctx).Raw("SHOW server_version").Scan(&version).Error
+    return version, err
+}
+
 func InitMockService(mockDB *gorm.DB) *Service {
 	return &Service{db: mockDB}
 }
 
-// InitService initialize the service
-func InitService() (*Service, error) {
-    host := os.Getenv("DB_HOST")
-    user := os.Getenv("DB_USER")
-    pass := os.Getenv("DB_PASSWORD")
-    dbname := os.Getenv("DB_NAME")
-    port := 5432
+// InitMockServiceWithReplicas is InitMockService with read replicas, they
+// are healthy until a health check fails
+func InitMockServiceWithReplicas(mockDB *gorm.DB, replicas ...*gorm.DB) *Service {
+	service := &Service{db: mockDB}
+	for i, db := range replicas {
+		r := &replica{addr: "replica-" + strconv.Itoa(i), db: db}
+		r.healthy.Store(true)
+		service.replicas = append(service.replicas, r)
+	}
+
+	return service
+}
 
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=disable TimeZone=UTC",
-        host, user, pass, dbname, port)
+// Longest wait between two connection attempts on startup
+const maxRetryBackoff = 30 * time.Second
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{PrepareStmt: false})
+// InitService initialize the service connecting to the database. Failed
+// connections to the primary are retried as configured, so the app can
+// start before the database is up. Replicas that can't be reached are
+// left out of the reads until their health check passes.
+func InitService(database appconfig.DatabaseConfig) (*Service, error) {
+	var db *gorm.DB
+	err := retry(database.ConnectRetries, database.RetryBackoff, func() error {
+		var err error
+		db, err = open(database, false)
+		return err
+	})
 	if err != nil {
 		logging.Log.Error(
 			"Failed to initialize service",
@@ -46,5 +128,91 @@ func InitService() (*Service, error) {
 		return nil, err
 	}
 
-	return &Service{db: db}, nil
+	service := &Service{db: db}
+	for _, addr := range database.Replicas {
+		r, err := openReplica(database, addr)
+		if err != nil {
+			service.Close()
+			return nil, err
+		}
+		service.replicas = append(service.replicas, r)
+	}
+
+	if len(service.replicas) > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		service.stopChecks = cancel
+		// Checked once before serving, then in the background
+		service.pingReplicas(ctx)
+		go service.checkReplicas(ctx, database.ReplicaCheckInterval)
+	}
+
+	return service, nil
+}
+
+// open opens the connection pool to a database and traces its queries.
+// The primary is pinged so a failure is retried, a replica is not since
+// its health checks take care of it.
+func open(database appconfig.DatabaseConfig, lazy bool) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(database.DSN()), &gorm.Config{
+		PrepareStmt:          false,
+		DisableAutomaticPing: lazy,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(database.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(database.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(database.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(database.ConnMaxIdleTime)
+
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		sqlDB.Close()
+		return nil, fmt.Errorf("failed to trace the queries: %w", err)
+	}
+
+	return db, nil
+}
+
+func openReplica(database appconfig.DatabaseConfig, addr string) (*replica, error) {
+	replicaConfig, err := database.Replica(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := open(replicaConfig, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open read replica %q: %w", addr, err)
+	}
+
+	hostPort := net.JoinHostPort(replicaConfig.Host, strconv.Itoa(replicaConfig.Port))
+	return &replica{addr: hostPort, db: db}, nil
+}
+
+// sleep is replaced by the tests
+var sleep = time.Sleep
+
+// retry calls connect until it succeeds or the retries run out, waiting
+// backoff before the first retry and doubling it after each one
+func retry(retries int, backoff time.Duration, connect func() error) error {
+	err := connect()
+	for attempt := 1; err != nil && attempt <= retries; attempt++ {
+		logging.Log.Warn(
+			"Failed to connect to the database, retrying",
+			slog.String("error", err.Error()),
+			slog.Int("attempt", attempt),
+			slog.Int("retries", retries),
+			slog.Duration("backoff", backoff),
+		)
+		sleep(backoff)
+		backoff = min(backoff*2, maxRetryBackoff)
+
+		err = connect()
+	}
+
+	return err
 }
@@ -1,12 +1,25 @@
 package config
 
 import (
+	"errors"
 	"testing"
+	"time"
+
+	appconfig "github.com/alexbsec/MiniMarketplace/src/config"
 )
 
 func TestInitService(t *testing.T) {
+	// The test profile, overridden by the DB_* variables when set
+	cfg, err := appconfig.Load([]string{"-profile", appconfig.ProfileTest})
+	if err != nil {
+		t.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Fail fast when the database is not running
+	cfg.Database.ConnectRetries = 0
+
 	// Call InitService to test if the service initializes correctly
-	service, err := InitService()
+	service, err := InitService(cfg.Database)
 
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
@@ -29,3 +42,50 @@ func TestInitService(t *testing.T) {
 	}
 }
 
+
+func TestRetry(t *testing.T) {
+	var waits []time.Duration
+	sleep = func(d time.Duration) { waits = append(waits, d) }
+	t.Cleanup(func() { sleep = time.Sleep })
+
+	attempts := 0
+	err := retry(6, 10*time.Second, func() error {
+		attempts++
+		if attempts < 5 {
+			return errors.New("connection refused")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the connection to succeed, got %v", err)
+	}
+
+	want := []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second}
+	if len(waits) != len(want) {
+		t.Fatalf("Expected waits %v, got %v", want, waits)
+	}
+	for i := range want {
+		if waits[i] != want[i] {
+			t.Errorf("Expected waits %v, got %v", want, waits)
+			break
+		}
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	sleep = func(time.Duration) {}
+	t.Cleanup(func() { sleep = time.Sleep })
+
+	attempts := 0
+	refused := errors.New("connection refused")
+	err := retry(2, time.Second, func() error {
+		attempts++
+		return refused
+	})
+	if !errors.Is(err, refused) {
+		t.Errorf("Expected the last error, got %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", attempts)
+	}
+}
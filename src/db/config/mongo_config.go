@@ -2,39 +2,53 @@ package config
 
 import (
 	"context"
-	"fmt"
-	"os"
+	"log/slog"
+	"time"
 
+	"github.com/alexbsec/MiniMarketplace/src/logging"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func Connect() {
-	user := os.Getenv("MONGO_USER")
-	password := os.Getenv("MONGO_PASSWORD")
-	cluster := os.Getenv("MONGO_CLUSTER")
+// Connect opens the MongoDB client to the uri and pings the deployment.
+// The caller owns the client and must disconnect it on shutdown.
+func Connect(uri string) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
 	// Use the SetServerAPIOptions() method to set the version of the Stable API on the client
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
-	clientURI := fmt.Sprintf("mongodb+srv://%s:%s@:%s", user, password, cluster)
-	opts := options.Client().ApplyURI(clientURI).SetServerAPIOptions(serverAPI)
+	opts := options.Client().ApplyURI(uri).SetServerAPIOptions(serverAPI)
 
 	// Create a new client and connect to the server
-	client, err := mongo.Connect(context.TODO(), opts)
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	defer func() {
-		if err = client.Disconnect(context.TODO()); err != nil {
-			panic(err)
+	// Send a ping to confirm a successful connection
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Err(); err != nil {
+		if disconnectErr := client.Disconnect(context.Background()); disconnectErr != nil {
+			logging.Log.Error("Failed to disconnect from MongoDB", slog.String("error", disconnectErr.Error()))
 		}
-	}()
+		return nil, err
+	}
 
-	// Send a ping to confirm a successful connection
-	if err := client.Database("admin").RunCommand(context.TODO(), bson.D{{"ping", 1}}).Err(); err != nil {
-		panic(err)
+	logging.Log.Info("Pinged your deployment. You successfully connected to MongoDB!")
+	return client, nil
+}
+
+// MongoVersion pings the deployment and returns its version
+func MongoVersion(ctx context.Context, client *mongo.Client) (string, error) {
+	var info struct {
+		Version string `bson:"version"`
 	}
-	fmt.Println("Pinged your deployment. You successfully connected to MongoDB!")
+
+	err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&info)
+	if err != nil {
+		return "", err
+	}
+
+	return info.Version, nil
 }
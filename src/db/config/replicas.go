@@ -0,0 +1,106 @@
+package config
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"gorm.io/gorm"
+)
+
+// Time given to a replica to answer a health check
+const replicaPingTimeout = 2 * time.Second
+
+// replica is a read-only copy of the primary, it gets reads while it
+// answers the health checks
+type replica struct {
+	// host:port, for the logs
+	addr    string
+	db      *gorm.DB
+	healthy atomic.Bool
+}
+
+// The queries of a context marked by WithPrimary read from the primary
+type primaryKey struct{}
+
+// WithPrimary makes the reads of ctx go to the primary, so they see the
+// writes made just before, e.g. by the same request
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, primaryKey{}, true)
+}
+
+// UsesPrimary tells whether the reads of ctx go to the primary
+func UsesPrimary(ctx context.Context) bool {
+	primary, _ := ctx.Value(primaryKey{}).(bool)
+	return primary
+}
+
+// Reader returns the connection for queries that only read: one of the
+// healthy replicas in turn, or the primary when ctx asks for it, when
+// there are no replicas or when none of them answers
+func (s *Service) Reader(ctx context.Context) (*gorm.DB, error) {
+	if len(s.replicas) == 0 || UsesPrimary(ctx) {
+		return s.Db()
+	}
+
+	start := s.next.Add(1)
+	for i := range s.replicas {
+		r := s.replicas[(start+uint64(i))%uint64(len(s.replicas))]
+		if r.healthy.Load() {
+			return r.db, nil
+		}
+	}
+
+	return s.Db()
+}
+
+// checkReplicas pings the replicas every interval until ctx is done
+func (s *Service) checkReplicas(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.pingReplicas(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pingReplicas updates the health of every replica, logging the changes
+func (s *Service) pingReplicas(ctx context.Context) {
+	for _, r := range s.replicas {
+		err := ping(ctx, r.db)
+		healthy := err == nil
+		if r.healthy.Swap(healthy) == healthy {
+			continue
+		}
+
+		if healthy {
+			logging.Log.Info("Read replica is healthy, routing reads to it", slog.String("replica", r.addr))
+		} else {
+			logging.Log.Warn(
+				"Read replica is failing, routing its reads elsewhere",
+				slog.String("replica", r.addr),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+}
+
+func ping(ctx context.Context, db *gorm.DB) error {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, replicaPingTimeout)
+	defer cancel()
+
+	return sqlDB.PingContext(ctx)
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	if err != nil {
+		t.Fatalf("Failed to create SQL mock: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{DisableAutomaticPing: true})
+	if err != nil {
+		t.Fatalf("Failed to create GORM DB from SQL mock: %v", err)
+	}
+
+	return db, mock
+}
+
+func TestReaderRoutesToReplicas(t *testing.T) {
+	primary, _ := newMockDB(t)
+	first, _ := newMockDB(t)
+	second, _ := newMockDB(t)
+	service := InitMockServiceWithReplicas(primary, first, second)
+
+	seen := make(map[*gorm.DB]int)
+	for i := 0; i < 4; i++ {
+		db, err := service.Reader(context.Background())
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		seen[db]++
+	}
+	if seen[first] != 2 || seen[second] != 2 {
+		t.Errorf("Expected the reads to be spread over the replicas, got %v", seen)
+	}
+
+	if db, _ := service.Reader(WithPrimary(context.Background())); db != primary {
+		t.Error("Expected the primary for a context asking for it")
+	}
+}
+
+func TestReaderFailsOverToPrimary(t *testing.T) {
+	primary, _ := newMockDB(t)
+	replicaDB, mock := newMockDB(t)
+	service := InitMockServiceWithReplicas(primary, replicaDB)
+
+	mock.ExpectPing().WillReturnError(errors.New("connection refused"))
+	service.pingReplicas(context.Background())
+	if db, _ := service.Reader(context.Background()); db != primary {
+		t.Error("Expected the primary while the replica fails")
+	}
+
+	mock.ExpectPing()
+	service.pingReplicas(context.Background())
+	if db, _ := service.Reader(context.Background()); db != replicaDB {
+		t.Error("Expected the replica once it answers again")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
@@ -0,0 +1,4 @@
+-- Create "login_attempts" table
+CREATE TABLE "public"."login_attempts" ("id" bigserial NOT NULL, "key" text NOT NULL, "failures" bigint NOT NULL, "locked" boolean NOT NULL, "last_failure" timestamptz NOT NULL, "locked_until" timestamptz NULL, PRIMARY KEY ("id"));
+-- Create index "idx_login_attempts_key" to table: "login_attempts"
+CREATE UNIQUE INDEX "idx_login_attempts_key" ON "public"."login_attempts" ("key");
@@ -0,0 +1,6 @@
+-- Create "api_keys" table
+CREATE TABLE "public"."api_keys" ("id" bigserial NOT NULL, "label" text NOT NULL, "prefix" text NOT NULL, "hash" text NOT NULL, "scopes" text NULL, "expires_at" timestamptz NULL, "last_used_at" timestamptz NULL, "revoked_at" timestamptz NULL, "created_at" timestamptz NULL, "user_id" bigint NOT NULL, PRIMARY KEY ("id"), CONSTRAINT "fk_api_keys_user" FOREIGN KEY ("user_id") REFERENCES "public"."users" ("id") ON UPDATE NO ACTION ON DELETE NO ACTION);
+-- Create index "idx_api_keys_prefix" to table: "api_keys"
+CREATE UNIQUE INDEX "idx_api_keys_prefix" ON "public"."api_keys" ("prefix");
+-- Create index "idx_api_keys_user_id" to table: "api_keys"
+CREATE INDEX "idx_api_keys_user_id" ON "public"."api_keys" ("user_id");
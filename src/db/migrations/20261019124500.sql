@@ -0,0 +1,6 @@
+-- Create "user_identities" table
+CREATE TABLE "public"."user_identities" ("id" bigserial NOT NULL, "issuer" text NOT NULL, "subject" text NOT NULL, "email" text NULL, "created_at" timestamptz NULL, "user_id" bigint NOT NULL, PRIMARY KEY ("id"), CONSTRAINT "fk_user_identities_user" FOREIGN KEY ("user_id") REFERENCES "public"."users" ("id") ON UPDATE NO ACTION ON DELETE NO ACTION);
+-- Create index "idx_user_identities_issuer_subject" to table: "user_identities"
+CREATE UNIQUE INDEX "idx_user_identities_issuer_subject" ON "public"."user_identities" ("issuer", "subject");
+-- Create index "idx_user_identities_user_id" to table: "user_identities"
+CREATE INDEX "idx_user_identities_user_id" ON "public"."user_identities" ("user_id");
@@ -0,0 +1,6 @@
+-- Create "sessions" table
+CREATE TABLE "public"."sessions" ("id" bigserial NOT NULL, "sid" text NOT NULL, "user_agent" text NULL, "ip" text NULL, "created_at" timestamptz NULL, "last_seen_at" timestamptz NOT NULL, "expires_at" timestamptz NOT NULL, "revoked_at" timestamptz NULL, "user_id" bigint NOT NULL, PRIMARY KEY ("id"), CONSTRAINT "fk_sessions_user" FOREIGN KEY ("user_id") REFERENCES "public"."users" ("id") ON UPDATE NO ACTION ON DELETE NO ACTION);
+-- Create index "idx_sessions_sid" to table: "sessions"
+CREATE UNIQUE INDEX "idx_sessions_sid" ON "public"."sessions" ("sid");
+-- Create index "idx_sessions_user_id" to table: "sessions"
+CREATE INDEX "idx_sessions_user_id" ON "public"."sessions" ("user_id");
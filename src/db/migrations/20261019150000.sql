@@ -0,0 +1,4 @@
+-- Create "outbox_events" table
+CREATE TABLE "public"."outbox_events" ("id" bigserial NOT NULL, "type" text NOT NULL, "aggregate_type" text NOT NULL, "aggregate_id" text NOT NULL, "payload" jsonb NOT NULL, "status" text NOT NULL, "attempts" bigint NOT NULL, "last_error" text NULL, "available_at" timestamptz NOT NULL, "created_at" timestamptz NULL, "delivered_at" timestamptz NULL, PRIMARY KEY ("id"));
+-- Create index "idx_outbox_events_status_available_at" to table: "outbox_events"
+CREATE INDEX "idx_outbox_events_status_available_at" ON "public"."outbox_events" ("status", "available_at");
@@ -0,0 +1,63 @@
+// Package migrations embeds the Atlas migration files, so the binary knows
+// the schema version it expects
+package migrations
+
+import (
+	"context"
+	"embed"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+//go:embed *.sql atlas.sum
+var FS embed.FS
+
+// Atlas keeps the applied revisions in this table when the database URL is
+// not bound to a schema, as in atlas.hcl
+const revisionsTable = "atlas_schema_revisions.atlas_schema_revisions"
+
+// Versions returns the versions of the migration files in order, e.g.
+// "20261019130000"
+func Versions() []string {
+	files, _ := fs.Glob(FS, "*.sql")
+
+	versions := make([]string, 0, len(files))
+	for _, file := range files {
+		version, _, _ := strings.Cut(strings.TrimSuffix(file, ".sql"), "_")
+		versions = append(versions, version)
+	}
+	sort.Strings(versions)
+
+	return versions
+}
+
+// Latest returns the version of the newest migration file
+func Latest() string {
+	versions := Versions()
+	if len(versions) == 0 {
+		return ""
+	}
+
+	return versions[len(versions)-1]
+}
+
+// Applied returns the newest version fully applied to the database, or ""
+// when none was
+func Applied(ctx context.Context, db *gorm.DB) (string, error) {
+	var versions []string
+	err := db.WithContext(ctx).
+		Raw("SELECT version FROM " + revisionsTable + " WHERE applied = total AND (error IS NULL OR error = '') ORDER BY version DESC LIMIT 1").
+		Scan(&versions).Error
+	if err != nil {
+		return "", err
+	}
+
+	if len(versions) == 0 {
+		return "", nil
+	}
+
+	return versions[0], nil
+}
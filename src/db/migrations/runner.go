@@ -0,0 +1,461 @@
+package migrations
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"gorm.io/gorm"
+)
+
+// Key of the advisory lock held while migrating, so replicas starting
+// together apply each file once
+const lockKey int64 = 7_171_979_001
+
+// Files starting with this directive run without a transaction, e.g. for
+// CREATE INDEX CONCURRENTLY, as with the Atlas CLI
+const noTxDirective = "-- atlas:txmode none"
+
+// Type of the revisions written by the runner, the one of the revisions
+// Atlas executed
+const revisionExecuted = 2
+
+// Written to the revisions as the operator_version, Atlas writes its own
+const operatorVersion = "minimarketplace"
+
+// The revisions table as the Atlas CLI creates it, so a database migrated
+// by either one can be migrated by the other
+var createRevisionsTable = []string{
+	`CREATE SCHEMA IF NOT EXISTS "atlas_schema_revisions"`,
+	`CREATE TABLE IF NOT EXISTS "atlas_schema_revisions"."atlas_schema_revisions" (
+		"version" character varying NOT NULL,
+		"description" character varying NOT NULL,
+		"type" bigint NOT NULL DEFAULT 2,
+		"applied" bigint NOT NULL DEFAULT 0,
+		"total" bigint NOT NULL DEFAULT 0,
+		"executed_at" timestamptz NOT NULL,
+		"execution_time" bigint NOT NULL,
+		"error" text NULL,
+		"error_stmt" text NULL,
+		"hash" character varying NOT NULL,
+		"partial_hashes" jsonb NULL,
+		"operator_version" character varying NOT NULL,
+		PRIMARY KEY ("version")
+	)`,
+}
+
+// ErrSumMismatch is returned when the migration files don't match
+// atlas.sum, a file was edited or added without running atlas migrate hash
+var ErrSumMismatch = errors.New("migration files do not match atlas.sum")
+
+// Migration is an embedded migration file
+type Migration struct {
+	Version     string
+	Description string
+	// Hash of the file in atlas.sum, stored with its revision
+	Hash       string
+	Statements []string
+	// Run without a transaction, see noTxDirective
+	NoTx bool
+}
+
+// Status is the state of a migration in the database
+type Status struct {
+	Version     string     `json:"version"`
+	Description string     `json:"description"`
+	Applied     bool       `json:"applied"`
+	ExecutedAt  *time.Time `json:"executed_at"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// revision is a row of the revisions table
+type revision struct {
+	Version    string
+	Applied    int
+	Total      int
+	Error      *string
+	Hash       string
+	ExecutedAt time.Time
+}
+
+func (r *revision) done() bool {
+	return r.Applied == r.Total && (r.Error == nil || *r.Error == "")
+}
+
+// Load reads the embedded migration files in order, checking them against
+// atlas.sum
+func Load() ([]Migration, error) {
+	files, err := fs.Glob(FS, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	sum, err := FS.ReadFile("atlas.sum")
+	if err != nil {
+		return nil, err
+	}
+	hashes, err := checkSum(files, sum)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, len(files))
+	for i, file := range files {
+		content, err := FS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		version, description, _ := strings.Cut(strings.TrimSuffix(file, ".sql"), "_")
+		migrations[i] = Migration{
+			Version:     version,
+			Description: description,
+			Hash:        hashes[file],
+			Statements:  splitStatements(string(content)),
+			NoTx:        bytes.HasPrefix(content, []byte(noTxDirective)),
+		}
+	}
+
+	return migrations, nil
+}
+
+// checkSum recomputes atlas.sum from the files, as Atlas does, and returns
+// the hash of each file. Each hash covers the file and the ones before it.
+func checkSum(files []string, sum []byte) (map[string]string, error) {
+	hashes := make(map[string]string, len(files))
+	cumulative := sha256.New()
+	total := sha256.New()
+	for _, file := range files {
+		content, err := FS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		cumulative.Write([]byte(file))
+		cumulative.Write(content)
+		hash := base64.StdEncoding.EncodeToString(cumulative.Sum(nil))
+		hashes[file] = hash
+
+		total.Write([]byte(file))
+		total.Write([]byte(hash))
+	}
+
+	var want strings.Builder
+	want.WriteString("h1:" + base64.StdEncoding.EncodeToString(total.Sum(nil)) + "\n")
+	for _, file := range files {
+		want.WriteString(file + " h1:" + hashes[file] + "\n")
+	}
+
+	if strings.TrimSpace(string(sum)) != strings.TrimSpace(want.String()) {
+		return nil, ErrSumMismatch
+	}
+
+	return hashes, nil
+}
+
+// splitStatements splits a file on the semicolons ending its statements,
+// leaving out the comments. Semicolons in strings, quoted identifiers and
+// dollar quoted bodies are kept.
+func splitStatements(content string) []string {
+	var statements []string
+	var current strings.Builder
+
+	flush := func() {
+		if statement := strings.TrimSpace(current.String()); statement != "" {
+			statements = append(statements, statement)
+		}
+		current.Reset()
+	}
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '-' && strings.HasPrefix(content[i:], "--"):
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				end = len(content) - i
+			}
+			i += end
+			current.WriteByte('\n')
+		case c == '/' && strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				end = len(content) - i - 2
+			}
+			i += end + 3
+			current.WriteByte(' ')
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(content[i+1:], c)
+			if end < 0 {
+				end = len(content) - i - 1
+			}
+			current.WriteString(content[i : i+end+2])
+			i += end + 1
+		case c == '$':
+			tagEnd := strings.IndexByte(content[i+1:], '$')
+			tag := ""
+			if tagEnd >= 0 {
+				tag = content[i : i+tagEnd+2]
+			}
+			if tag == "" || strings.ContainsAny(tag[1:len(tag)-1], " \t\n;'\"") {
+				current.WriteByte(c)
+				continue
+			}
+			end := strings.Index(content[i+len(tag):], tag)
+			if end < 0 {
+				end = len(content) - i - len(tag)
+			} else {
+				end += len(tag)
+			}
+			current.WriteString(content[i : i+len(tag)+end])
+			i += len(tag) + end - 1
+		case c == ';':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+
+	return statements
+}
+
+// Statuses returns the state of every migration file in the database
+func Statuses(ctx context.Context, db *gorm.DB) ([]Status, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	var exists bool
+	err = db.WithContext(ctx).Raw("SELECT to_regclass(?) IS NOT NULL", revisionsTable).Scan(&exists).Error
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := map[string]*revision{}
+	if exists {
+		revisions, err = readRevisions(db.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := make([]Status, len(migrations))
+	for i, migration := range migrations {
+		statuses[i] = Status{Version: migration.Version, Description: migration.Description}
+		if rev, ok := revisions[migration.Version]; ok {
+			executedAt := rev.ExecutedAt
+			statuses[i].ExecutedAt = &executedAt
+			statuses[i].Applied = rev.done()
+			if rev.Error != nil {
+				statuses[i].Error = *rev.Error
+			}
+		}
+	}
+
+	return statuses, nil
+}
+
+// Up applies every pending migration, see To
+func Up(ctx context.Context, db *gorm.DB) ([]string, error) {
+	return To(ctx, db, Latest())
+}
+
+// To applies the pending migrations up to and including version, in
+// order, and returns the versions applied. The other processes migrating
+// the same database wait for it to finish. Migrations are never rolled
+// back, a database past version is an error.
+func To(ctx context.Context, db *gorm.DB, version string) ([]string, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	target := -1
+	for i, migration := range migrations {
+		if migration.Version == version {
+			target = i
+		}
+	}
+	if target < 0 {
+		return nil, fmt.Errorf("unknown migration version %q", version)
+	}
+
+	var applied []string
+	err = db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		unlock, err := lock(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		// Migrations may take longer than the statement timeout of the app
+		if err := conn.Exec("SET statement_timeout = 0").Error; err != nil {
+			return err
+		}
+		defer conn.WithContext(context.WithoutCancel(ctx)).Exec("RESET statement_timeout")
+
+		for _, statement := range createRevisionsTable {
+			if err := conn.Exec(statement).Error; err != nil {
+				return fmt.Errorf("failed to create the revisions table: %w", err)
+			}
+		}
+
+		revisions, err := readRevisions(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range migrations[target+1:] {
+			if rev, ok := revisions[migration.Version]; ok && rev.done() {
+				return fmt.Errorf("database is past %s, migration %s is applied and migrations can't be rolled back", version, migration.Version)
+			}
+		}
+
+		for _, migration := range migrations[:target+1] {
+			from := 0
+			if rev, ok := revisions[migration.Version]; ok {
+				if rev.done() {
+					if rev.Hash != migration.Hash {
+						return fmt.Errorf("migration %s changed after it was applied", migration.Version)
+					}
+					continue
+				}
+				// A file run without a transaction resumes after the
+				// statements that succeeded
+				from = rev.Applied
+			}
+
+			if err := apply(ctx, conn, migration, from); err != nil {
+				return err
+			}
+			applied = append(applied, migration.Version)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// lock takes the advisory lock of the migrations, waiting for the process
+// holding it, if any
+func lock(ctx context.Context, conn *gorm.DB) (func(), error) {
+	var locked bool
+	if err := conn.Raw("SELECT pg_try_advisory_lock(?)", lockKey).Scan(&locked).Error; err != nil {
+		return nil, err
+	}
+
+	if !locked {
+		logging.Log.InfoContext(ctx, "Waiting for another process to finish migrating")
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", lockKey).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return func() {
+		// Released even when ctx is cancelled, the connection goes back
+		// to the pool
+		conn.WithContext(context.WithoutCancel(ctx)).Exec("SELECT pg_advisory_unlock(?)", lockKey)
+	}, nil
+}
+
+func readRevisions(db *gorm.DB) (map[string]*revision, error) {
+	var rows []revision
+	err := db.Raw("SELECT version, applied, total, error, hash, executed_at FROM " + revisionsTable).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make(map[string]*revision, len(rows))
+	for i := range rows {
+		revisions[rows[i].Version] = &rows[i]
+	}
+
+	return revisions, nil
+}
+
+// apply runs the statements of the migration from the given one and
+// records the revision, in the transaction of the statements unless the
+// file opts out of it
+func apply(ctx context.Context, conn *gorm.DB, migration Migration, from int) error {
+	logging.Log.InfoContext(ctx, "Applying migration",
+		slog.String("version", migration.Version),
+		slog.Int("statements", len(migration.Statements)-from),
+	)
+	start := time.Now()
+
+	run := func(tx *gorm.DB) (int, string, error) {
+		for i := from; i < len(migration.Statements); i++ {
+			if err := tx.Exec(migration.Statements[i]).Error; err != nil {
+				return i, migration.Statements[i], err
+			}
+		}
+		return len(migration.Statements), "", nil
+	}
+
+	var applied int
+	var failed string
+	var err error
+	if migration.NoTx {
+		applied, failed, err = run(conn)
+	} else {
+		err = conn.Transaction(func(tx *gorm.DB) error {
+			var runErr error
+			applied, failed, runErr = run(tx)
+			if runErr != nil {
+				return runErr
+			}
+			return record(tx, migration, applied, start, "", nil)
+		})
+		if err != nil {
+			// Rolled back, nothing of the file was applied
+			applied = from
+		}
+	}
+
+	if err != nil || migration.NoTx {
+		if recordErr := record(conn, migration, applied, start, failed, err); recordErr != nil {
+			return errors.Join(err, fmt.Errorf("failed to record migration %s: %w", migration.Version, recordErr))
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("migration %s failed: %w", migration.Version, err)
+	}
+
+	logging.Log.InfoContext(ctx, "Migration applied",
+		slog.String("version", migration.Version),
+		slog.Duration("duration", time.Since(start)),
+	)
+	return nil
+}
+
+func record(db *gorm.DB, migration Migration, applied int, start time.Time, statement string, runErr error) error {
+	var errText, errStatement *string
+	if runErr != nil {
+		message := runErr.Error()
+		errText, errStatement = &message, &statement
+	}
+
+	return db.Exec(
+		`INSERT INTO `+revisionsTable+` (version, description, type, applied, total, executed_at, execution_time, error, error_stmt, hash, operator_version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (version) DO UPDATE SET
+			applied = EXCLUDED.applied, total = EXCLUDED.total, executed_at = EXCLUDED.executed_at,
+			execution_time = EXCLUDED.execution_time, error = EXCLUDED.error, error_stmt = EXCLUDED.error_stmt,
+			hash = EXCLUDED.hash, operator_version = EXCLUDED.operator_version`,
+		migration.Version, migration.Description, revisionExecuted, applied, len(migration.Statements),
+		start, time.Since(start).Nanoseconds(), errText, errStatement, migration.Hash, operatorVersion,
+	).Error
+}
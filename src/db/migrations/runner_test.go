@@ -0,0 +1,85 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load()
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	versions := Versions()
+	if len(migrations) != len(versions) {
+		t.Fatalf("got %d migrations, want %d", len(migrations), len(versions))
+	}
+	for i, migration := range migrations {
+		if migration.Version != versions[i] {
+			t.Errorf("migration %d is %s, want %s", i, migration.Version, versions[i])
+		}
+		if len(migration.Statements) == 0 {
+			t.Errorf("migration %s has no statements", migration.Version)
+		}
+		if migration.Hash == "" {
+			t.Errorf("migration %s has no hash", migration.Version)
+		}
+	}
+}
+
+func TestCheckSumMismatch(t *testing.T) {
+	files := []string{"20250204194328.sql", "20250205115252.sql"}
+	sum, err := FS.ReadFile("atlas.sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the first two files are listed, the sum covers all of them
+	if _, err := checkSum(files, sum); !errors.Is(err, ErrSumMismatch) {
+		t.Errorf("got %v, want ErrSumMismatch", err)
+	}
+
+	// Every file, with the total hash altered
+	tampered := strings.Replace(string(sum), "h1:", "h1:x", 1)
+	var all []string
+	for _, version := range Versions() {
+		all = append(all, version+".sql")
+	}
+	if _, err := checkSum(all, []byte(tampered)); !errors.Is(err, ErrSumMismatch) {
+		t.Errorf("got %v, want ErrSumMismatch", err)
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	content := `-- Create "users" table
+CREATE TABLE "users" ("id" bigserial, "name" text DEFAULT 'a;b');
+/* block; comment */ CREATE INDEX "idx" ON "users" ("name");
+CREATE FUNCTION touch() RETURNS trigger AS $body$
+BEGIN
+	NEW.updated_at = now();
+	RETURN NEW;
+END;
+$body$ LANGUAGE plpgsql;
+-- trailing comment`
+
+	got := splitStatements(content)
+	want := []string{
+		`CREATE TABLE "users" ("id" bigserial, "name" text DEFAULT 'a;b')`,
+		`CREATE INDEX "idx" ON "users" ("name")`,
+		"CREATE FUNCTION touch() RETURNS trigger AS $body$\nBEGIN\n\tNEW.updated_at = now();\n\tRETURN NEW;\nEND;\n$body$ LANGUAGE plpgsql",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestToUnknownVersion(t *testing.T) {
+	// The version is checked before the database is used
+	if _, err := To(context.Background(), nil, "19700101000000"); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+}
@@ -0,0 +1,219 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+	"gorm.io/gorm"
+)
+
+// APIKey is a personal key used by machine clients instead of a password.
+// Only the SHA-256 of the key is stored, the prefix is kept in clear so the
+// owner can tell keys apart and so it can be looked up.
+type APIKey struct {
+	ID         uint       `gorm:"primaryKey"`
+	Label      *string    `gorm:"not null" json:"label"`
+	Prefix     string     `gorm:"uniqueIndex;not null" json:"prefix"`
+	Hash       string     `gorm:"not null" json:"-"`
+	Scopes     *string    `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	User       User       `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// ScopeList returns the scopes of the key, an empty list grants full access
+func (k *APIKey) ScopeList() []string {
+	if k.Scopes == nil {
+		return []string{}
+	}
+
+	return strings.Fields(*k.Scopes)
+}
+
+// Active reports whether the key is neither revoked nor expired
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+type APIKeyService struct {
+	Service *config.Service
+}
+
+func (ks *APIKeyService) Create(ctx context.Context, key *APIKey) error {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Create")
+	defer span.End()
+
+	if !ks.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	return models_utils.DoTransaction(ctx, ks.Service, models_utils.CREATE, func(tx *gorm.DB) error {
+		if err := tx.Create(key).Error; err != nil {
+			return fmt.Errorf("failed to create api key: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (ks *APIKeyService) Fetch(ctx context.Context, id uint) (*APIKey, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Fetch")
+	defer span.End()
+
+	if !ks.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ks.Service.Reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+	res := dbGorm.WithContext(ctx).First(&key, id)
+	if res.Error != nil {
+		if res.Error == gorm.ErrRecordNotFound {
+			logging.Log.InfoContext(ctx, "API key not found", slog.String("error", res.Error.Error()))
+			return nil, res.Error
+		}
+
+		logging.Log.ErrorContext(ctx, "Error while searching for api key", slog.String("error", res.Error.Error()))
+		return nil, res.Error
+	}
+
+	return &key, nil
+}
+
+// FetchByPrefix reads from the primary, a revoked key must stop working
+// at once
+func (ks *APIKeyService) FetchByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.FetchByPrefix")
+	defer span.End()
+
+	if !ks.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ks.Service.Db()
+	if err != nil {
+		return nil, err
+	}
+
+	var key APIKey
+	res := dbGorm.WithContext(ctx).Where("prefix = ?", prefix).First(&key)
+	if res.Error != nil {
+		if res.Error == gorm.ErrRecordNotFound {
+			logging.Log.InfoContext(ctx, "API key not found", slog.String("prefix", prefix))
+			return nil, res.Error
+		}
+
+		logging.Log.ErrorContext(ctx, "Error while searching for api key", slog.String("error", res.Error.Error()))
+		return nil, res.Error
+	}
+
+	return &key, nil
+}
+
+func (ks *APIKeyService) ListByUser(ctx context.Context, userID uint) ([]APIKey, error) {
+	ctx, span := tracing.Start(ctx, "APIKeyService.ListByUser")
+	defer span.End()
+
+	if !ks.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ks.Service.Reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []APIKey
+	if err := dbGorm.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&keys).Error; err != nil {
+		logging.Log.ErrorContext(ctx, "Error while listing api keys", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+func (ks *APIKeyService) UpdateLabel(ctx context.Context, id uint, label string) error {
+	ctx, span := tracing.Start(ctx, "APIKeyService.UpdateLabel")
+	defer span.End()
+
+	if !ks.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	return models_utils.DoTransaction(ctx, ks.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		res := tx.Model(&APIKey{}).Where("id = ?", id).Update("label", label)
+		if res.Error != nil {
+			return fmt.Errorf("failed to update api key with id %d: %w", id, res.Error)
+		}
+
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("api key with id %d not found: %w", id, gorm.ErrRecordNotFound)
+		}
+
+		return nil
+	})
+}
+
+func (ks *APIKeyService) Revoke(ctx context.Context, id uint) error {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Revoke")
+	defer span.End()
+
+	if !ks.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	return models_utils.DoTransaction(ctx, ks.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		res := tx.Model(&APIKey{}).
+			Where("id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", time.Now())
+		if res.Error != nil {
+			return fmt.Errorf("failed to revoke api key with id %d: %w", id, res.Error)
+		}
+
+		return nil
+	})
+}
+
+// Touch records the usage of the key. It is done outside of DoTransaction
+// since it runs on every authenticated request.
+func (ks *APIKeyService) Touch(ctx context.Context, id uint, usedAt time.Time) error {
+	ctx, span := tracing.Start(ctx, "APIKeyService.Touch")
+	defer span.End()
+
+	if !ks.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ks.Service.Db()
+	if err != nil {
+		return err
+	}
+
+	return dbGorm.WithContext(ctx).Model(&APIKey{}).Where("id = ?", id).Update("last_used_at", usedAt).Error
+}
+
+func (ks *APIKeyService) isServiceRunning() bool {
+	if ks.Service == nil {
+		logging.Log.Error("API Key Service is not initialized! Aborting")
+	}
+
+	return ks.Service != nil
+}
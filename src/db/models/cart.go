@@ -1,12 +1,14 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 
 	"github.com/alexbsec/MiniMarketplace/src/db/config"
 	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
 	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
 	"gorm.io/gorm"
 )
 
@@ -22,12 +24,15 @@ type CartService struct {
 	Service *config.Service
 }
 
-func (cs *CartService) Create(cart *Cart) error {
+func (cs *CartService) Create(ctx context.Context, cart *Cart) error {
+	ctx, span := tracing.Start(ctx, "CartService.Create")
+	defer span.End()
+
 	if !cs.isServiceRunning() {
 		return fmt.Errorf("Cannot proceed because service is offline")
 	}
 
-	return models_utils.DoTransaction(cs.Service, models_utils.CREATE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, cs.Service, models_utils.CREATE, func(tx *gorm.DB) error {
 		if err := tx.Create(cart).Error; err != nil {
 			return fmt.Errorf("failed to create cart: %w", err)
 		}
@@ -36,37 +41,43 @@ func (cs *CartService) Create(cart *Cart) error {
 	})
 }
 
-func (cs *CartService) FetchByUser(userID uint) (*Cart, error) {
+func (cs *CartService) FetchByUser(ctx context.Context, userID uint) (*Cart, error) {
+	ctx, span := tracing.Start(ctx, "CartService.FetchByUser")
+	defer span.End()
+
 	if !cs.isServiceRunning() {
 		return nil, fmt.Errorf("Cannot proceed because service is offline")
 	}
 
-	dbGorm, err := cs.Service.Db()
+	dbGorm, err := cs.Service.Reader(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	var cart Cart
-	res := dbGorm.Where("user_id = ?", userID).First(&cart)
+	res := dbGorm.WithContext(ctx).Where("user_id = ?", userID).First(&cart)
 	if res.Error != nil {
 		if res.Error == gorm.ErrRecordNotFound {
-			logging.Log.Error("Carrinho não encontrado", slog.String("error", res.Error.Error()))
+			logging.Log.ErrorContext(ctx, "Cart not found", slog.String("error", res.Error.Error()))
 			return nil, res.Error
 		}
 
-		logging.Log.Error("Error while searching for cart", slog.String("error", res.Error.Error()))
+		logging.Log.ErrorContext(ctx, "Error while searching for cart", slog.String("error", res.Error.Error()))
 		return nil, res.Error
 	}
 
 	return &cart, nil
 }
 
-func (cs *CartService) UpdateByUser(userID uint, newCart *Cart) error {
+func (cs *CartService) UpdateByUser(ctx context.Context, userID uint, newCart *Cart) error {
+	ctx, span := tracing.Start(ctx, "CartService.UpdateByUser")
+	defer span.End()
+
 	if !cs.isServiceRunning() {
 		return fmt.Errorf("Cannot proceed because service is offline")
 	}
 
-	return models_utils.DoTransaction(cs.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, cs.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
 		var cart Cart
 		if err := tx.Where("user_id = ?", userID).First(&cart).Error; err != nil {
 			return fmt.Errorf("cart does not exist")
@@ -80,12 +91,15 @@ func (cs *CartService) UpdateByUser(userID uint, newCart *Cart) error {
 	})
 }
 
-func (cs *CartService) DeleteByUser(userID uint) error {
+func (cs *CartService) DeleteByUser(ctx context.Context, userID uint) error {
+	ctx, span := tracing.Start(ctx, "CartService.DeleteByUser")
+	defer span.End()
+
 	if !cs.isServiceRunning() {
 		return fmt.Errorf("Cannot proceed because service is offline")
 
 	}
-	return models_utils.DoTransaction(cs.Service, models_utils.DELETE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, cs.Service, models_utils.DELETE, func(tx *gorm.DB) error {
 		if err := tx.Where("user_id = ?", userID).Delete(&Cart{}); err != nil {
 			return fmt.Errorf("cart not found")
 		}
@@ -0,0 +1,467 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+	"gorm.io/gorm"
+)
+
+// LoginAttempt holds the failed login counter of a single throttle key,
+// e.g. "account:john@doe.com" or "ip:10.0.0.1"
+type LoginAttempt struct {
+	ID          uint       `gorm:"primaryKey"`
+	Key         string     `gorm:"uniqueIndex;not null" json:"key"`
+	Failures    uint       `gorm:"not null" json:"failures"`
+	Locked      bool       `gorm:"not null" json:"locked"`
+	LastFailure time.Time  `gorm:"not null" json:"last_failure"`
+	LockedUntil *time.Time `json:"locked_until"`
+}
+
+// ThrottlePolicy controls how long a key must wait after failing to login.
+// After FreeFailures every failure doubles the wait starting at BaseDelay
+// (capped at MaxDelay) and after MaxFailures the key is locked for
+// LockoutDuration, a key is never locked when MaxFailures is 0. Counters
+// are forgotten when no failure happens during Window.
+type ThrottlePolicy struct {
+	FreeFailures    uint
+	MaxFailures     uint
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutDuration time.Duration
+	Window          time.Duration
+}
+
+// DefaultThrottlePolicy applies to the accounts
+var DefaultThrottlePolicy = ThrottlePolicy{
+	MaxFailures:     5,
+	BaseDelay:       time.Second,
+	MaxDelay:        30 * time.Second,
+	LockoutDuration: 15 * time.Minute,
+	Window:          time.Hour,
+}
+
+// DefaultAddressThrottlePolicy applies to the IP addresses. Many users may
+// share one behind a proxy or a NAT, so it allows more failures and is
+// never locked, otherwise anyone could keep all of them from logging in.
+var DefaultAddressThrottlePolicy = ThrottlePolicy{
+	FreeFailures: 50,
+	BaseDelay:    time.Second,
+	MaxDelay:     30 * time.Second,
+	Window:       time.Hour,
+}
+
+// Prefix of the keys following the address policy of a throttle
+const addressKeyPrefix = "ip:"
+
+func AccountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func AddressThrottleKey(ip string) string {
+	return addressKeyPrefix + ip
+}
+
+// LoginThrottle keeps track of failed logins. An attempt is counted before
+// the credentials are verified, so concurrent attempts can't get past the
+// failures allowed before a lockout.
+type LoginThrottle interface {
+	// Reserve counts an attempt of the key and returns its state. When the
+	// key must still wait after previous failures, or the attempts in
+	// progress already reach the lockout, nothing is counted and the wait
+	// is returned instead.
+	Reserve(ctx context.Context, key string) (*LoginAttempt, time.Duration, error)
+	// Fail records that a reserved attempt failed, making the key wait
+	Fail(ctx context.Context, key string) (*LoginAttempt, error)
+	// Release takes back an attempt reserved by a login that succeeded
+	Release(ctx context.Context, key string) error
+	// Reset clears the counter of the key, unlocking it
+	Reset(ctx context.Context, key string) error
+}
+
+// expire forgets the failures of the attempt once the window or the
+// lockout is over at now
+func (p ThrottlePolicy) expire(attempt *LoginAttempt, now time.Time) {
+	expired := now.Sub(attempt.LastFailure) > p.Window
+	lockoutOver := attempt.Locked && attempt.LockedUntil != nil && !now.Before(*attempt.LockedUntil)
+	if expired || lockoutOver {
+		attempt.Failures = 0
+		attempt.Locked = false
+		attempt.LockedUntil = nil
+	}
+}
+
+// wait returns how long the key of the expired attempt must wait before
+// another attempt is reserved
+func (p ThrottlePolicy) wait(attempt *LoginAttempt, now time.Time) time.Duration {
+	if wait := remaining(attempt, now); wait > 0 {
+		return wait
+	}
+
+	// The attempts in progress lock the key if they all fail
+	if p.MaxFailures > 0 && attempt.Failures >= p.MaxFailures {
+		return p.LockoutDuration
+	}
+
+	return 0
+}
+
+// reserve counts a new attempt happening at now
+func (p ThrottlePolicy) reserve(attempt *LoginAttempt, now time.Time) {
+	p.expire(attempt, now)
+	attempt.Failures++
+	attempt.LastFailure = now
+}
+
+// fail makes the key of the reserved attempt wait after it failed at now
+func (p ThrottlePolicy) fail(attempt *LoginAttempt, now time.Time) {
+	attempt.LastFailure = now
+	if p.MaxFailures > 0 && attempt.Failures >= p.MaxFailures {
+		lockedUntil := now.Add(p.LockoutDuration)
+		attempt.Locked = true
+		attempt.LockedUntil = &lockedUntil
+		return
+	}
+	if attempt.Failures <= p.FreeFailures {
+		return
+	}
+
+	wait := p.BaseDelay << (attempt.Failures - p.FreeFailures - 1)
+	if wait > p.MaxDelay || wait <= 0 {
+		wait = p.MaxDelay
+	}
+
+	// A concurrent failure may already wait longer
+	lockedUntil := now.Add(wait)
+	if attempt.LockedUntil == nil || lockedUntil.After(*attempt.LockedUntil) {
+		attempt.LockedUntil = &lockedUntil
+	}
+}
+
+func remaining(attempt *LoginAttempt, now time.Time) time.Duration {
+	if attempt == nil || attempt.LockedUntil == nil {
+		return 0
+	}
+
+	wait := attempt.LockedUntil.Sub(now)
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+// How often MemoryLoginThrottle drops the counters it can forget
+const memorySweepInterval = time.Minute
+
+// MemoryLoginThrottle keeps the counters in the process memory. Counters are
+// lost on restart and are not shared between replicas.
+type MemoryLoginThrottle struct {
+	Policy        ThrottlePolicy
+	AddressPolicy ThrottlePolicy
+	Now           func() time.Time
+
+	mutex     sync.Mutex
+	attempts  map[string]*LoginAttempt
+	lastSweep time.Time
+}
+
+func NewMemoryLoginThrottle(policy ThrottlePolicy, addressPolicy ThrottlePolicy) *MemoryLoginThrottle {
+	return &MemoryLoginThrottle{
+		Policy:        policy,
+		AddressPolicy: addressPolicy,
+		Now:           time.Now,
+		attempts:      make(map[string]*LoginAttempt),
+	}
+}
+
+func (mt *MemoryLoginThrottle) Reserve(ctx context.Context, key string) (*LoginAttempt, time.Duration, error) {
+	mt.mutex.Lock()
+	defer mt.mutex.Unlock()
+
+	now := mt.Now()
+	mt.sweep(now)
+
+	policy := mt.policy(key)
+	attempt, ok := mt.attempts[key]
+	if !ok {
+		attempt = &LoginAttempt{Key: key, LastFailure: now}
+	}
+
+	policy.expire(attempt, now)
+	if wait := policy.wait(attempt, now); wait > 0 {
+		return nil, wait, nil
+	}
+
+	policy.reserve(attempt, now)
+	mt.attempts[key] = attempt
+
+	out := *attempt
+	return &out, 0, nil
+}
+
+func (mt *MemoryLoginThrottle) Fail(ctx context.Context, key string) (*LoginAttempt, error) {
+	mt.mutex.Lock()
+	defer mt.mutex.Unlock()
+
+	// Reset in between, nothing left to wait for
+	attempt, ok := mt.attempts[key]
+	if !ok {
+		return &LoginAttempt{Key: key}, nil
+	}
+
+	mt.policy(key).fail(attempt, mt.Now())
+
+	out := *attempt
+	return &out, nil
+}
+
+func (mt *MemoryLoginThrottle) Release(ctx context.Context, key string) error {
+	mt.mutex.Lock()
+	defer mt.mutex.Unlock()
+
+	attempt, ok := mt.attempts[key]
+	if !ok {
+		return nil
+	}
+
+	if attempt.Failures > 0 {
+		attempt.Failures--
+	}
+	if attempt.Failures == 0 && remaining(attempt, mt.Now()) == 0 {
+		delete(mt.attempts, key)
+	}
+	return nil
+}
+
+func (mt *MemoryLoginThrottle) Reset(ctx context.Context, key string) error {
+	mt.mutex.Lock()
+	defer mt.mutex.Unlock()
+
+	delete(mt.attempts, key)
+	return nil
+}
+
+// Len returns how many keys have a counter
+func (mt *MemoryLoginThrottle) Len() int {
+	mt.mutex.Lock()
+	defer mt.mutex.Unlock()
+
+	return len(mt.attempts)
+}
+
+func (mt *MemoryLoginThrottle) policy(key string) ThrottlePolicy {
+	if strings.HasPrefix(key, addressKeyPrefix) {
+		return mt.AddressPolicy
+	}
+	return mt.Policy
+}
+
+// sweep drops the counters whose failures are forgotten and whose wait is
+// over, so keys sent once don't stay in memory. It runs at most once per
+// memorySweepInterval.
+func (mt *MemoryLoginThrottle) sweep(now time.Time) {
+	if now.Sub(mt.lastSweep) < memorySweepInterval {
+		return
+	}
+	mt.lastSweep = now
+
+	for key, attempt := range mt.attempts {
+		if now.Sub(attempt.LastFailure) > mt.policy(key).Window && remaining(attempt, now) == 0 {
+			delete(mt.attempts, key)
+		}
+	}
+}
+
+// Whether the failures of a key are forgotten, as in ThrottlePolicy.expire
+const expiredAttempt = `(login_attempts.last_failure < @window_start
+	OR (login_attempts.locked AND login_attempts.locked_until <= CAST(@now AS timestamptz)))`
+
+// reserveQuery counts an attempt in a single statement, so concurrent
+// first attempts of a key don't both insert it. The update follows
+// ThrottlePolicy.reserve and is skipped while the key must wait, as in
+// ThrottlePolicy.wait, no row is returned then.
+var reserveQuery = fmt.Sprintf(`INSERT INTO login_attempts (key, failures, locked, last_failure)
+VALUES (@key, 1, false, @now)
+ON CONFLICT (key) DO UPDATE SET
+	failures = CASE WHEN %[1]s THEN 1 ELSE login_attempts.failures + 1 END,
+	locked = CASE WHEN %[1]s THEN false ELSE login_attempts.locked END,
+	locked_until = CASE WHEN %[1]s THEN NULL ELSE login_attempts.locked_until END,
+	last_failure = @now
+WHERE %[1]s OR (
+	(login_attempts.locked_until IS NULL OR login_attempts.locked_until <= CAST(@now AS timestamptz))
+	AND (@max_failures = 0 OR login_attempts.failures < @max_failures)
+)
+RETURNING *`, expiredAttempt)
+
+// Whether a failure locks the key, as in ThrottlePolicy.fail
+const lockingFailure = `(@max_failures > 0 AND failures >= @max_failures)`
+
+// failQuery makes the key wait after a failure, as ThrottlePolicy.fail.
+// The exponent is capped so the wait does not overflow.
+var failQuery = fmt.Sprintf(`UPDATE login_attempts SET
+	last_failure = @now,
+	locked = locked OR %[1]s,
+	locked_until = CASE
+		WHEN %[1]s THEN CAST(@now AS timestamptz) + make_interval(secs => CAST(@lockout AS float8))
+		WHEN failures > @free_failures THEN GREATEST(
+			COALESCE(locked_until, CAST(@now AS timestamptz)),
+			CAST(@now AS timestamptz) + make_interval(secs => LEAST(
+				CAST(@base_delay AS float8) * power(2, LEAST(failures - @free_failures - 1, 32)),
+				CAST(@max_delay AS float8)
+			))
+		)
+		ELSE locked_until
+	END
+WHERE key = @key
+RETURNING *`, lockingFailure)
+
+// PostgresLoginThrottle keeps the counters in the login_attempts table so
+// they are shared between replicas and survive restarts
+type PostgresLoginThrottle struct {
+	Service       *config.Service
+	Policy        ThrottlePolicy
+	AddressPolicy ThrottlePolicy
+}
+
+func (pt *PostgresLoginThrottle) Reserve(ctx context.Context, key string) (*LoginAttempt, time.Duration, error) {
+	ctx, span := tracing.Start(ctx, "PostgresLoginThrottle.Reserve")
+	defer span.End()
+
+	if !pt.isServiceRunning() {
+		return nil, 0, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	policy := pt.policy(key)
+	var attempt LoginAttempt
+	var wait time.Duration
+	err := models_utils.DoTransaction(ctx, pt.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		for {
+			now := time.Now()
+			res := tx.Raw(reserveQuery, map[string]any{
+				"key":          key,
+				"now":          now,
+				"window_start": now.Add(-policy.Window),
+				"max_failures": policy.MaxFailures,
+			}).Scan(&attempt)
+			if res.Error != nil {
+				return fmt.Errorf("failed to record login attempt: %w", res.Error)
+			}
+			if res.RowsAffected > 0 {
+				return nil
+			}
+
+			// The key is waiting, read for how long
+			var current LoginAttempt
+			if err := tx.Where("key = ?", key).Limit(1).Find(&current).Error; err != nil {
+				return fmt.Errorf("failed to fetch login attempts: %w", err)
+			}
+			// Otherwise the wait ended in between, the attempt is counted
+			now = time.Now()
+			policy.expire(&current, now)
+			if wait = policy.wait(&current, now); wait > 0 {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if wait > 0 {
+		return nil, wait, nil
+	}
+
+	return &attempt, 0, nil
+}
+
+func (pt *PostgresLoginThrottle) Fail(ctx context.Context, key string) (*LoginAttempt, error) {
+	ctx, span := tracing.Start(ctx, "PostgresLoginThrottle.Fail")
+	defer span.End()
+
+	if !pt.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	policy := pt.policy(key)
+	attempt := LoginAttempt{Key: key}
+	err := models_utils.DoTransaction(ctx, pt.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		// No row when the key was reset in between
+		err := tx.Raw(failQuery, map[string]any{
+			"key":           key,
+			"now":           time.Now(),
+			"free_failures": policy.FreeFailures,
+			"max_failures":  policy.MaxFailures,
+			"base_delay":    policy.BaseDelay.Seconds(),
+			"max_delay":     policy.MaxDelay.Seconds(),
+			"lockout":       policy.LockoutDuration.Seconds(),
+		}).Scan(&attempt).Error
+		if err != nil {
+			return fmt.Errorf("failed to record login failure: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &attempt, nil
+}
+
+func (pt *PostgresLoginThrottle) Release(ctx context.Context, key string) error {
+	ctx, span := tracing.Start(ctx, "PostgresLoginThrottle.Release")
+	defer span.End()
+
+	if !pt.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	return models_utils.DoTransaction(ctx, pt.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		err := tx.Model(&LoginAttempt{}).Where("key = ?", key).
+			Update("failures", gorm.Expr("GREATEST(failures - 1, 0)")).Error
+		if err != nil {
+			return fmt.Errorf("failed to release login attempt: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (pt *PostgresLoginThrottle) Reset(ctx context.Context, key string) error {
+	ctx, span := tracing.Start(ctx, "PostgresLoginThrottle.Reset")
+	defer span.End()
+
+	if !pt.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	return models_utils.DoTransaction(ctx, pt.Service, models_utils.DELETE, func(tx *gorm.DB) error {
+		if err := tx.Where("key = ?", key).Delete(&LoginAttempt{}).Error; err != nil {
+			return fmt.Errorf("failed to reset login attempts: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (pt *PostgresLoginThrottle) policy(key string) ThrottlePolicy {
+	if strings.HasPrefix(key, addressKeyPrefix) {
+		return pt.AddressPolicy
+	}
+	return pt.Policy
+}
+
+func (pt *PostgresLoginThrottle) isServiceRunning() bool {
+	if pt.Service == nil {
+		logging.Log.Error("Login Throttle Service is not initialized! Aborting")
+	}
+
+	return pt.Service != nil
+}
@@ -0,0 +1,339 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Statuses of an outbox event
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxFailed    = "failed"
+)
+
+// ErrOutboxEventNotFailed is returned when replaying an event that did not
+// fail
+var ErrOutboxEventNotFailed = errors.New("outbox event did not fail")
+
+// OutboxEvent is a domain event waiting to be published. It is written in
+// the transaction of the change it describes, so it exists if and only if
+// the change was committed.
+type OutboxEvent struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	Type          string          `gorm:"not null" json:"type"`
+	AggregateType string          `gorm:"not null" json:"aggregate_type"`
+	AggregateID   string          `gorm:"not null" json:"aggregate_id"`
+	Payload       json.RawMessage `gorm:"type:jsonb;not null" json:"payload"`
+	Status        string          `gorm:"not null;index:idx_outbox_events_status_available_at,priority:1" json:"status"`
+	Attempts      uint            `gorm:"not null" json:"attempts"`
+	LastError     *string         `json:"last_error"`
+	AvailableAt   time.Time       `gorm:"not null;index:idx_outbox_events_status_available_at,priority:2" json:"available_at"`
+	CreatedAt     time.Time       `json:"created_at"`
+	DeliveredAt   *time.Time      `json:"delivered_at"`
+}
+
+// EnqueueEvent adds an event to the outbox. tx must be the transaction of
+// the change the event describes; payload is encoded as JSON.
+func EnqueueEvent(tx *gorm.DB, eventType string, aggregateType string, aggregateID uint, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+
+	event := OutboxEvent{
+		Type:          eventType,
+		AggregateType: aggregateType,
+		AggregateID:   strconv.FormatUint(uint64(aggregateID), 10),
+		Payload:       body,
+		Status:        OutboxPending,
+		AvailableAt:   time.Now(),
+	}
+	if err := tx.Create(&event).Error; err != nil {
+		return fmt.Errorf("failed to enqueue %s event: %w", eventType, err)
+	}
+
+	return nil
+}
+
+// OutboxRetryPolicy controls when a failed delivery is tried again. The
+// wait doubles after every attempt starting at BaseDelay (capped at
+// MaxDelay) and after MaxAttempts the event is marked failed. Lease is how
+// long claimed events are kept from the other dispatchers, it must be
+// longer than delivering a batch.
+type OutboxRetryPolicy struct {
+	MaxAttempts uint
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Lease       time.Duration
+}
+
+var DefaultOutboxRetryPolicy = OutboxRetryPolicy{
+	MaxAttempts: 10,
+	BaseDelay:   5 * time.Second,
+	MaxDelay:    time.Hour,
+	Lease:       5 * time.Minute,
+}
+
+// apply records the outcome of a delivery attempt made at now
+func (p OutboxRetryPolicy) apply(event *OutboxEvent, deliveryErr error, now time.Time) {
+	event.Attempts++
+	if deliveryErr == nil {
+		event.Status = OutboxDelivered
+		event.DeliveredAt = &now
+		event.LastError = nil
+		return
+	}
+
+	message := deliveryErr.Error()
+	event.LastError = &message
+	if event.Attempts >= p.MaxAttempts {
+		event.Status = OutboxFailed
+		return
+	}
+
+	wait := p.BaseDelay << (event.Attempts - 1)
+	if wait > p.MaxDelay || wait <= 0 {
+		wait = p.MaxDelay
+	}
+	event.AvailableAt = now.Add(wait)
+}
+
+// OutboxService reads and updates the outbox. Events are added with
+// EnqueueEvent by the services writing the changes.
+type OutboxService struct {
+	Service *config.Service
+}
+
+// Dispatch delivers up to limit due events and records the outcome of
+// each, returning how many were processed. The events are claimed in a
+// short transaction that pushes their available_at to the end of the
+// lease, so the other replicas skip them, and are delivered once it is
+// committed. Each outcome is then recorded on its own, only while the
+// lease still holds. An event whose outcome could not be recorded is
+// delivered again after the lease, so the sinks must be idempotent on the
+// event id.
+func (ob *OutboxService) Dispatch(
+	ctx context.Context,
+	limit int,
+	policy OutboxRetryPolicy,
+	deliver func(context.Context, *OutboxEvent) error) (int, error) {
+	ctx, span := tracing.Start(ctx, "OutboxService.Dispatch")
+	defer span.End()
+
+	if !ob.isServiceRunning() {
+		return 0, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ob.Service.Db()
+	if err != nil {
+		return 0, err
+	}
+
+	// Checked first so an idle outbox does not open a transaction per poll
+	var due bool
+	err = dbGorm.WithContext(ctx).Raw(
+		"SELECT EXISTS (SELECT 1 FROM outbox_events WHERE status = ? AND available_at <= ?)",
+		OutboxPending, time.Now(),
+	).Scan(&due).Error
+	if err != nil || !due {
+		return 0, err
+	}
+
+	events, leasedUntil, err := ob.claim(ctx, limit, policy.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	// Outcomes are recorded even once ctx is done, the event was published
+	recordCtx := context.WithoutCancel(ctx)
+	processed := 0
+	var errs []error
+	for i := range events {
+		// The rest is due again, another replica may have claimed it
+		if ctx.Err() != nil || !time.Now().Before(leasedUntil) {
+			break
+		}
+
+		event := &events[i]
+		policy.apply(event, deliver(ctx, event), time.Now())
+		processed++
+
+		if err := ob.record(recordCtx, dbGorm, event, leasedUntil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return processed, errors.Join(errs...)
+}
+
+// claim leases up to limit due events until the returned time
+func (ob *OutboxService) claim(ctx context.Context, limit int, lease time.Duration) ([]OutboxEvent, time.Time, error) {
+	// Postgres keeps microseconds, the lease is compared to the stored value
+	leasedUntil := time.Now().Add(lease).Truncate(time.Microsecond)
+
+	var events []OutboxEvent
+	err := models_utils.DoTransaction(ctx, ob.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		events = nil
+
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND available_at <= ?", OutboxPending, time.Now()).
+			Order("id").Limit(limit).Find(&events)
+		if res.Error != nil {
+			return fmt.Errorf("failed to fetch outbox events: %w", res.Error)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, len(events))
+		for i := range events {
+			ids[i] = events[i].ID
+			events[i].AvailableAt = leasedUntil
+		}
+
+		res = tx.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("available_at", leasedUntil)
+		if res.Error != nil {
+			return fmt.Errorf("failed to claim outbox events: %w", res.Error)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	return events, leasedUntil, nil
+}
+
+// record stores the outcome of the delivery of event, unless its lease
+// ran out and another replica claimed it
+func (ob *OutboxService) record(ctx context.Context, dbGorm *gorm.DB, event *OutboxEvent, leasedUntil time.Time) error {
+	res := dbGorm.WithContext(ctx).Model(event).
+		Where("status = ? AND available_at = ?", OutboxPending, leasedUntil).
+		Select("status", "attempts", "last_error", "available_at", "delivered_at").
+		Updates(event)
+	if res.Error != nil {
+		logging.Log.ErrorContext(ctx, "Failed to record outbox event outcome",
+			slog.Uint64("event_id", uint64(event.ID)),
+			slog.String("error", res.Error.Error()),
+		)
+		return fmt.Errorf("failed to update outbox event %d: %w", event.ID, res.Error)
+	}
+	if res.RowsAffected == 0 {
+		logging.Log.WarnContext(ctx, "Outbox event lease lost before its outcome was recorded",
+			slog.Uint64("event_id", uint64(event.ID)),
+		)
+	}
+
+	return nil
+}
+
+// List returns the latest events with the given status
+func (ob *OutboxService) List(ctx context.Context, status string, limit int) ([]OutboxEvent, error) {
+	ctx, span := tracing.Start(ctx, "OutboxService.List")
+	defer span.End()
+
+	if !ob.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ob.Service.Reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []OutboxEvent
+	res := dbGorm.WithContext(ctx).Where("status = ?", status).Order("id DESC").Limit(limit).Find(&events)
+	if res.Error != nil {
+		logging.Log.ErrorContext(ctx, "Error while listing outbox events", slog.String("error", res.Error.Error()))
+		return nil, res.Error
+	}
+
+	return events, nil
+}
+
+func (ob *OutboxService) Fetch(ctx context.Context, id uint) (*OutboxEvent, error) {
+	ctx, span := tracing.Start(ctx, "OutboxService.Fetch")
+	defer span.End()
+
+	if !ob.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ob.Service.Reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var event OutboxEvent
+	res := dbGorm.WithContext(ctx).First(&event, id)
+	if res.Error != nil {
+		if res.Error == gorm.ErrRecordNotFound {
+			logging.Log.InfoContext(ctx, "Outbox event not found", slog.String("error", res.Error.Error()))
+			return nil, res.Error
+		}
+
+		logging.Log.ErrorContext(ctx, "Error while searching for outbox event", slog.String("error", res.Error.Error()))
+		return nil, res.Error
+	}
+
+	return &event, nil
+}
+
+// Replay puts a failed event back in the queue with its attempts reset,
+// it is published on the next poll of the dispatcher
+func (ob *OutboxService) Replay(ctx context.Context, id uint) (*OutboxEvent, error) {
+	ctx, span := tracing.Start(ctx, "OutboxService.Replay")
+	defer span.End()
+
+	if !ob.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	var event OutboxEvent
+	err := models_utils.DoTransaction(ctx, ob.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&event, id).Error; err != nil {
+			return fmt.Errorf("outbox event with id %d not found: %w", id, err)
+		}
+
+		if event.Status != OutboxFailed {
+			return ErrOutboxEventNotFailed
+		}
+
+		event.Status = OutboxPending
+		event.Attempts = 0
+		event.AvailableAt = time.Now()
+		res := tx.Model(&event).Select("status", "attempts", "available_at").Updates(&event)
+		if res.Error != nil {
+			return fmt.Errorf("failed to replay outbox event %d: %w", id, res.Error)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &event, nil
+}
+
+func (ob *OutboxService) isServiceRunning() bool {
+	if ob.Service == nil {
+		logging.Log.Error("Outbox Service is not initialized! Aborting")
+	}
+
+	return ob.Service != nil
+}
@@ -1,12 +1,14 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 
 	"github.com/alexbsec/MiniMarketplace/src/db/config"
 	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
 	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
 	"gorm.io/gorm"
 )
 
@@ -24,12 +26,15 @@ type ProductService struct {
 	Service *config.Service
 }
 
-func (ps *ProductService) Create(product *Product) error {
+func (ps *ProductService) Create(ctx context.Context, product *Product) error {
+	ctx, span := tracing.Start(ctx, "ProductService.Create")
+	defer span.End()
+
     if !ps.isServiceRunning() {
         return fmt.Errorf("Cannot proceed because service is offline") 
     }
 
-	return models_utils.DoTransaction(ps.Service, models_utils.CREATE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, ps.Service, models_utils.CREATE, func(tx *gorm.DB) error {
 		if err := tx.Create(product).Error; err != nil {
 			return fmt.Errorf("failed to create product: %w", err)
 		}
@@ -38,38 +43,44 @@ func (ps *ProductService) Create(product *Product) error {
 	})
 }
 
-func (ps *ProductService) Fetch(id uint) (*Product, error) {
+func (ps *ProductService) Fetch(ctx context.Context, id uint) (*Product, error) {
+	ctx, span := tracing.Start(ctx, "ProductService.Fetch")
+	defer span.End()
+
     if !ps.isServiceRunning() {
         return nil, fmt.Errorf("Cannot proceed because service is offline") 
     }
 
-	dbGorm, err := ps.Service.Db()
+	dbGorm, err := ps.Service.Reader(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	var product Product
 
-	res := dbGorm.First(&product, id)
+	res := dbGorm.WithContext(ctx).First(&product, id)
 	if res.Error != nil {
 		if res.Error == gorm.ErrRecordNotFound {
-			logging.Log.Info("Produto não encontrado", slog.String("error", res.Error.Error()))
+			logging.Log.InfoContext(ctx, "Product not found", slog.String("error", res.Error.Error()))
 			return nil, res.Error
 		}
 
-		logging.Log.Error("Error while searching the product", slog.String("error", res.Error.Error()))
+		logging.Log.ErrorContext(ctx, "Error while searching the product", slog.String("error", res.Error.Error()))
 		return nil, res.Error
 	}
 
 	return &product, nil
 }
 
-func (ps *ProductService) Update(id uint, newProduct *Product) error {
+func (ps *ProductService) Update(ctx context.Context, id uint, newProduct *Product) error {
+	ctx, span := tracing.Start(ctx, "ProductService.Update")
+	defer span.End()
+
     if !ps.isServiceRunning() {
         return fmt.Errorf("Cannot proceed because service is offline") 
     }
 
-	return models_utils.DoTransaction(ps.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, ps.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
 		var product Product
 		if err := tx.First(&product, id).Error; err != nil {
 			return fmt.Errorf("product with id %d not found: %w", id, err)
@@ -83,11 +94,14 @@ func (ps *ProductService) Update(id uint, newProduct *Product) error {
 	})
 }
 
-func (ps *ProductService) Delete(id uint) error {
+func (ps *ProductService) Delete(ctx context.Context, id uint) error {
+	ctx, span := tracing.Start(ctx, "ProductService.Delete")
+	defer span.End()
+
     if !ps.isServiceRunning() {
         return fmt.Errorf("Cannot proceed because service is offline") 
     }
-	return models_utils.DoTransaction(ps.Service, models_utils.DELETE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, ps.Service, models_utils.DELETE, func(tx *gorm.DB) error {
 		if err := tx.Delete(&Product{}, id).Error; err != nil {
 			return fmt.Errorf("failed to delete product: %w", err)
 		}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+	"gorm.io/gorm"
+)
+
+// Session is a login of a user on a device. Tokens carry the SID of their
+// session in the "sid" claim, so revoking the session invalidates them.
+type Session struct {
+	ID         uint       `gorm:"primaryKey"`
+	SID        string     `gorm:"column:sid;uniqueIndex;not null" json:"-"`
+	UserAgent  *string    `json:"user_agent"`
+	IP         *string    `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `gorm:"not null" json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	User       User       `gorm:"foreignKey:UserID" json:"-"`
+}
+
+// Active reports whether the session is neither revoked nor expired
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+type SessionService struct {
+	Service *config.Service
+}
+
+func (ss *SessionService) Create(ctx context.Context, session *Session) error {
+	ctx, span := tracing.Start(ctx, "SessionService.Create")
+	defer span.End()
+
+	if !ss.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	return models_utils.DoTransaction(ctx, ss.Service, models_utils.CREATE, func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return fmt.Errorf("failed to create session: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (ss *SessionService) Fetch(ctx context.Context, id uint) (*Session, error) {
+	ctx, span := tracing.Start(ctx, "SessionService.Fetch")
+	defer span.End()
+
+	if !ss.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ss.Service.Reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	res := dbGorm.WithContext(ctx).First(&session, id)
+	if res.Error != nil {
+		if res.Error == gorm.ErrRecordNotFound {
+			logging.Log.InfoContext(ctx, "Session not found", slog.String("error", res.Error.Error()))
+			return nil, res.Error
+		}
+
+		logging.Log.ErrorContext(ctx, "Error while searching for session", slog.String("error", res.Error.Error()))
+		return nil, res.Error
+	}
+
+	return &session, nil
+}
+
+// FetchBySID reads from the primary, a revoked session must stop working
+// at once
+func (ss *SessionService) FetchBySID(ctx context.Context, sid string) (*Session, error) {
+	ctx, span := tracing.Start(ctx, "SessionService.FetchBySID")
+	defer span.End()
+
+	if !ss.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ss.Service.Db()
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	res := dbGorm.WithContext(ctx).Where("sid = ?", sid).First(&session)
+	if res.Error != nil {
+		if res.Error == gorm.ErrRecordNotFound {
+			logging.Log.InfoContext(ctx, "Session not found", slog.String("error", res.Error.Error()))
+			return nil, res.Error
+		}
+
+		logging.Log.ErrorContext(ctx, "Error while searching for session", slog.String("error", res.Error.Error()))
+		return nil, res.Error
+	}
+
+	return &session, nil
+}
+
+// ListActiveByUser returns the sessions of the user that can still be used
+func (ss *SessionService) ListActiveByUser(ctx context.Context, userID uint) ([]Session, error) {
+	ctx, span := tracing.Start(ctx, "SessionService.ListActiveByUser")
+	defer span.End()
+
+	if !ss.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ss.Service.Reader(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var sessions []Session
+	err = dbGorm.WithContext(ctx).Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		logging.Log.ErrorContext(ctx, "Error while listing sessions", slog.String("error", err.Error()))
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+func (ss *SessionService) Revoke(ctx context.Context, id uint) error {
+	ctx, span := tracing.Start(ctx, "SessionService.Revoke")
+	defer span.End()
+
+	if !ss.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	return models_utils.DoTransaction(ctx, ss.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		res := tx.Model(&Session{}).
+			Where("id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", time.Now())
+		if res.Error != nil {
+			return fmt.Errorf("failed to revoke session with id %d: %w", id, res.Error)
+		}
+
+		return nil
+	})
+}
+
+// Touch records activity on the session. It is done outside of
+// DoTransaction since it runs on authenticated requests.
+func (ss *SessionService) Touch(ctx context.Context, id uint, seenAt time.Time) error {
+	ctx, span := tracing.Start(ctx, "SessionService.Touch")
+	defer span.End()
+
+	if !ss.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := ss.Service.Db()
+	if err != nil {
+		return err
+	}
+
+	return dbGorm.WithContext(ctx).Model(&Session{}).Where("id = ?", id).Update("last_seen_at", seenAt).Error
+}
+
+func (ss *SessionService) isServiceRunning() bool {
+	if ss.Service == nil {
+		logging.Log.Error("Session Service is not initialized! Aborting")
+	}
+
+	return ss.Service != nil
+}
@@ -0,0 +1,100 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestAPIKeyService_FetchByPrefix(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create SQL mock: %v", err)
+	}
+	defer sqlDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sqlDB,
+	}), &gorm.Config{
+		PrepareStmt: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create GORM DB from SQL mock: %v", err)
+	}
+
+	mockService := config.InitMockService(gormDB)
+
+	prefix := "mmk_1a2b3c4d"
+	mock.ExpectQuery(`SELECT \* FROM "api_keys" WHERE prefix = \$1 ORDER BY "api_keys"\."id" LIMIT \$2`).
+		WithArgs(prefix, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "label", "prefix", "hash", "scopes", "user_id", "created_at"}).
+			AddRow(1, "ci", prefix, "hash", "products:read wallets:*", 2, time.Now()))
+
+	apiKeyService := &models.APIKeyService{
+		Service: mockService,
+	}
+
+	apiKey, err := apiKeyService.FetchByPrefix(context.Background(), prefix)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if scopes := apiKey.ScopeList(); len(scopes) != 2 || scopes[1] != "wallets:*" {
+		t.Errorf("Expected scopes [products:read wallets:*], got: %v", scopes)
+	}
+
+	if !apiKey.Active(time.Now()) {
+		t.Errorf("Expected api key to be active")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestAPIKeyService_Revoke(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create SQL mock: %v", err)
+	}
+	defer sqlDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sqlDB,
+	}), &gorm.Config{
+		PrepareStmt: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create GORM DB from SQL mock: %v", err)
+	}
+
+	mockService := config.InitMockService(gormDB)
+
+	keyID := uint(1)
+
+	mock.ExpectBegin()
+
+	mock.ExpectExec(`UPDATE "api_keys" SET "revoked_at"=\$1 WHERE id = \$2 AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), keyID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectCommit()
+
+	apiKeyService := &models.APIKeyService{
+		Service: mockService,
+	}
+
+	if err := apiKeyService.Revoke(context.Background(), keyID); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
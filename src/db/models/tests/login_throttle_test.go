@@ -0,0 +1,329 @@
+package models_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+var testThrottlePolicy = models.ThrottlePolicy{
+	MaxFailures:     3,
+	BaseDelay:       time.Second,
+	MaxDelay:        10 * time.Second,
+	LockoutDuration: time.Minute,
+	Window:          time.Hour,
+}
+
+var testAddressThrottlePolicy = models.ThrottlePolicy{
+	FreeFailures: 2,
+	BaseDelay:    time.Second,
+	MaxDelay:     10 * time.Second,
+	Window:       time.Hour,
+}
+
+func newTestMemoryThrottle(now *time.Time) *models.MemoryLoginThrottle {
+	throttle := models.NewMemoryLoginThrottle(testThrottlePolicy, testAddressThrottlePolicy)
+	throttle.Now = func() time.Time { return *now }
+	return throttle
+}
+
+func TestMemoryLoginThrottle_Backoff(t *testing.T) {
+	now := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	throttle := newTestMemoryThrottle(&now)
+
+	key := models.AccountThrottleKey("john@doe.com")
+
+	expected := []time.Duration{time.Second, 2 * time.Second}
+	for i, exp := range expected {
+		attempt, wait, err := throttle.Reserve(context.Background(), key)
+		if err != nil || wait != 0 {
+			t.Fatalf("Expected the attempt to be reserved, got a wait of %v (err: %v)", wait, err)
+		}
+		if attempt.Failures != uint(i+1) {
+			t.Errorf("Expected %d failures, got %d", i+1, attempt.Failures)
+		}
+
+		attempt, err = throttle.Fail(context.Background(), key)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if attempt.Locked {
+			t.Errorf("Expected key not to be locked after %d failures", i+1)
+		}
+
+		// Nothing is counted while the key waits
+		_, wait, _ = throttle.Reserve(context.Background(), key)
+		if wait != exp {
+			t.Errorf("Expected wait of %v after %d failures, got %v", exp, i+1, wait)
+		}
+
+		now = now.Add(wait)
+	}
+}
+
+func TestMemoryLoginThrottle_Lockout(t *testing.T) {
+	now := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	throttle := newTestMemoryThrottle(&now)
+
+	key := models.AccountThrottleKey("john@doe.com")
+
+	var attempt *models.LoginAttempt
+	for i := uint(0); i < testThrottlePolicy.MaxFailures; i++ {
+		throttle.Reserve(context.Background(), key)
+		attempt, _ = throttle.Fail(context.Background(), key)
+		now = now.Add(testThrottlePolicy.MaxDelay)
+	}
+
+	if !attempt.Locked {
+		t.Fatalf("Expected key to be locked after %d failures", testThrottlePolicy.MaxFailures)
+	}
+
+	lockedUntil := *attempt.LockedUntil
+	if _, wait, _ := throttle.Reserve(context.Background(), key); wait != lockedUntil.Sub(now) {
+		t.Errorf("Expected wait of %v, got %v", lockedUntil.Sub(now), wait)
+	}
+
+	// Once the lockout expires the counter starts over
+	now = lockedUntil
+	attempt, _, _ = throttle.Reserve(context.Background(), key)
+	if attempt.Failures != 1 || attempt.Locked {
+		t.Errorf("Expected counter to restart after lockout, got %d failures (locked: %v)", attempt.Failures, attempt.Locked)
+	}
+}
+
+func TestMemoryLoginThrottle_ConcurrentReservations(t *testing.T) {
+	now := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	throttle := newTestMemoryThrottle(&now)
+
+	key := models.AccountThrottleKey("john@doe.com")
+
+	// Attempts in progress don't wait for each other
+	for i := uint(0); i < testThrottlePolicy.MaxFailures; i++ {
+		if _, wait, _ := throttle.Reserve(context.Background(), key); wait != 0 {
+			t.Fatalf("Expected attempt %d to be reserved, got a wait of %v", i+1, wait)
+		}
+	}
+
+	// Up to the lockout, should they all fail
+	if _, wait, _ := throttle.Reserve(context.Background(), key); wait != testThrottlePolicy.LockoutDuration {
+		t.Errorf("Expected a wait of %v, got %v", testThrottlePolicy.LockoutDuration, wait)
+	}
+}
+
+func TestMemoryLoginThrottle_AddressPolicy(t *testing.T) {
+	now := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	throttle := newTestMemoryThrottle(&now)
+
+	key := models.AddressThrottleKey("10.0.0.1")
+
+	// Free failures first, then only a backoff, way past the lockout of
+	// the accounts
+	for i := uint(1); i <= testThrottlePolicy.MaxFailures*3; i++ {
+		_, wait, _ := throttle.Reserve(context.Background(), key)
+		if wait != 0 {
+			t.Fatalf("Expected attempt %d to be reserved, got a wait of %v", i, wait)
+		}
+
+		attempt, _ := throttle.Fail(context.Background(), key)
+		if attempt.Locked {
+			t.Fatalf("Expected an address never to be locked, got %+v", attempt)
+		}
+
+		_, wait, _ = throttle.Reserve(context.Background(), key)
+		if i <= testAddressThrottlePolicy.FreeFailures && wait != 0 {
+			t.Errorf("Expected no wait after %d failures, got %v", i, wait)
+		}
+		if i > testAddressThrottlePolicy.FreeFailures && (wait <= 0 || wait > testAddressThrottlePolicy.MaxDelay) {
+			t.Errorf("Expected a backoff after %d failures, got %v", i, wait)
+		}
+		if wait == 0 {
+			throttle.Release(context.Background(), key)
+		}
+
+		now = now.Add(wait)
+	}
+}
+
+func TestMemoryLoginThrottle_Reset(t *testing.T) {
+	now := time.Now()
+	throttle := newTestMemoryThrottle(&now)
+
+	key := models.AccountThrottleKey("john@doe.com")
+	throttle.Reserve(context.Background(), key)
+	throttle.Fail(context.Background(), key)
+
+	if err := throttle.Reset(context.Background(), key); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, wait, _ := throttle.Reserve(context.Background(), key); wait != 0 {
+		t.Errorf("Expected no wait after reset, got %v", wait)
+	}
+}
+
+func TestMemoryLoginThrottle_Release(t *testing.T) {
+	now := time.Now()
+	throttle := newTestMemoryThrottle(&now)
+
+	key := models.AddressThrottleKey("10.0.0.1")
+	throttle.Reserve(context.Background(), key)
+
+	if err := throttle.Release(context.Background(), key); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	attempt, wait, _ := throttle.Reserve(context.Background(), key)
+	if wait != 0 {
+		t.Fatalf("Expected no wait after release, got %v", wait)
+	}
+	if attempt.Failures != 1 {
+		t.Errorf("Expected the released attempt not to count, got %d failures", attempt.Failures)
+	}
+}
+
+func TestMemoryLoginThrottle_ForgetsStaleKeys(t *testing.T) {
+	now := time.Date(2025, 2, 10, 12, 0, 0, 0, time.UTC)
+	throttle := newTestMemoryThrottle(&now)
+
+	for i := 0; i < 10; i++ {
+		key := models.AccountThrottleKey(fmt.Sprintf("user%d@doe.com", i))
+		throttle.Reserve(context.Background(), key)
+		throttle.Fail(context.Background(), key)
+	}
+	if throttle.Len() != 10 {
+		t.Fatalf("Expected 10 keys, got %d", throttle.Len())
+	}
+
+	// Their failures are forgotten and their waits are over
+	now = now.Add(testThrottlePolicy.Window + time.Minute)
+	throttle.Reserve(context.Background(), models.AccountThrottleKey("john@doe.com"))
+	if throttle.Len() != 1 {
+		t.Errorf("Expected the stale keys to be dropped, got %d keys", throttle.Len())
+	}
+}
+
+func newThrottleMock(t *testing.T) (*models.PostgresLoginThrottle, sqlmock.Sqlmock) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create SQL mock: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sqlDB,
+	}), &gorm.Config{
+		PrepareStmt: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create GORM DB from SQL mock: %v", err)
+	}
+
+	return &models.PostgresLoginThrottle{
+		Service:       config.InitMockService(gormDB),
+		Policy:        testThrottlePolicy,
+		AddressPolicy: testAddressThrottlePolicy,
+	}, mock
+}
+
+var loginAttemptColumns = []string{"id", "key", "failures", "locked", "last_failure", "locked_until"}
+
+func TestPostgresLoginThrottle_Reserve(t *testing.T) {
+	throttle, mock := newThrottleMock(t)
+
+	key := models.AccountThrottleKey("john@doe.com")
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO login_attempts .* ON CONFLICT \(key\) DO UPDATE SET .* RETURNING \*`).
+		WithArgs(
+			key, sqlmock.AnyArg(), // inserted
+			sqlmock.AnyArg(), sqlmock.AnyArg(), // failures
+			sqlmock.AnyArg(), sqlmock.AnyArg(), // locked
+			sqlmock.AnyArg(), sqlmock.AnyArg(), // locked_until
+			sqlmock.AnyArg(),                                     // last_failure
+			sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), // not waiting
+			testThrottlePolicy.MaxFailures, testThrottlePolicy.MaxFailures,
+		).
+		WillReturnRows(sqlmock.NewRows(loginAttemptColumns).
+			AddRow(1, key, 1, false, time.Now(), nil))
+	mock.ExpectCommit()
+
+	attempt, wait, err := throttle.Reserve(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if wait != 0 || attempt == nil || attempt.Failures != 1 || attempt.LockedUntil != nil {
+		t.Errorf("Expected the first attempt to be counted without a wait, got %+v (wait: %v)", attempt, wait)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestPostgresLoginThrottle_ReserveWhileWaiting(t *testing.T) {
+	throttle, mock := newThrottleMock(t)
+
+	key := models.AccountThrottleKey("john@doe.com")
+	lockedUntil := time.Now().Add(time.Minute)
+	mock.ExpectBegin()
+	// The update is skipped, nothing is counted
+	mock.ExpectQuery(`INSERT INTO login_attempts`).
+		WillReturnRows(sqlmock.NewRows(loginAttemptColumns))
+	mock.ExpectQuery(`SELECT \* FROM "login_attempts" WHERE key = \$1 LIMIT \$2`).
+		WithArgs(key, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows(loginAttemptColumns).
+			AddRow(1, key, 3, true, time.Now(), lockedUntil))
+	mock.ExpectCommit()
+
+	attempt, wait, err := throttle.Reserve(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if attempt != nil || wait <= 0 || wait > time.Minute {
+		t.Errorf("Expected a wait of up to one minute, got %v (attempt: %+v)", wait, attempt)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestPostgresLoginThrottle_Fail(t *testing.T) {
+	throttle, mock := newThrottleMock(t)
+
+	key := models.AddressThrottleKey("10.0.0.1")
+	lockedUntil := time.Now().Add(time.Second)
+	mock.ExpectBegin()
+	// The policy of the addresses, which never locks them
+	mock.ExpectQuery(`UPDATE login_attempts SET .* WHERE key = \$\d+ RETURNING \*`).
+		WithArgs(
+			sqlmock.AnyArg(), 0, 0, // last_failure, locked
+			0, 0, sqlmock.AnyArg(), 0.0, // lockout
+			2, sqlmock.AnyArg(), sqlmock.AnyArg(), 1.0, 2, 10.0, // backoff
+			key,
+		).
+		WillReturnRows(sqlmock.NewRows(loginAttemptColumns).
+			AddRow(1, key, 3, false, time.Now(), lockedUntil))
+	mock.ExpectCommit()
+
+	attempt, err := throttle.Fail(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if attempt.Locked || attempt.LockedUntil == nil || !attempt.LockedUntil.Equal(lockedUntil) {
+		t.Errorf("Expected the address to wait without being locked, got %+v", attempt)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+)
+
+var testOutboxPolicy = models.OutboxRetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+	Lease:       time.Minute,
+}
+
+// expectOutboxClaim expects the due events to be locked and leased in a
+// transaction of their own
+func expectOutboxClaim(mock sqlmock.Sqlmock, rows *sqlmock.Rows) {
+	mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "outbox_events" .* FOR UPDATE SKIP LOCKED`).WillReturnRows(rows)
+	mock.ExpectExec(`UPDATE "outbox_events" SET "available_at"=\$1 WHERE id IN`).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+}
+
+// expectOutboxOutcome expects the outcome of an event to be recorded on its
+// own, as long as the lease is still held
+func expectOutboxOutcome(mock sqlmock.Sqlmock, status string, attempts int, lastError any, id int, rowsAffected int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "outbox_events" SET .* WHERE \(status = \$6 AND available_at = \$7\) AND "id" = \$8`).
+		WithArgs(status, attempts, lastError, sqlmock.AnyArg(), sqlmock.AnyArg(), models.OutboxPending, sqlmock.AnyArg(), id).
+		WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+	mock.ExpectCommit()
+}
+
+func TestOutboxService_Dispatch(t *testing.T) {
+	service, mock := newMockService(t)
+	outbox := &models.OutboxService{Service: service}
+
+	columns := []string{"id", "type", "aggregate_type", "aggregate_id", "payload", "status", "attempts"}
+	expectOutboxClaim(mock, sqlmock.NewRows(columns).
+		AddRow(1, "wallet.created", "wallet", "3", []byte(`{}`), models.OutboxPending, 0).
+		AddRow(2, "wallet.updated", "wallet", "3", []byte(`{}`), models.OutboxPending, 0).
+		AddRow(3, "wallet.deleted", "wallet", "3", []byte(`{}`), models.OutboxPending, 2))
+	// Delivered
+	expectOutboxOutcome(mock, models.OutboxDelivered, 1, nil, 1, 1)
+	// Retried later
+	expectOutboxOutcome(mock, models.OutboxPending, 1, "sink down", 2, 1)
+	// Out of attempts
+	expectOutboxOutcome(mock, models.OutboxFailed, 3, "sink down", 3, 1)
+
+	var delivered []uint
+	deliver := func(ctx context.Context, event *models.OutboxEvent) error {
+		delivered = append(delivered, event.ID)
+		if event.ID == 1 {
+			return nil
+		}
+		return errors.New("sink down")
+	}
+
+	processed, err := outbox.Dispatch(context.Background(), 10, testOutboxPolicy, deliver)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if processed != 3 || len(delivered) != 3 {
+		t.Errorf("Expected 3 events processed, got %d (delivered %v)", processed, delivered)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestOutboxService_DispatchRecordsEachOutcome(t *testing.T) {
+	service, mock := newMockService(t)
+	outbox := &models.OutboxService{Service: service}
+
+	columns := []string{"id", "type", "aggregate_type", "aggregate_id", "payload", "status", "attempts"}
+	expectOutboxClaim(mock, sqlmock.NewRows(columns).
+		AddRow(1, "wallet.created", "wallet", "3", []byte(`{}`), models.OutboxPending, 0).
+		AddRow(2, "wallet.updated", "wallet", "3", []byte(`{}`), models.OutboxPending, 0).
+		AddRow(3, "wallet.deleted", "wallet", "3", []byte(`{}`), models.OutboxPending, 0))
+	// The failure to record the first outcome does not undo the others
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "outbox_events"`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	// Lease lost, another replica owns the event now
+	expectOutboxOutcome(mock, models.OutboxDelivered, 1, nil, 2, 0)
+	expectOutboxOutcome(mock, models.OutboxDelivered, 1, nil, 3, 1)
+
+	deliver := func(ctx context.Context, event *models.OutboxEvent) error { return nil }
+
+	processed, err := outbox.Dispatch(context.Background(), 10, testOutboxPolicy, deliver)
+	if err == nil {
+		t.Error("Expected the failure to record the first outcome")
+	}
+	if processed != 3 {
+		t.Errorf("Expected 3 events processed, got %d", processed)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestOutboxService_DispatchStopsWhenLeaseEnds(t *testing.T) {
+	service, mock := newMockService(t)
+	outbox := &models.OutboxService{Service: service}
+
+	columns := []string{"id", "type", "aggregate_type", "aggregate_id", "payload", "status", "attempts"}
+	expectOutboxClaim(mock, sqlmock.NewRows(columns).
+		AddRow(1, "wallet.created", "wallet", "3", []byte(`{}`), models.OutboxPending, 0).
+		AddRow(2, "wallet.updated", "wallet", "3", []byte(`{}`), models.OutboxPending, 0))
+	expectOutboxOutcome(mock, models.OutboxDelivered, 1, nil, 1, 1)
+
+	policy := testOutboxPolicy
+	policy.Lease = 50 * time.Millisecond
+	var delivered []uint
+	deliver := func(ctx context.Context, event *models.OutboxEvent) error {
+		delivered = append(delivered, event.ID)
+		time.Sleep(policy.Lease)
+		return nil
+	}
+
+	// The second event is left to whoever claims it after the lease
+	processed, err := outbox.Dispatch(context.Background(), 10, policy, deliver)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if processed != 1 || len(delivered) != 1 {
+		t.Errorf("Expected only the first event processed, got %d (delivered %v)", processed, delivered)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestOutboxService_DispatchIdle(t *testing.T) {
+	service, mock := newMockService(t)
+	outbox := &models.OutboxService{Service: service}
+
+	mock.ExpectQuery(`SELECT EXISTS`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	deliver := func(ctx context.Context, event *models.OutboxEvent) error {
+		t.Errorf("Expected nothing to be delivered, got event %d", event.ID)
+		return nil
+	}
+
+	processed, err := outbox.Dispatch(context.Background(), 10, testOutboxPolicy, deliver)
+	if err != nil || processed != 0 {
+		t.Errorf("Expected nothing processed, got %d (err: %v)", processed, err)
+	}
+
+	// No transaction is opened for an idle outbox
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestOutboxService_ReplayRequiresFailure(t *testing.T) {
+	service, mock := newMockService(t)
+	outbox := &models.OutboxService{Service: service}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "outbox_events" WHERE "outbox_events"."id" = \$1 .* FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(1, models.OutboxDelivered))
+	mock.ExpectRollback()
+
+	if _, err := outbox.Replay(context.Background(), 1); !errors.Is(err, models.ErrOutboxEventNotFailed) {
+		t.Errorf("Expected ErrOutboxEventNotFailed, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
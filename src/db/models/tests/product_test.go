@@ -1,6 +1,8 @@
 package models_test
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -60,7 +62,7 @@ func TestProductService_Create(t *testing.T) {
 		Service: mockService,
 	}
 
-	if err := productService.Create(product); err != nil {
+	if err := productService.Create(context.Background(), product); err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
 
@@ -69,6 +71,41 @@ func TestProductService_Create(t *testing.T) {
 	}
 }
 
+func TestProductService_CreateCancelled(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create SQL mock: %v", err)
+	}
+	defer sqlDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sqlDB,
+	}), &gorm.Config{
+		PrepareStmt: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create GORM DB from SQL mock: %v", err)
+	}
+
+	productService := &models.ProductService{
+		Service: config.InitMockService(gormDB),
+	}
+
+	// The request is gone before the transaction starts, nothing is sent
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	name := "Laptop"
+	err = productService.Create(ctx, &models.Product{Name: &name})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancellation of the context, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
 func TestProductService_Fetch(t *testing.T) {
 	sqlDB, mock, err := sqlmock.New()
 	if err != nil {
@@ -98,7 +135,7 @@ func TestProductService_Fetch(t *testing.T) {
 		Service: mockService,
 	}
 
-	product, err := productService.Fetch(productID)
+	product, err := productService.Fetch(context.Background(), productID)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
@@ -177,7 +214,7 @@ func TestProductService_Update(t *testing.T) {
 		Service: mockService,
 	}
 
-	if err := productService.Update(productID, updatedProduct); err != nil {
+	if err := productService.Update(context.Background(), productID, updatedProduct); err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
 
@@ -222,7 +259,7 @@ func TestProductService_Delete(t *testing.T) {
 		Service: mockService,
 	}
 
-	if err := productService.Delete(productID); err != nil {
+	if err := productService.Delete(context.Background(), productID); err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
 
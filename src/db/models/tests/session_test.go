@@ -0,0 +1,97 @@
+package models_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestSessionService_FetchBySID(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create SQL mock: %v", err)
+	}
+	defer sqlDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sqlDB,
+	}), &gorm.Config{
+		PrepareStmt: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create GORM DB from SQL mock: %v", err)
+	}
+
+	mockService := config.InitMockService(gormDB)
+
+	sid := "session-id"
+	now := time.Now()
+	mock.ExpectQuery(`SELECT \* FROM "sessions" WHERE sid = \$1 ORDER BY "sessions"\."id" LIMIT \$2`).
+		WithArgs(sid, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sid", "user_agent", "ip", "created_at", "last_seen_at", "expires_at", "revoked_at", "user_id"}).
+			AddRow(1, sid, "curl/8.0", "10.0.0.1", now, now, now.Add(time.Hour), now, 2))
+
+	sessionService := &models.SessionService{
+		Service: mockService,
+	}
+
+	session, err := sessionService.FetchBySID(context.Background(), sid)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if session.Active(now) {
+		t.Errorf("Expected revoked session not to be active")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestSessionService_Revoke(t *testing.T) {
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create SQL mock: %v", err)
+	}
+	defer sqlDB.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sqlDB,
+	}), &gorm.Config{
+		PrepareStmt: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create GORM DB from SQL mock: %v", err)
+	}
+
+	mockService := config.InitMockService(gormDB)
+
+	sessionID := uint(1)
+
+	mock.ExpectBegin()
+
+	mock.ExpectExec(`UPDATE "sessions" SET "revoked_at"=\$1 WHERE id = \$2 AND revoked_at IS NULL`).
+		WithArgs(sqlmock.AnyArg(), sessionID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	mock.ExpectCommit()
+
+	sessionService := &models.SessionService{
+		Service: mockService,
+	}
+
+	if err := sessionService.Revoke(context.Background(), sessionID); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
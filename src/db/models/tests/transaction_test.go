@@ -0,0 +1,183 @@
+package models_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func newMockService(t *testing.T) (*config.Service, sqlmock.Sqlmock) {
+	t.Helper()
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create SQL mock: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: sqlDB,
+	}), &gorm.Config{
+		PrepareStmt: false,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create GORM DB from SQL mock: %v", err)
+	}
+
+	return config.InitMockService(gormDB), mock
+}
+
+func debit(tx *gorm.DB) error {
+	return tx.Exec(`UPDATE wallets SET amount = amount - 1 WHERE id = 1`).Error
+}
+
+func TestDoTransaction_RetriesConflicts(t *testing.T) {
+	service, mock := newMockService(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE wallets`).WillReturnError(&pgconn.PgError{Code: "40001"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE wallets`).WillReturnError(&pgconn.PgError{Code: "40P01"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE wallets`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := models_utils.DoTransaction(context.Background(), service, models_utils.UPDATE, debit, models_utils.SERIALIZABLE)
+	if err != nil {
+		t.Errorf("Expected the third attempt to commit, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestDoTransaction_GivesUp(t *testing.T) {
+	service, mock := newMockService(t)
+
+	for i := 0; i < 2; i++ {
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE wallets`).WillReturnError(&pgconn.PgError{Code: "40001"})
+		mock.ExpectRollback()
+	}
+
+	err := models_utils.DoTransaction(context.Background(), service, models_utils.UPDATE, debit, models_utils.MaxRetries(1))
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "40001" {
+		t.Errorf("Expected the serialization failure, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestDoTransaction_DoesNotRetryOtherErrors(t *testing.T) {
+	service, mock := newMockService(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE wallets`).WillReturnError(&pgconn.PgError{Code: "23514"})
+	mock.ExpectRollback()
+
+	if err := models_utils.DoTransaction(context.Background(), service, models_utils.UPDATE, debit); err == nil {
+		t.Error("Expected the check violation")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestDoTransaction_CommitFails(t *testing.T) {
+	service, mock := newMockService(t)
+
+	var logs bytes.Buffer
+	previous := logging.Log
+	logging.Log = slog.New(slog.NewTextHandler(&logs, nil))
+	t.Cleanup(func() { logging.Log = previous })
+
+	commitErr := errors.New("connection reset")
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE wallets`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit().WillReturnError(commitErr)
+
+	err := models_utils.DoTransaction(context.Background(), service, models_utils.UPDATE, debit)
+	if !errors.Is(err, commitErr) {
+		t.Errorf("Expected the commit error, got %v", err)
+	}
+
+	if strings.Contains(logs.String(), "Transaction committed") {
+		t.Errorf("Expected no commit to be logged, got %q", logs.String())
+	}
+	if !strings.Contains(logs.String(), "Failed to commit transaction") {
+		t.Errorf("Expected the commit error to be logged, got %q", logs.String())
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestDoTransaction_NestedSavepoint(t *testing.T) {
+	service, mock := newMockService(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE wallets`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SAVEPOINT sp\d+`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO audit`).WillReturnError(errors.New("audit is down"))
+	mock.ExpectExec(`ROLLBACK TO SAVEPOINT sp\d+`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err := models_utils.DoTransaction(context.Background(), service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		if err := debit(tx); err != nil {
+			return err
+		}
+
+		// Only the savepoint is rolled back, the debit is kept
+		nested := models_utils.DoTransaction(tx.Statement.Context, service, models_utils.CREATE, func(tx *gorm.DB) error {
+			return tx.Exec(`INSERT INTO audit (event) VALUES ('debit')`).Error
+		})
+		if nested == nil {
+			t.Error("Expected the nested transaction to fail")
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected the outer transaction to commit, got %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("There were unmet SQL mock expectations: %v", err)
+	}
+}
+
+func TestDoTransaction_ReadsFromPrimary(t *testing.T) {
+	service, mock := newMockService(t)
+
+	mock.ExpectBegin()
+	mock.ExpectCommit()
+
+	err := models_utils.DoTransaction(context.Background(), service, models_utils.UPDATE, func(tx *gorm.DB) error {
+		if !config.UsesPrimary(tx.Statement.Context) {
+			t.Error("Expected the reads of the transaction to go to the primary")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package models_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
@@ -42,7 +43,7 @@ func TestUserService_Create(t *testing.T) {
 	mock.ExpectBegin()
 
 	mock.ExpectQuery(`INSERT INTO "users" .* RETURNING "id"`).
-		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
 		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
 
 	mock.ExpectCommit()
@@ -51,7 +52,7 @@ func TestUserService_Create(t *testing.T) {
 		Service: mockService,
 	}
 
-	if err := userService.Create(user); err != nil {
+	if err := userService.Create(context.Background(), user); err != nil {
 		t.Errorf("Expected no errors, got %v", err)
 	}
 
@@ -88,7 +89,7 @@ func TestUserService_Fetch(t *testing.T) {
 		Service: mockService,
 	}
 
-	user, err := userService.Fetch(userID)
+	user, err := userService.Fetch(context.Background(), userID)
 	if err != nil {
 		t.Errorf("Expected no errors, got: %v", err)
 	}
@@ -156,7 +157,7 @@ func TestUserService_Update(t *testing.T) {
         Service: mockService,
     }
 
-    if err := userService.Update(userID, updatedUser); err != nil {
+    if err := userService.Update(context.Background(), userID, updatedUser); err != nil {
         t.Errorf("Expected no errors, got: %v", err)
     }
 
@@ -197,7 +198,7 @@ func TestUserService_Delete(t *testing.T) {
         Service: mockService,
     }
     
-    if err := userService.Delete(userID); err != nil {
+    if err := userService.Delete(context.Background(), userID); err != nil {
         t.Error("Expected no errors, got: %w", err)
     }
 
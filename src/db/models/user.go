@@ -1,12 +1,14 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 
 	"github.com/alexbsec/MiniMarketplace/src/db/config"
 	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
 	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
 	"gorm.io/gorm"
 )
 
@@ -16,18 +18,23 @@ type User struct {
 	Email    *string `gorm:"unique" json:"email"`
 	Password *string `gorm:"not null" json:"password"`
     Role     *uint   `gorm:"not null" json:"role"`
+	// Language of the responses, e.g. pt-BR. Accept-Language is used when nil.
+	Language *string `gorm:"size:8" json:"language"`
 }
 
 type UserService struct {
 	Service *config.Service
 }
 
-func (us *UserService) Create(user *User) error {
+func (us *UserService) Create(ctx context.Context, user *User) error {
+	ctx, span := tracing.Start(ctx, "UserService.Create")
+	defer span.End()
+
 	if !us.isServiceRunning() {
 		return fmt.Errorf("Cannot proceed because service is offline")
 	}
 
-	return models_utils.DoTransaction(us.Service, models_utils.CREATE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, us.Service, models_utils.CREATE, func(tx *gorm.DB) error {
 		if err := tx.Create(user).Error; err != nil {
 			return fmt.Errorf("failed to create user: %w", err)
 		}
@@ -36,37 +43,43 @@ func (us *UserService) Create(user *User) error {
 	})
 }
 
-func (us *UserService) Fetch(id uint) (*User, error) {
+func (us *UserService) Fetch(ctx context.Context, id uint) (*User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.Fetch")
+	defer span.End()
+
 	if !us.isServiceRunning() {
 		return nil, fmt.Errorf("Cannot proceed because service is offline")
 	}
 
-	dbGorm, err := us.Service.Db()
+	dbGorm, err := us.Service.Reader(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	var user User
-	res := dbGorm.First(&user, id)
+	res := dbGorm.WithContext(ctx).First(&user, id)
 	if res.Error != nil {
 		if res.Error == gorm.ErrRecordNotFound {
-			logging.Log.Error("Usuário não encontrado", slog.String("error", res.Error.Error()))
+			logging.Log.ErrorContext(ctx, "User not found", slog.String("error", res.Error.Error()))
 			return nil, res.Error
 		}
 
-		logging.Log.Error("Error while searching for user", slog.String("error", res.Error.Error()))
+		logging.Log.ErrorContext(ctx, "Error while searching for user", slog.String("error", res.Error.Error()))
 		return nil, res.Error
 	}
 
 	return &user, nil
 }
 
-func (us *UserService) Update(id uint, newUser *User) error {
+func (us *UserService) Update(ctx context.Context, id uint, newUser *User) error {
+	ctx, span := tracing.Start(ctx, "UserService.Update")
+	defer span.End()
+
 	if !us.isServiceRunning() {
 		return fmt.Errorf("Cannot proceed because service is offline")
 	}
 
-	return models_utils.DoTransaction(us.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, us.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
 		var user User
 		if err := tx.First(&user, id).Error; err != nil {
 			return fmt.Errorf("user with id %d not found: %w", id, err)
@@ -80,12 +93,15 @@ func (us *UserService) Update(id uint, newUser *User) error {
 	})
 }
 
-func (us *UserService) Delete(id uint) error {
+func (us *UserService) Delete(ctx context.Context, id uint) error {
+	ctx, span := tracing.Start(ctx, "UserService.Delete")
+	defer span.End()
+
 	if !us.isServiceRunning() {
 		return fmt.Errorf("Cannot proceed because service is offline")
 	}
 
-	return models_utils.DoTransaction(us.Service, models_utils.DELETE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, us.Service, models_utils.DELETE, func(tx *gorm.DB) error {
 		if err := tx.Delete(&User{}, id).Error; err != nil {
 			return fmt.Errorf("failed to delete user: %w", err)
 		}
@@ -94,7 +110,12 @@ func (us *UserService) Delete(id uint) error {
 	})
 }
 
-func (us *UserService) FetchUserByEmail(email *string) (*User, error) {
+// FetchUserByEmail, FetchPassword, FetchEmail and CheckEmailExists check
+// credentials and uniqueness, they read from the primary
+func (us *UserService) FetchUserByEmail(ctx context.Context, email *string) (*User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.FetchUserByEmail")
+	defer span.End()
+
  	if !us.isServiceRunning() {
 		return nil, fmt.Errorf("Cannot proceed because service is offline")
 	}
@@ -105,21 +126,24 @@ func (us *UserService) FetchUserByEmail(email *string) (*User, error) {
 	}
 
     var user User
-    res := dbGorm.Where("email = ?", email).First(&user)
+    res := dbGorm.WithContext(ctx).Where("email = ?", email).First(&user)
 	if res.Error != nil {
 		if res.Error == gorm.ErrRecordNotFound {
-			logging.Log.Error("Usuário não encontrado", slog.String("error", res.Error.Error()))
+			logging.Log.ErrorContext(ctx, "User not found", slog.String("error", res.Error.Error()))
 			return nil, res.Error
 		}
 
-		logging.Log.Error("Error while searching for user", slog.String("error", res.Error.Error()))
+		logging.Log.ErrorContext(ctx, "Error while searching for user", slog.String("error", res.Error.Error()))
 		return nil, res.Error
 	}
 
     return &user, nil
 }
 
-func (us *UserService) FetchPassword(id uint) (*string, error) {
+func (us *UserService) FetchPassword(ctx context.Context, id uint) (*string, error) {
+	ctx, span := tracing.Start(ctx, "UserService.FetchPassword")
+	defer span.End()
+
 	if !us.isServiceRunning() {
 		return nil, fmt.Errorf("Cannot proceed because service is offline")
 	}
@@ -130,14 +154,14 @@ func (us *UserService) FetchPassword(id uint) (*string, error) {
 	}
 
 	var user User 
-	res := dbGorm.Select("password").First(&user, id)
+	res := dbGorm.WithContext(ctx).Select("password").First(&user, id)
 	if res.Error != nil {
 		if res.Error == gorm.ErrRecordNotFound {
-			logging.Log.Error("Usuário não encontrado", slog.String("error", res.Error.Error()))
+			logging.Log.ErrorContext(ctx, "User not found", slog.String("error", res.Error.Error()))
 			return nil, res.Error
 		}
 
-		logging.Log.Error("Error while searching for user", slog.String("error", res.Error.Error()))
+		logging.Log.ErrorContext(ctx, "Error while searching for user", slog.String("error", res.Error.Error()))
 		return nil, res.Error
 	}
 
@@ -148,7 +172,10 @@ func (us *UserService) FetchPassword(id uint) (*string, error) {
     return user.Password, nil
 }
 
-func (us *UserService) FetchEmail(id uint) (*string, error) {
+func (us *UserService) FetchEmail(ctx context.Context, id uint) (*string, error) {
+	ctx, span := tracing.Start(ctx, "UserService.FetchEmail")
+	defer span.End()
+
 	if !us.isServiceRunning() {
 		return nil, fmt.Errorf("Cannot proceed because service is offline")
 	}
@@ -159,14 +186,14 @@ func (us *UserService) FetchEmail(id uint) (*string, error) {
 	}
 
 	var user models_utils.MinifiedUser
-	res := dbGorm.Select("email").First(&user, id)
+	res := dbGorm.WithContext(ctx).Select("email").First(&user, id)
 	if res.Error != nil {
 		if res.Error == gorm.ErrRecordNotFound {
-			logging.Log.Error("Usuário não encontrado", slog.String("error", res.Error.Error()))
+			logging.Log.ErrorContext(ctx, "User not found", slog.String("error", res.Error.Error()))
 			return nil, res.Error
 		}
 
-		logging.Log.Error("Error while searching for user", slog.String("error", res.Error.Error()))
+		logging.Log.ErrorContext(ctx, "Error while searching for user", slog.String("error", res.Error.Error()))
 		return nil, res.Error
 	}
 
@@ -177,7 +204,10 @@ func (us *UserService) FetchEmail(id uint) (*string, error) {
     return user.Email, nil
 }
 
-func (us *UserService) CheckEmailExists(email *string) (bool, error) {
+func (us *UserService) CheckEmailExists(ctx context.Context, email *string) (bool, error) {
+	ctx, span := tracing.Start(ctx, "UserService.CheckEmailExists")
+	defer span.End()
+
 	if !us.isServiceRunning() {
 		return false, fmt.Errorf("Cannot proceed because service is offline")
 	}
@@ -188,7 +218,7 @@ func (us *UserService) CheckEmailExists(email *string) (bool, error) {
 	}
 
     var exists bool
-    err = dbGorm.Raw("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", email).
+    err = dbGorm.WithContext(ctx).Raw("SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", email).
             Scan(&exists).Error
 
     if err != nil {
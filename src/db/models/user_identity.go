@@ -0,0 +1,107 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a user to an account of an external identity provider
+type UserIdentity struct {
+	ID        uint      `gorm:"primaryKey"`
+	Issuer    string    `gorm:"not null;uniqueIndex:idx_user_identities_issuer_subject" json:"issuer"`
+	Subject   string    `gorm:"not null;uniqueIndex:idx_user_identities_issuer_subject" json:"subject"`
+	Email     *string   `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `gorm:"not null;index" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"-"`
+}
+
+type UserIdentityService struct {
+	Service *config.Service
+}
+
+func (is *UserIdentityService) Create(ctx context.Context, identity *UserIdentity) error {
+	ctx, span := tracing.Start(ctx, "UserIdentityService.Create")
+	defer span.End()
+
+	if !is.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	return models_utils.DoTransaction(ctx, is.Service, models_utils.CREATE, func(tx *gorm.DB) error {
+		if err := tx.Create(identity).Error; err != nil {
+			return fmt.Errorf("failed to create user identity: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// CreateWithUser creates a new user along with its first identity
+func (is *UserIdentityService) CreateWithUser(ctx context.Context, user *User, identity *UserIdentity) error {
+	ctx, span := tracing.Start(ctx, "UserIdentityService.CreateWithUser")
+	defer span.End()
+
+	if !is.isServiceRunning() {
+		return fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	return models_utils.DoTransaction(ctx, is.Service, models_utils.CREATE, func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
+		}
+
+		identity.UserID = user.ID
+		if err := tx.Create(identity).Error; err != nil {
+			return fmt.Errorf("failed to create user identity: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// FetchBySubject returns the identity of the issuer's subject, or nil if
+// it was never linked. It reads from the primary to see the identities
+// linked by a login just before.
+func (is *UserIdentityService) FetchBySubject(ctx context.Context, issuer string, subject string) (*UserIdentity, error) {
+	ctx, span := tracing.Start(ctx, "UserIdentityService.FetchBySubject")
+	defer span.End()
+
+	if !is.isServiceRunning() {
+		return nil, fmt.Errorf("Cannot proceed because service is offline")
+	}
+
+	dbGorm, err := is.Service.Db()
+	if err != nil {
+		return nil, err
+	}
+
+	var identity UserIdentity
+	res := dbGorm.WithContext(ctx).Where("issuer = ? AND subject = ?", issuer, subject).Limit(1).Find(&identity)
+	if res.Error != nil {
+		logging.Log.ErrorContext(ctx, "Error while searching for user identity", slog.String("error", res.Error.Error()))
+		return nil, res.Error
+	}
+
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	return &identity, nil
+}
+
+func (is *UserIdentityService) isServiceRunning() bool {
+	if is.Service == nil {
+		logging.Log.Error("User Identity Service is not initialized! Aborting")
+	}
+
+	return is.Service != nil
+}
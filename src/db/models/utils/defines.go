@@ -8,6 +8,39 @@ const (
 	DELETE TransactionEvent = "DELETE"
 )
 
+// IsolationLevel of a transaction, READ_COMMITTED unless given to
+// DoTransaction
+type IsolationLevel string
+
+const (
+	READ_COMMITTED  IsolationLevel = "READ COMMITTED"
+	REPEATABLE_READ IsolationLevel = "REPEATABLE READ"
+	SERIALIZABLE    IsolationLevel = "SERIALIZABLE"
+)
+
+// MaxRetries is how many times DoTransaction retries a transaction that
+// failed on a serialization failure or a deadlock
+type MaxRetries int
+
+// TransactionOption tunes a transaction, it is either an IsolationLevel or
+// MaxRetries, e.g. DoTransaction(ctx, service, UPDATE, fn, SERIALIZABLE)
+type TransactionOption interface {
+	apply(options *transactionOptions)
+}
+
+type transactionOptions struct {
+	isolation IsolationLevel
+	retries   int
+}
+
+func (level IsolationLevel) apply(options *transactionOptions) {
+	options.isolation = level
+}
+
+func (retries MaxRetries) apply(options *transactionOptions) {
+	options.retries = int(retries)
+}
+
 type MinifiedUser struct {
     Email    *string
     Password *string    
@@ -1,35 +1,207 @@
 package models_utils
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"log/slog"
+	"math/rand/v2"
+	"time"
 
 	"github.com/alexbsec/MiniMarketplace/src/db/config"
 	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/metrics"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
+// Retries of a transaction when no MaxRetries is given
+const defaultMaxRetries = 3
+
+// The wait before the nth retry is random, up to retryBaseDelay doubled n
+// times and at most retryMaxDelay, so the conflicting transactions don't
+// collide again
+const (
+	retryBaseDelay = 10 * time.Millisecond
+	retryMaxDelay  = time.Second
+)
+
+// SQLSTATE of the errors solved by running the transaction again
+const (
+	serializationFailure = "40001"
+	deadlockDetected     = "40P01"
+)
+
+var isolationLevels = map[IsolationLevel]sql.IsolationLevel{
+	READ_COMMITTED:  sql.LevelReadCommitted,
+	REPEATABLE_READ: sql.LevelRepeatableRead,
+	SERIALIZABLE:    sql.LevelSerializable,
+}
+
+// The ongoing transaction, in the context of its queries
+type txKey struct{}
+
+// DoTransaction runs txFunc in a transaction on the primary bound to ctx,
+// it is rolled back when ctx is cancelled or its deadline passes.
+//
+// A transaction failing on a serialization failure or a deadlock is run
+// again from the start, so txFunc must not have side effects outside of
+// tx. Called with the context of an ongoing transaction, the one of the
+// queries of tx, txFunc runs in a savepoint of that transaction instead:
+// the options are then ignored and the retries are left to the outermost
+// call.
 func DoTransaction(
+	ctx context.Context,
     service *config.Service,
 	event TransactionEvent,
-	txFunc func(*gorm.DB) error) error {
+	txFunc func(*gorm.DB) error,
+	options ...TransactionOption) error {
+	if outer, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return doSavepoint(ctx, outer, event, txFunc)
+	}
+
+	// Reads made with the context of the transaction see its writes
+	ctx = config.WithPrimary(ctx)
+
+	opts := transactionOptions{isolation: READ_COMMITTED, retries: defaultMaxRetries}
+	for _, option := range options {
+		option.apply(&opts)
+	}
+
 	dbGorm, err := service.Db()
 	if err != nil {
 		logging.Log.Error(
-			"Erro ao obter conexão com banco de dados",
+			"Failed to get a database connection",
 			slog.String("error", err.Error()),
 		)
 		return err
 	}
 
-	// Execute transaction dynamically
-	return dbGorm.Transaction(func(tx *gorm.DB) error {
-		logging.Log.Info("Iniciando transação", slog.String("event", string(event)))
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "db.transaction",
+		attribute.String("db.transaction.event", string(event)),
+		attribute.String("db.transaction.isolation", string(opts.isolation)))
+
+	attempt := 0
+	for {
+		err = runTransaction(ctx, dbGorm, event, opts.isolation, txFunc)
+
+		reason := retryReason(err)
+		if reason == "" || attempt >= opts.retries {
+			break
+		}
+		attempt++
+
+		wait := retryDelay(attempt)
+		logging.Log.WarnContext(ctx, "Transaction conflicted, retrying",
+			slog.String("event", string(event)),
+			slog.String("reason", reason),
+			slog.Int("attempt", attempt),
+			slog.Duration("wait", wait),
+		)
+		span.AddEvent("retry", trace.WithAttributes(
+			attribute.String("reason", reason),
+			attribute.Int("attempt", attempt),
+		))
+		metrics.TransactionRetry(string(event), reason)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			err = ctx.Err()
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	tracing.End(span, err)
+
+	outcome := metrics.OutcomeCommitted
+	if err != nil {
+		outcome = metrics.OutcomeRolledBack
+	}
+	metrics.ObserveTransaction(string(event), outcome, time.Since(start))
+
+	return err
+}
+
+// runTransaction makes a single attempt, the queries of tx are children
+// of the transaction span
+func runTransaction(
+	ctx context.Context,
+	dbGorm *gorm.DB,
+	event TransactionEvent,
+	isolation IsolationLevel,
+	txFunc func(*gorm.DB) error) error {
+	txOptions := &sql.TxOptions{Isolation: isolationLevels[isolation]}
+
+	// Set when txFunc succeeded, an error past it comes from the commit
+	committing := false
+	err := dbGorm.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Nested calls made with the context of tx become savepoints
+		tx = tx.WithContext(context.WithValue(ctx, txKey{}, tx))
+
+		logging.Log.InfoContext(ctx, "Starting transaction", slog.String("event", string(event)))
 		if err := txFunc(tx); err != nil {
-			logging.Log.Error("Erro durante a transação", slog.String("error", err.Error()))
+			logging.Log.ErrorContext(ctx, "Transaction failed", slog.String("error", err.Error()))
 			return err
 		}
 
-		logging.Log.Info("Transação concluída com sucesso", slog.String("event", string(event)))
+		committing = true
 		return nil
-	})
+	}, txOptions)
+
+	switch {
+	case err == nil:
+		logging.Log.InfoContext(ctx, "Transaction committed", slog.String("event", string(event)))
+	case committing:
+		logging.Log.ErrorContext(ctx, "Failed to commit transaction",
+			slog.String("event", string(event)),
+			slog.String("error", err.Error()),
+		)
+	}
+	return err
+}
+
+// doSavepoint runs txFunc in a savepoint of the outer transaction, only
+// the savepoint is rolled back when it fails
+func doSavepoint(
+	ctx context.Context,
+	outer *gorm.DB,
+	event TransactionEvent,
+	txFunc func(*gorm.DB) error) error {
+	ctx, span := tracing.Start(ctx, "db.savepoint",
+		attribute.String("db.transaction.event", string(event)))
+
+	err := outer.WithContext(ctx).Transaction(txFunc)
+	tracing.End(span, err)
+
+	return err
+}
+
+// retryReason tells why the transaction is worth running again, if it is
+func retryReason(err error) string {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return ""
+	}
+
+	switch pgErr.Code {
+	case serializationFailure:
+		return "serialization_failure"
+	case deadlockDetected:
+		return "deadlock"
+	}
+	return ""
+}
+
+func retryDelay(attempt int) time.Duration {
+	// Past 2^7 the base delay is over the maximum anyway
+	limit := min(retryBaseDelay<<min(attempt-1, 7), retryMaxDelay)
+	return rand.N(limit) + 1
 }
@@ -1,12 +1,14 @@
 package models
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 
 	"github.com/alexbsec/MiniMarketplace/src/db/config"
 	"github.com/alexbsec/MiniMarketplace/src/db/models/utils"
 	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
 	"gorm.io/gorm"
 )
 
@@ -19,55 +21,94 @@ type Wallet struct {
 	User   User     `gorm:"foreignKey:UserID"`
 }
 
+// Events of the wallets added to the outbox
+const (
+	WalletCreated = "wallet.created"
+	WalletUpdated = "wallet.updated"
+	WalletDeleted = "wallet.deleted"
+)
+
+// walletEvent is the payload of the wallet events, the state of the
+// wallet once the change is committed
+type walletEvent struct {
+	ID     uint     `json:"id"`
+	Name   *string  `json:"name,omitempty"`
+	Amount *float64 `json:"amount,omitempty"`
+	Points *float64 `json:"points,omitempty"`
+	UserID uint     `json:"user_id,omitempty"`
+}
+
+func newWalletEvent(wallet *Wallet) walletEvent {
+	return walletEvent{
+		ID:     wallet.ID,
+		Name:   wallet.Name,
+		Amount: wallet.Amount,
+		Points: wallet.Points,
+		UserID: wallet.UserID,
+	}
+}
+
+// WalletService writes in serializable transactions since wallets hold
+// money, they are retried when they conflict with a concurrent one. Every
+// change adds its event to the outbox in the same transaction.
 type WalletService struct {
 	Service *config.Service
 }
 
-func (ws *WalletService) Create(wallet *Wallet) error {
+func (ws *WalletService) Create(ctx context.Context, wallet *Wallet) error {
+	ctx, span := tracing.Start(ctx, "WalletService.Create")
+	defer span.End()
+
 	if !ws.isServiceRunning() {
 		return fmt.Errorf("Cannot proceed because service is offline")
 	}
 
-	return models_utils.DoTransaction(ws.Service, models_utils.CREATE, func(tx *gorm.DB) error {
+	return models_utils.DoTransaction(ctx, ws.Service, models_utils.CREATE, func(tx *gorm.DB) error {
 		if err := tx.Create(wallet).Error; err != nil {
 			return fmt.Errorf("failed to create wallet: %w", err)
 		}
 
-		return nil
-	})
+		return EnqueueEvent(tx, WalletCreated, "wallet", wallet.ID, newWalletEvent(wallet))
+	}, models_utils.SERIALIZABLE)
 }
 
-func (ws *WalletService) Fetch(id uint) (*Wallet, error) {
+func (ws *WalletService) Fetch(ctx context.Context, id uint) (*Wallet, error) {
+	ctx, span := tracing.Start(ctx, "WalletService.Fetch")
+	defer span.End()
+
 	if !ws.isServiceRunning() {
 		return nil, fmt.Errorf("Cannot proceed because service is offline")
 	}
 
-	dbGorm, err := ws.Service.Db()
+	dbGorm, err := ws.Service.Reader(ctx)
 	if err != nil {
 		return nil, err
 	}
 
 	var wallet Wallet
-	res := dbGorm.First(&wallet, id)
+	res := dbGorm.WithContext(ctx).First(&wallet, id)
 	if res.Error != nil {
 		if res.Error == gorm.ErrRecordNotFound {
-			logging.Log.Error("Carteira não encontrada", slog.String("error", res.Error.Error()))
+			logging.Log.ErrorContext(ctx, "Wallet not found", slog.String("error", res.Error.Error()))
 			return nil, res.Error
 		}
 
-		logging.Log.Error("Error while searching for wallet", slog.String("error", res.Error.Error()))
+		logging.Log.ErrorContext(ctx, "Error while searching for wallet", slog.String("error", res.Error.Error()))
 		return nil, res.Error
 	}
 
 	return &wallet, nil
 }
 
-func (ws *WalletService) Update(id uint, newWallet *Wallet) error {
+func (ws *WalletService) Update(ctx context.Context, id uint, newWallet *Wallet) error {
+	ctx, span := tracing.Start(ctx, "WalletService.Update")
+	defer span.End()
+
     if !ws.isServiceRunning() {
 		return fmt.Errorf("Cannot proceed because service is offline")
     }
 
-    return models_utils.DoTransaction(ws.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
+    return models_utils.DoTransaction(ctx, ws.Service, models_utils.UPDATE, func(tx *gorm.DB) error {
         var wallet Wallet
         if err := tx.First(&wallet, id).Error; err != nil {
             return fmt.Errorf("wallet with id %d not found: %w", id, err)
@@ -77,22 +118,34 @@ func (ws *WalletService) Update(id uint, newWallet *Wallet) error {
             return fmt.Errorf("failed to update wallet with id %d: %w", id, err)
         }
 
-        return nil
-    })
+        // Read back for the fields left out of newWallet
+        if err := tx.First(&wallet, id).Error; err != nil {
+            return fmt.Errorf("failed to read updated wallet with id %d: %w", id, err)
+        }
+
+        return EnqueueEvent(tx, WalletUpdated, "wallet", id, newWalletEvent(&wallet))
+    }, models_utils.SERIALIZABLE)
 }
 
-func (ws *WalletService) Delete(id uint) error {
+func (ws *WalletService) Delete(ctx context.Context, id uint) error {
+	ctx, span := tracing.Start(ctx, "WalletService.Delete")
+	defer span.End()
+
     if !ws.isServiceRunning() {
 		return fmt.Errorf("Cannot proceed because service is offline")
     }
 
-    return models_utils.DoTransaction(ws.Service, models_utils.DELETE, func(tx *gorm.DB) error {
-        if err := tx.Delete(&Wallet{}, id).Error; err != nil {
-            return fmt.Errorf("failed to delete wallet: %w", err)
+    return models_utils.DoTransaction(ctx, ws.Service, models_utils.DELETE, func(tx *gorm.DB) error {
+        res := tx.Delete(&Wallet{}, id)
+        if res.Error != nil {
+            return fmt.Errorf("failed to delete wallet: %w", res.Error)
+        }
+        if res.RowsAffected == 0 {
+            return nil
         }
 
-        return nil
-    })
+        return EnqueueEvent(tx, WalletDeleted, "wallet", id, walletEvent{ID: id})
+    }, models_utils.SERIALIZABLE)
 }
 
 func (ws *WalletService) isServiceRunning() bool {
@@ -0,0 +1,217 @@
+package i18n
+
+// catalog maps a key to its message in each supported language. Keys of
+// the field errors are prefixed with "field.".
+var catalog = map[string]map[string]string{
+	// Errors of the API, keyed by problem code
+	"invalid_payload": {
+		English:    "Invalid request payload",
+		Portuguese: "Corpo da requisição inválido",
+	},
+	"invalid_id": {
+		English:    "Invalid ID",
+		Portuguese: "ID inválido",
+	},
+	"invalid_path_parameter": {
+		English:    "Invalid path parameter: %s",
+		Portuguese: "Parâmetro de caminho inválido: %s",
+	},
+	"validation_failed": {
+		English:    "Some fields are invalid",
+		Portuguese: "Alguns campos são inválidos",
+	},
+	"unauthorized": {
+		English:    "Unauthorized",
+		Portuguese: "Não autorizado",
+	},
+	"forbidden": {
+		English:    "You are not allowed to do this",
+		Portuguese: "Você não tem permissão para fazer isso",
+	},
+	"insufficient_scope": {
+		English:    "API key is not allowed to %s %s",
+		Portuguese: "A chave de API não tem permissão para %s %s",
+	},
+	"session_required": {
+		English:    "API keys can't be used here, log in instead",
+		Portuguese: "Chaves de API não podem ser usadas aqui, faça login",
+	},
+	"internal_error": {
+		English:    "Something unexpected happened",
+		Portuguese: "Algo inesperado aconteceu",
+	},
+	"route_not_found": {
+		English:    "No route matches the path",
+		Portuguese: "Nenhuma rota corresponde ao caminho",
+	},
+	"method_not_allowed": {
+		English:    "The route does not accept the method",
+		Portuguese: "A rota não aceita o método",
+	},
+	"payload_too_large": {
+		English:    "Request payload is too large",
+		Portuguese: "Corpo da requisição muito grande",
+	},
+	"request_timeout": {
+		English:    "The request took too long, try again later",
+		Portuguese: "A requisição demorou demais, tente novamente mais tarde",
+	},
+	"product_not_found": {
+		English:    "Product does not exist",
+		Portuguese: "Produto não encontrado",
+	},
+	"user_not_found": {
+		English:    "User does not exist",
+		Portuguese: "Usuário não existe",
+	},
+	"wallet_not_found": {
+		English:    "Wallet does not exist",
+		Portuguese: "Carteira não encontrada",
+	},
+	"api_key_not_found": {
+		English:    "API key does not exist",
+		Portuguese: "Chave de API não encontrada",
+	},
+	"session_not_found": {
+		English:    "Session does not exist",
+		Portuguese: "Sessão não encontrada",
+	},
+	"outbox_event_not_found": {
+		English:    "Outbox event does not exist",
+		Portuguese: "Evento da outbox não encontrado",
+	},
+	"outbox_event_not_failed": {
+		English:    "Only failed outbox events can be replayed",
+		Portuguese: "Somente eventos da outbox com falha podem ser reenviados",
+	},
+	"invalid_credentials": {
+		English:    "Incorrect e-mail or password",
+		Portuguese: "Usuário ou senha incorretos",
+	},
+	"login_throttled": {
+		English:    "Too many failed login attempts, try again later",
+		Portuguese: "Muitas tentativas de login sem sucesso, tente novamente mais tarde",
+	},
+	"wrong_password": {
+		English:    "Old password does not match our records",
+		Portuguese: "A senha antiga não confere",
+	},
+	"email_taken": {
+		English:    "E-mail is already in use",
+		Portuguese: "Este e-mail já está em uso",
+	},
+	"external_login_disabled": {
+		English:    "External login is not enabled",
+		Portuguese: "Login externo não está habilitado",
+	},
+	"external_login_unavailable": {
+		English:    "External login is unavailable",
+		Portuguese: "Login externo indisponível",
+	},
+	"external_login_failed": {
+		English:    "External login failed",
+		Portuguese: "Falha no login externo",
+	},
+	"login_session_expired": {
+		English:    "Login session expired, please try again",
+		Portuguese: "Sessão de login expirada, tente novamente",
+	},
+	"verified_email_required": {
+		English:    "A verified e-mail is required to login",
+		Portuguese: "É necessário um e-mail verificado para entrar",
+	},
+
+	// Errors of a field of the request
+	"field.required": {
+		English:    "%s is required",
+		Portuguese: "%s é obrigatório",
+	},
+	"field.invalid": {
+		English:    "Invalid value",
+		Portuguese: "Valor inválido",
+	},
+	"field.invalid_type": {
+		English:    "Value has the wrong type",
+		Portuguese: "O valor tem o tipo errado",
+	},
+	"field.unknown_field": {
+		English:    "Unknown field",
+		Portuguese: "Campo desconhecido",
+	},
+	"field.too_short": {
+		English:    "Must have at least %s characters",
+		Portuguese: "Deve ter pelo menos %s caracteres",
+	},
+	"field.too_long": {
+		English:    "Must have at most %s characters",
+		Portuguese: "Deve ter no máximo %s caracteres",
+	},
+	"field.too_small": {
+		English:    "Must be at least %s",
+		Portuguese: "Deve ser no mínimo %s",
+	},
+	"field.too_large": {
+		English:    "Must be at most %s",
+		Portuguese: "Deve ser no máximo %s",
+	},
+	"field.invalid_email": {
+		English:    "Must be a valid e-mail address",
+		Portuguese: "Deve ser um endereço de e-mail válido",
+	},
+	"field.not_one_of": {
+		English:    "Must be one of: %s",
+		Portuguese: "Deve ser um destes: %s",
+	},
+	"field.invalid_ip": {
+		English:    "Must be a valid IP address",
+		Portuguese: "Deve ser um endereço IP válido",
+	},
+	"field.invalid_scope": {
+		English:    "Invalid scope: %s",
+		Portuguese: "Escopo inválido: %s",
+	},
+	"field.not_in_future": {
+		English:    "Must be in the future",
+		Portuguese: "Deve estar no futuro",
+	},
+	"field.unsupported_language": {
+		English:    "Unsupported language: %s",
+		Portuguese: "Idioma não suportado: %s",
+	},
+	"field.confirmation": {
+		English:    "Passwords do not match",
+		Portuguese: "As senhas não conferem",
+	},
+	"field.min_length": {
+		English:    "Password must have at least %d characters",
+		Portuguese: "A senha deve ter pelo menos %d caracteres",
+	},
+	"field.max_length": {
+		English:    "Password must have at most %d bytes",
+		Portuguese: "A senha deve ter no máximo %d bytes",
+	},
+	"field.uppercase": {
+		English:    "Password must contain an uppercase letter",
+		Portuguese: "A senha deve conter uma letra maiúscula",
+	},
+	"field.lowercase": {
+		English:    "Password must contain a lowercase letter",
+		Portuguese: "A senha deve conter uma letra minúscula",
+	},
+	"field.digit": {
+		English:    "Password must contain a digit",
+		Portuguese: "A senha deve conter um número",
+	},
+	"field.symbol": {
+		English:    "Password must contain a symbol",
+		Portuguese: "A senha deve conter um símbolo",
+	},
+	"field.not_email": {
+		English:    "Password must not be equal to the e-mail",
+		Portuguese: "A senha não pode ser igual ao e-mail",
+	},
+	"field.breached": {
+		English:    "Password appears in a list of breached passwords",
+		Portuguese: "A senha aparece em uma lista de senhas vazadas",
+	},
+}
@@ -0,0 +1,113 @@
+// Package i18n renders the messages sent to the users in their language.
+// Messages are looked up by key, the error codes of the API and the codes
+// of the field errors. Logs are not translated.
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	English    = "en"
+	Portuguese = "pt-BR"
+)
+
+// Default is used when the client accepts none of the supported languages
+const Default = English
+
+// Supported lists the languages of the catalog
+var Supported = []string{English, Portuguese}
+
+// Canonical returns the supported language of a BCP 47 tag, matching the
+// primary subtag when the region is not supported, e.g. pt-PT is served
+// in pt-BR and en-GB in en
+func Canonical(tag string) (string, bool) {
+	tag = strings.ReplaceAll(strings.TrimSpace(tag), "_", "-")
+	for _, lang := range Supported {
+		if strings.EqualFold(tag, lang) {
+			return lang, true
+		}
+	}
+
+	primary, _, _ := strings.Cut(tag, "-")
+	for _, lang := range Supported {
+		langPrimary, _, _ := strings.Cut(lang, "-")
+		if strings.EqualFold(primary, langPrimary) {
+			return lang, true
+		}
+	}
+
+	return "", false
+}
+
+// Negotiate picks the supported language the Accept-Language header
+// prefers, or Default
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		tag     string
+		quality float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		candidates = append(candidates, candidate{tag, quality})
+	}
+
+	// Stable so equal qualities keep the order of the header
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].quality > candidates[j].quality
+	})
+
+	for _, c := range candidates {
+		if lang, ok := Canonical(c.tag); ok {
+			return lang
+		}
+	}
+
+	return Default
+}
+
+// Has tells whether the catalog has a message for the key
+func Has(key string) bool {
+	_, ok := catalog[key]
+	return ok
+}
+
+// Message renders the message of the key in the language, falling back to
+// Default for a missing translation and to the key for a missing message
+func Message(lang string, key string, args ...any) string {
+	translations, ok := catalog[key]
+	if !ok {
+		return key
+	}
+
+	format, ok := translations[lang]
+	if !ok {
+		format = translations[Default]
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
@@ -0,0 +1,47 @@
+package i18n
+
+import "testing"
+
+func TestCatalogIsComplete(t *testing.T) {
+	for key, translations := range catalog {
+		for _, lang := range Supported {
+			if translations[lang] == "" {
+				t.Errorf("Missing %s translation of %q", lang, key)
+			}
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                              Default,
+		"pt-BR":                         Portuguese,
+		"pt-PT, en;q=0.5":               Portuguese,
+		"fr-FR, en-US;q=0.8, pt;q=0.5":  English,
+		"en;q=0.4, pt-br;q=0.9":         Portuguese,
+		"fr, de;q=0.5":                  Default,
+		"pt;q=0, en":                    English,
+		"*":                             Default,
+		"pt-BR;q=not-a-number, en;q=.1": English,
+	}
+
+	for header, want := range cases {
+		if got := Negotiate(header); got != want {
+			t.Errorf("%q: expected %q, got %q", header, want, got)
+		}
+	}
+}
+
+func TestMessage(t *testing.T) {
+	if got := Message(Portuguese, "field.required", "email"); got != "email é obrigatório" {
+		t.Errorf("Unexpected message %q", got)
+	}
+
+	// Unknown languages fall back to the default, unknown keys to the key
+	if got := Message("fr", "unauthorized"); got != "Unauthorized" {
+		t.Errorf("Unexpected message %q", got)
+	}
+	if got := Message(English, "no_such_key"); got != "no_such_key" {
+		t.Errorf("Unexpected message %q", got)
+	}
+}
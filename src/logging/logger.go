@@ -8,6 +8,8 @@ import (
 	"log/slog"
 	"strconv"
 	"sync"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Constants to make logger have color
@@ -69,6 +71,15 @@ func (h *Handler) Handle(ctx context.Context, record slog.Record) error {
 		level = colorize(red, level)
 	}
 
+	// Records logged within a span point to its trace
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		record = record.Clone()
+		record.AddAttrs(
+			slog.String("trace_id", spanContext.TraceID().String()),
+			slog.String("span_id", spanContext.SpanID().String()),
+		)
+	}
+
 	attrs, err := h.computeAttrs(ctx, record)
 	if err != nil {
 		return err
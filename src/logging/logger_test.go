@@ -2,6 +2,7 @@ package logging
 
 import (
 	"bytes"
+	"context"
 	"strconv"
 	//"fmt"
 	//	"context"
@@ -14,6 +15,8 @@ import (
 	"sync"
 	"testing"
 	// "time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Custom syncWriter for controlled output capture (used for capturing log prints)
@@ -177,3 +180,30 @@ func TestLoggerErrorHandling(t *testing.T) {
 	}
 	t.Log("Error handling test passed.")
 }
+
+// Test that records logged within a span carry its ids
+func TestLoggerTraceCorrelation(t *testing.T) {
+	handler := NewHandler(nil)
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: traceID,
+		SpanID:  spanID,
+	}))
+
+	output := captureOutput(func() {
+		log := slog.New(handler)
+		log.InfoContext(ctx, "Within a span")
+		log.Info("Outside of a span")
+	})
+
+	if !strings.Contains(output, `"trace_id": "4bf92f3577b34da6a3ce929d0e0e4736"`) {
+		t.Errorf("Expected 'trace_id' attribute not found")
+	}
+	if !strings.Contains(output, `"span_id": "00f067aa0ba902b7"`) {
+		t.Errorf("Expected 'span_id' attribute not found")
+	}
+	if strings.Count(output, "trace_id") != 1 {
+		t.Errorf("Expected only the record logged within the span to carry a trace id")
+	}
+}
@@ -1,12 +1,99 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/config"
 	"github.com/alexbsec/MiniMarketplace/src/core"
-	"github.com/alexbsec/MiniMarketplace/src/db/config"
+	dbconfig "github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/outbox"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
-    config.Connect()
-    app := app.App{}
-    app.Run(":7676")
+    command := run
+    if len(os.Args) > 1 && os.Args[1] == "migrate" {
+        command = func() error { return migrate(os.Args[2:]) }
+    }
+
+    if err := command(); err != nil {
+        logging.Log.Error("Application stopped with an error", slog.String("error", err.Error()))
+        os.Exit(1)
+    }
+}
+
+func run() (err error) {
+    cfg, err := config.Load(os.Args[1:])
+    if errors.Is(err, flag.ErrHelp) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    logging.Log.Info("Configuration loaded", slog.String("profile", cfg.Profile))
+
+    // Every resource is released as soon as it is acquired, in reverse
+    // order, so a failing step doesn't leak the previous ones. The spans of
+    // the last queries are flushed once the database is closed.
+    shutdownTracing, err := tracing.Setup(cfg.Tracing)
+    if err != nil {
+        return err
+    }
+    defer release(&err, cfg.HTTP.ShutdownTimeout, shutdownTracing)
+
+    dbService, err := dbconfig.InitService(cfg.Database)
+    if err != nil {
+        return err
+    }
+    defer release(&err, cfg.HTTP.ShutdownTimeout, func(ctx context.Context) error {
+        return dbService.Close()
+    })
+
+    application, err := app.New(cfg, dbService)
+    if err != nil {
+        return err
+    }
+
+    var mongoClient *mongo.Client
+    if cfg.Mongo.Enabled() {
+        mongoClient, err = dbconfig.Connect(cfg.Mongo.URI())
+        if err != nil {
+            return err
+        }
+        defer release(&err, cfg.HTTP.ShutdownTimeout, mongoClient.Disconnect)
+        application.AddProbe(app.MongoProbe(mongoClient))
+    }
+
+    if cfg.Outbox.Enabled {
+        sinks, err := outbox.NewSinks(cfg.Outbox, mongoClient)
+        if err != nil {
+            return err
+        }
+        // Stopped once the server drained, before the connections it uses
+        // are released
+        dispatcher := outbox.NewDispatcher(&models.OutboxService{Service: dbService}, sinks, cfg.Outbox)
+        application.OnShutdown(dispatcher.Start())
+    }
+
+    return application.Run()
+}
+
+// release runs a cleanup deferred by run within the shutdown timeout,
+// adding its error to the one run returns
+func release(err *error, timeout time.Duration, cleanup func(context.Context) error) {
+    ctx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+
+    if cleanupErr := cleanup(ctx); cleanupErr != nil {
+        logging.Log.Error("Failed to release resource on shutdown", slog.String("error", cleanupErr.Error()))
+        *err = errors.Join(*err, cleanupErr)
+    }
 }
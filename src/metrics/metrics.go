@@ -0,0 +1,170 @@
+// Package metrics holds the Prometheus collectors of the application,
+// served by core on /metrics
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "minimarketplace"
+
+// Registry is used instead of the global one so tests and the handler only
+// see the collectors of the application
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route pattern, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time spent handling HTTP requests, by route pattern, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	transactionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_transaction_duration_seconds",
+		Help:      "Time spent in database transactions, by event and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"event", "outcome"})
+
+	transactionRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "db_transaction_retries_total",
+		Help:      "Transactions run again after a conflict, by event and reason.",
+	}, []string{"event", "reason"})
+
+	logins = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Successful logins, by method.",
+	}, []string{"method"})
+
+	loginFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_failures_total",
+		Help:      "Refused logins, by reason.",
+	}, []string{"reason"})
+
+	walletVolume = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "wallet_volume_total",
+		Help:      "Amount credited to or debited from wallets.",
+	}, []string{"direction"})
+
+	outboxPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "outbox_publish_total",
+		Help:      "Outbox events sent to a sink, by sink and outcome.",
+	}, []string{"sink", "outcome"})
+)
+
+// Outcomes of a transaction
+const (
+	OutcomeCommitted  = "committed"
+	OutcomeRolledBack = "rolled_back"
+)
+
+// Outcomes of the publication of an outbox event
+const (
+	OutcomePublished = "published"
+	OutcomeFailed    = "failed"
+)
+
+// Login methods
+const (
+	LoginPassword = "password"
+	LoginOIDC     = "oidc"
+)
+
+// Login failure reasons
+const (
+	FailureBadCredentials = "bad_credentials"
+	FailureThrottled      = "throttled"
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		transactionDuration,
+		transactionRetries,
+		logins,
+		loginFailures,
+		walletVolume,
+		outboxPublished,
+	)
+}
+
+// Handler serves the metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// RegisterDB exports the connection pool stats of the database
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveRequest records a handled request. route is the pattern it
+// matched, never the raw path, to keep the number of series bounded.
+func ObserveRequest(route string, method string, status int, duration time.Duration) {
+	// The method of unmatched requests is whatever the client sent
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+	default:
+		method = "OTHER"
+	}
+
+	statusLabel := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, statusLabel).Inc()
+	httpDuration.WithLabelValues(route, method, statusLabel).Observe(duration.Seconds())
+}
+
+func ObserveTransaction(event string, outcome string, duration time.Duration) {
+	transactionDuration.WithLabelValues(event, outcome).Observe(duration.Seconds())
+}
+
+// TransactionRetry records a transaction run again after a serialization
+// failure or a deadlock
+func TransactionRetry(event string, reason string) {
+	transactionRetries.WithLabelValues(event, reason).Inc()
+}
+
+func Login(method string) {
+	logins.WithLabelValues(method).Inc()
+}
+
+func LoginFailure(reason string) {
+	loginFailures.WithLabelValues(reason).Inc()
+}
+
+// WalletMovement records the change of a wallet amount, credits when
+// positive and debits when negative
+func WalletMovement(delta float64) {
+	switch {
+	case delta > 0:
+		walletVolume.WithLabelValues("credit").Add(delta)
+	case delta < 0:
+		walletVolume.WithLabelValues("debit").Add(-delta)
+	}
+}
+
+func OutboxPublish(sink string, outcome string) {
+	outboxPublished.WithLabelValues(sink, outcome).Inc()
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/alexbsec/MiniMarketplace/src/config"
+	dbconfig "github.com/alexbsec/MiniMarketplace/src/db/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/migrations"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+)
+
+const migrateUsage = `usage: main migrate [flags] <command>
+
+commands:
+  up              apply every pending migration
+  status          list the migrations and whether they are applied
+  to <version>    apply the pending migrations up to version`
+
+// migrate runs the migrate subcommand, args are the ones after "migrate".
+// It takes the same flags, variables and file as the server for the
+// database connection.
+func migrate(args []string) error {
+	cfg, rest, err := config.LoadArgs(args)
+	if errors.Is(err, flag.ErrHelp) {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	var version string
+	switch rest[0] {
+	case "up", "status":
+		if len(rest) != 1 {
+			return errors.New(migrateUsage)
+		}
+	case "to":
+		if len(rest) != 2 {
+			return errors.New(migrateUsage)
+		}
+		version = rest[1]
+	default:
+		return fmt.Errorf("unknown migrate command %q\n%s", rest[0], migrateUsage)
+	}
+
+	// Migrations only go to the primary
+	cfg.Database.Replicas = nil
+	dbService, err := dbconfig.InitService(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer dbService.Close()
+
+	db, err := dbService.Db()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var applied []string
+	switch rest[0] {
+	case "status":
+		statuses, err := migrations.Statuses(ctx, db)
+		if err != nil {
+			return err
+		}
+		printStatuses(statuses)
+		return nil
+	case "up":
+		applied, err = migrations.Up(ctx, db)
+	case "to":
+		applied, err = migrations.To(ctx, db, version)
+	}
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 {
+		logging.Log.Info("No pending migrations")
+	} else {
+		logging.Log.Info("Migrations applied", slog.Any("versions", applied))
+	}
+	return nil
+}
+
+func printStatuses(statuses []migrations.Status) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tDESCRIPTION\tSTATUS\tEXECUTED AT\tERROR")
+	for _, status := range statuses {
+		state, executedAt := "pending", "-"
+		if status.Applied {
+			state = "applied"
+		} else if status.Error != "" {
+			state = "failed"
+		}
+		if status.ExecutedAt != nil {
+			executedAt = status.ExecutedAt.Format("2006-01-02 15:04:05 MST")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.Version, status.Description, state, executedAt, status.Error)
+	}
+	w.Flush()
+}
@@ -0,0 +1,182 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockIssuer is a minimal OpenID Connect provider issuing a token for a
+// single authorization code
+type mockIssuer struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	code      string
+	challenge string
+	nonce     string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+
+	issuer := &mockIssuer{key: key, code: "auth-code"}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test-key",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != issuer.code || S256Challenge(r.Form.Get("code_verifier")) != issuer.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		claims := jwt.MapClaims{
+			"iss":   issuer.server.URL,
+			"aud":   "marketplace",
+			"sub":   "user-123",
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"iat":   time.Now().Unix(),
+			"nonce": issuer.nonce,
+		}
+		for k, v := range issuer.claims {
+			claims[k] = v
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test-key"
+		signed, _ := token.SignedString(key)
+
+		json.NewEncoder(w).Encode(map[string]any{
+			"access_token": "access",
+			"token_type":   "Bearer",
+			"id_token":     signed,
+			"expires_in":   60,
+		})
+	})
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (m *mockIssuer) provider() *Provider {
+	return NewProvider(Config{
+		IssuerURL:   m.server.URL,
+		ClientID:    "marketplace",
+		RedirectURL: "http://localhost:7676/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	}, m.server.Client())
+}
+
+func TestProviderAuthorizationCodeFlow(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.claims = jwt.MapClaims{"email": "john@doe.com", "email_verified": true, "name": "John Doe"}
+	provider := issuer.provider()
+	ctx := context.Background()
+
+	verifier, _ := RandomString(32)
+	issuer.nonce = "nonce-1"
+
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", issuer.nonce, verifier)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	parsed, _ := url.Parse(authURL)
+	query := parsed.Query()
+	if !strings.HasPrefix(authURL, issuer.server.URL+"/authorize?") ||
+		query.Get("state") != "state-1" ||
+		query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("Unexpected authorization URL: %s", authURL)
+	}
+	issuer.challenge = query.Get("code_challenge")
+
+	tokens, err := provider.Exchange(ctx, issuer.code, verifier)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	claims, err := provider.VerifyIDToken(ctx, tokens.IDToken, issuer.nonce)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if claims.Subject != "user-123" || claims.Email != "john@doe.com" || !claims.EmailVerified || claims.Name != "John Doe" {
+		t.Errorf("Unexpected claims: %+v", claims)
+	}
+}
+
+func TestProviderRejectsWrongVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+	issuer.challenge = S256Challenge("the-right-verifier")
+
+	if _, err := provider.Exchange(context.Background(), issuer.code, "another-verifier"); err == nil {
+		t.Errorf("Expected exchange with a wrong verifier to fail")
+	}
+}
+
+func TestProviderRejectsWrongNonceAndAudience(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+	ctx := context.Background()
+
+	verifier := "verifier"
+	issuer.challenge = S256Challenge(verifier)
+	issuer.nonce = "nonce-1"
+
+	tokens, err := provider.Exchange(ctx, issuer.code, verifier)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, tokens.IDToken, "nonce-2"); err == nil {
+		t.Errorf("Expected token with another nonce to be rejected")
+	}
+
+	issuer.claims = jwt.MapClaims{"aud": "another-client"}
+	tokens, err = provider.Exchange(ctx, issuer.code, verifier)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, tokens.IDToken, issuer.nonce); err == nil {
+		t.Errorf("Expected token for another audience to be rejected")
+	}
+}
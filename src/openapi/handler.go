@@ -0,0 +1,26 @@
+package openapi
+
+import (
+	"bytes"
+	_ "embed"
+	"html/template"
+	"net/http"
+)
+
+//go:embed docs.html
+var docsPage string
+
+var docsTemplate = template.Must(template.New("docs").Parse(docsPage))
+
+// DocsHandler serves Swagger UI rendering the document at specURL. The
+// page is embedded in the binary, the scripts of Swagger UI are loaded
+// from its CDN.
+func DocsHandler(specURL string) http.Handler {
+	var page bytes.Buffer
+	docsTemplate.Execute(&page, struct{ SpecURL string }{specURL})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page.Bytes())
+	})
+}
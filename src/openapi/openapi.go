@@ -0,0 +1,311 @@
+// Package openapi builds the OpenAPI 3 document of the API from the routes
+// of the router and the operations the controllers document, so the
+// document follows the code
+package openapi
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alexbsec/MiniMarketplace/src/core/problem"
+)
+
+const Version = "3.0.3"
+
+// Access tells who may call an operation
+type Access int
+
+const (
+	Public Access = iota
+	// Authenticated with a token or an API key
+	User
+	// Authenticated as an admin
+	Admin
+)
+
+// Operation documents what a handler takes and answers. Bodies are given
+// as values of their Go type, the schemas are derived from the json and
+// validate tags.
+type Operation struct {
+	Summary string
+	Tag     string
+	Access  Access
+	// Body of the request, nil when there is none
+	Request any
+	// Status of the success, 200 by default
+	Status int
+	// Body of the success, nil when there is none
+	Response any
+	// Content type of the success body, JSON by default
+	ContentType string
+	// Query parameters
+	Query []Query
+	// The handler reads the id of the resource. Routes without {id} in the
+	// path take it as the deprecated ?id= query parameter.
+	ID bool
+	// Status of the errors, all answered with a problem
+	Errors []int
+}
+
+type Query struct {
+	Name        string
+	Description string
+	// Go value of the type of the parameter
+	Type     any
+	Required bool
+}
+
+// Route is a registered route, as the router describes it
+type Route struct {
+	Method string
+	// Path pattern of http.ServeMux, e.g. /api/v1/products/{id}
+	Pattern string
+	Params  []Param
+	// Name of the handler, the key of its operation
+	Name       string
+	Deprecated bool
+}
+
+type Param struct {
+	Name string
+	// string, int or uint
+	Type string
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// Document is the subset of OpenAPI the API needs
+type Document struct {
+	OpenAPI    string                          `json:"openapi"`
+	Info       Info                            `json:"info"`
+	Paths      map[string]map[string]*PathItem `json:"paths"`
+	Components Components                      `json:"components"`
+}
+
+// PathItem is an operation of a path, keyed by the method
+type PathItem struct {
+	OperationID string                `json:"operationId,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required"`
+	Deprecated  bool    `json:"deprecated,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+var security = []map[string][]string{
+	{"bearerAuth": {}},
+	{"apiKeyAuth": {}},
+}
+
+// Build documents every route with the operation of its handler. The
+// routes whose handler has no operation are left out and returned, so a
+// route registered without documentation can be caught.
+func Build(info Info, routes []Route, operations map[string]Operation) (*Document, []Route) {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   make(map[string]map[string]*PathItem),
+		Components: Components{
+			Schemas: make(map[string]*Schema),
+			SecuritySchemes: map[string]SecurityScheme{
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+				"apiKeyAuth": {Type: "apiKey", In: "header", Name: "X-API-Key"},
+			},
+		},
+	}
+	schemas := newSchemas(doc.Components.Schemas)
+	problemSchema := schemas.of(problem.Problem{})
+
+	var missing []Route
+	operationIDs := make(map[string]bool)
+	for _, route := range routes {
+		operation, ok := operations[route.Name]
+		if !ok {
+			missing = append(missing, route)
+			continue
+		}
+
+		item := &PathItem{
+			Summary:    operation.Summary,
+			Deprecated: route.Deprecated,
+			Responses:  make(map[string]Response),
+		}
+		// Handlers serving several routes are named after the first one,
+		// the current version is registered before the aliases
+		if !operationIDs[route.Name] {
+			operationIDs[route.Name] = true
+			item.OperationID = route.Name
+		}
+		if operation.Tag != "" {
+			item.Tags = []string{operation.Tag}
+		}
+		if operation.Access != Public {
+			item.Security = security
+		}
+
+		hasID := false
+		for _, param := range route.Params {
+			hasID = hasID || param.Name == "id"
+			item.Parameters = append(item.Parameters, Parameter{
+				Name:     param.Name,
+				In:       "path",
+				Required: true,
+				Schema:   paramSchema(param.Type),
+			})
+		}
+		if operation.ID && !hasID {
+			item.Parameters = append(item.Parameters, Parameter{
+				Name:        "id",
+				In:          "query",
+				Description: "Id of the resource, use the path of the current version instead",
+				Required:    true,
+				Deprecated:  true,
+				Schema:      paramSchema("uint"),
+			})
+		}
+		for _, query := range operation.Query {
+			item.Parameters = append(item.Parameters, Parameter{
+				Name:        query.Name,
+				In:          "query",
+				Description: query.Description,
+				Required:    query.Required,
+				Schema:      schemas.of(query.Type),
+			})
+		}
+
+		if operation.Request != nil {
+			item.RequestBody = &RequestBody{
+				Required: true,
+				Content:  map[string]MediaType{"application/json": {Schema: schemas.of(operation.Request)}},
+			}
+		}
+
+		status := operation.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := Response{Description: http.StatusText(status)}
+		if operation.Response != nil {
+			contentType := operation.ContentType
+			if contentType == "" {
+				contentType = "application/json"
+			}
+			success.Content = map[string]MediaType{contentType: {Schema: schemas.of(operation.Response)}}
+		}
+		item.Responses[strconv.Itoa(status)] = success
+
+		for _, status := range errorStatuses(operation, route) {
+			item.Responses[strconv.Itoa(status)] = Response{
+				Description: http.StatusText(status),
+				Content:     map[string]MediaType{problem.ContentType: {Schema: problemSchema}},
+			}
+		}
+		item.Responses["default"] = Response{
+			Description: "Unexpected error",
+			Content:     map[string]MediaType{problem.ContentType: {Schema: problemSchema}},
+		}
+
+		path := openAPIPath(route.Pattern)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*PathItem)
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = item
+	}
+
+	return doc, missing
+}
+
+// errorStatuses adds the errors every route of the kind may answer to the
+// ones of the operation
+func errorStatuses(operation Operation, route Route) []int {
+	set := make(map[int]bool)
+	for _, status := range operation.Errors {
+		set[status] = true
+	}
+	if operation.Access != Public {
+		set[http.StatusUnauthorized] = true
+	}
+	if operation.Request != nil {
+		set[http.StatusBadRequest] = true
+		set[http.StatusRequestEntityTooLarge] = true
+	}
+	for _, param := range route.Params {
+		if param.Type != "string" {
+			set[http.StatusBadRequest] = true
+		}
+	}
+
+	statuses := make([]int, 0, len(set))
+	for status := range set {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+	return statuses
+}
+
+// openAPIPath turns a pattern of http.ServeMux into an OpenAPI path:
+// {$} matches the end of the path and {name...} the rest of it
+func openAPIPath(pattern string) string {
+	path := strings.TrimSuffix(pattern, "{$}")
+	return strings.ReplaceAll(path, "...}", "}")
+}
+
+func paramSchema(typ string) *Schema {
+	switch typ {
+	case "uint":
+		return &Schema{Type: "integer", Minimum: float(0)}
+	case "int":
+		return &Schema{Type: "integer"}
+	}
+	return &Schema{Type: "string"}
+}
+
+// Has tells whether the document describes the route
+func (d *Document) Has(method string, pattern string) bool {
+	_, ok := d.Paths[openAPIPath(pattern)][strings.ToLower(method)]
+	return ok
+}
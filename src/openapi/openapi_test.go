@@ -0,0 +1,98 @@
+package openapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+type itemBody struct {
+	Name  *string  `json:"name" validate:"required,notblank,max=20"`
+	Email *string  `json:"email" validate:"omitnil,email"`
+	Price *float64 `json:"price" validate:"omitnil,gte=0"`
+	Kind  string   `json:"kind" validate:"oneof=small large"`
+	Tags  []string `json:"tags" validate:"dive,max=5"`
+}
+
+type itemOut struct {
+	ID        uint
+	CreatedAt time.Time `json:"created_at"`
+	Secret    string    `json:"-"`
+	hidden    string
+}
+
+func TestBuild(t *testing.T) {
+	routes := []Route{
+		{Method: http.MethodPut, Pattern: "/v1/items/{id}", Params: []Param{{Name: "id", Type: "uint"}}, Name: "update"},
+		{Method: http.MethodPut, Pattern: "/items", Name: "update", Deprecated: true},
+		{Method: http.MethodGet, Pattern: "/undocumented", Name: "other"},
+	}
+	operations := map[string]Operation{
+		"update": {Summary: "Update an item", Access: User, Request: itemBody{}, Response: itemOut{}, ID: true},
+	}
+
+	doc, missing := Build(Info{Title: "Test", Version: "1"}, routes, operations)
+	if len(missing) != 1 || missing[0].Pattern != "/undocumented" {
+		t.Errorf("Expected the undocumented route to be reported, got %+v", missing)
+	}
+	if doc.Has(http.MethodGet, "/undocumented") || !doc.Has(http.MethodPut, "/v1/items/{id}") {
+		t.Error("Expected only the documented routes in the document")
+	}
+
+	current := doc.Paths["/v1/items/{id}"]["put"]
+	if current.OperationID != "update" || current.Deprecated || len(current.Security) == 0 {
+		t.Errorf("Unexpected operation %+v", current)
+	}
+	if len(current.Parameters) != 1 || current.Parameters[0].In != "path" || current.Parameters[0].Schema.Type != "integer" {
+		t.Errorf("Expected the typed path parameter, got %+v", current.Parameters)
+	}
+	for _, status := range []string{"200", "400", "401", "413", "default"} {
+		if _, ok := current.Responses[status]; !ok {
+			t.Errorf("Expected a %s response, got %+v", status, current.Responses)
+		}
+	}
+
+	legacy := doc.Paths["/items"]["put"]
+	if legacy.OperationID != "" || !legacy.Deprecated {
+		t.Errorf("Expected the alias to be deprecated without an operation id, got %+v", legacy)
+	}
+	if len(legacy.Parameters) != 1 || legacy.Parameters[0].In != "query" || !legacy.Parameters[0].Deprecated {
+		t.Errorf("Expected the id in the query of the alias, got %+v", legacy.Parameters)
+	}
+}
+
+func TestSchemas(t *testing.T) {
+	components := make(map[string]*Schema)
+	ref := newSchemas(components).of(itemBody{})
+	if ref.Ref != "#/components/schemas/ItemBody" {
+		t.Fatalf("Expected a reference to the component, got %+v", ref)
+	}
+
+	body := components["ItemBody"]
+	if len(body.Required) != 1 || body.Required[0] != "name" {
+		t.Errorf("Expected only name to be required, got %v", body.Required)
+	}
+
+	name := body.Properties["name"]
+	if name.Type != "string" || name.Nullable || *name.MaxLength != 20 {
+		t.Errorf("Unexpected name schema %+v", name)
+	}
+	if email := body.Properties["email"]; email.Format != "email" || !email.Nullable {
+		t.Errorf("Unexpected email schema %+v", email)
+	}
+	if price := body.Properties["price"]; price.Type != "number" || *price.Minimum != 0 {
+		t.Errorf("Unexpected price schema %+v", price)
+	}
+	if kind := body.Properties["kind"]; len(kind.Enum) != 2 || kind.Enum[1] != "large" {
+		t.Errorf("Unexpected kind schema %+v", kind)
+	}
+	if tags := body.Properties["tags"]; tags.Type != "array" || tags.MaxLength != nil || tags.Items.MaxLength != nil {
+		t.Errorf("Expected the rules after dive to be left out, got %+v", tags)
+	}
+
+	newSchemas(components).of(itemOut{})
+	out := components["ItemOut"]
+	if len(out.Properties) != 2 || out.Properties["ID"] == nil || out.Properties["created_at"].Format != "date-time" {
+		t.Errorf("Expected the fields encoding/json encodes, got %+v", out.Properties)
+	}
+}
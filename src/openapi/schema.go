@@ -0,0 +1,223 @@
+package openapi
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schema is the subset of the JSON schema of OpenAPI the API needs
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemas derives the schemas of Go values, registering the structs as
+// components referenced by name
+type schemas struct {
+	components map[string]*Schema
+}
+
+func newSchemas(components map[string]*Schema) *schemas {
+	return &schemas{components: components}
+}
+
+func (s *schemas) of(value any) *Schema {
+	return s.ofType(reflect.TypeOf(value))
+}
+
+func (s *schemas) ofType(t reflect.Type) *Schema {
+	if t == nil {
+		return &Schema{}
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := s.ofType(t.Elem())
+		if schema.Ref == "" {
+			schema.Nullable = true
+		}
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Schema{Type: "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: float(0)}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: s.ofType(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: s.ofType(t.Elem())}
+	case reflect.Struct:
+		return s.ofStruct(t)
+	}
+
+	// Interfaces take any value
+	return &Schema{}
+}
+
+// ofStruct registers the struct as a component, named after its type
+func (s *schemas) ofStruct(t reflect.Type) *Schema {
+	name := componentName(t)
+	if name == "" {
+		return s.object(t)
+	}
+
+	ref := &Schema{Ref: "#/components/schemas/" + name}
+	if _, ok := s.components[name]; ok {
+		return ref
+	}
+
+	// Registered before the fields, for the types referencing themselves
+	s.components[name] = &Schema{}
+	*s.components[name] = *s.object(t)
+	return ref
+}
+
+func (s *schemas) object(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	s.addFields(schema, t)
+	return schema
+}
+
+// addFields adds the fields as encoding/json encodes them, embedded
+// structs included
+func (s *schemas) addFields(schema *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || (!field.IsExported() && !field.Anonymous) {
+			continue
+		}
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.addFields(schema, field.Type)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property := s.ofType(field.Type)
+		if applyRules(property, field.Tag.Get("validate")) {
+			schema.Required = append(schema.Required, name)
+		}
+		schema.Properties[name] = property
+	}
+}
+
+// applyRules documents the rules of a validate tag, returning whether the
+// field is required. Rules after dive apply to the items and are left out.
+func applyRules(schema *Schema, tag string) bool {
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		if name == "dive" {
+			break
+		}
+		// References need to be wrapped to take other keywords
+		if schema.Ref != "" {
+			required = required || name == "required"
+			continue
+		}
+
+		isString := schema.Type == "string"
+		switch name {
+		case "required":
+			required = true
+			schema.Nullable = false
+		case "min", "gte":
+			if isString {
+				schema.MinLength = integer(param)
+			} else {
+				schema.Minimum = number(param)
+			}
+		case "max", "lte":
+			if isString {
+				schema.MaxLength = integer(param)
+			} else {
+				schema.Maximum = number(param)
+			}
+		case "email":
+			schema.Format = "email"
+		case "ip":
+			schema.Format = "ip"
+		case "oneof":
+			schema.Enum = strings.Fields(param)
+		case "notblank":
+			schema.Description = joinDescription(schema.Description, "Must not be blank")
+		case "future":
+			schema.Description = joinDescription(schema.Description, "Must be in the future")
+		case "language":
+			schema.Description = joinDescription(schema.Description, "Supported language, e.g. en or pt-BR")
+		}
+	}
+
+	return required
+}
+
+// componentName names the component of a named struct type, capitalized
+// since the request types are unexported, e.g. ProductBody
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return ""
+	}
+
+	runes := []rune(name)
+	runes[0] = unicode.ToUpper(runes[0])
+	return string(runes)
+}
+
+func joinDescription(description string, sentence string) string {
+	if description == "" {
+		return sentence
+	}
+	return description + ". " + sentence
+}
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func number(param string) *float64 {
+	value, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
+
+func integer(param string) *int {
+	value, err := strconv.Atoi(param)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
@@ -0,0 +1,127 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"github.com/alexbsec/MiniMarketplace/src/metrics"
+	"github.com/alexbsec/MiniMarketplace/src/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Store hands the due events to deliver and records the outcomes, see
+// models.OutboxService
+type Store interface {
+	Dispatch(
+		ctx context.Context,
+		limit int,
+		policy models.OutboxRetryPolicy,
+		deliver func(context.Context, *models.OutboxEvent) error) (int, error)
+}
+
+// Dispatcher polls the outbox and publishes the due events to every sink.
+// An event is delivered once all the sinks accepted it, a sink failing
+// makes the whole event retried, so the others may see it again.
+type Dispatcher struct {
+	Store     Store
+	Sinks     []Sink
+	Policy    models.OutboxRetryPolicy
+	Interval  time.Duration
+	BatchSize int
+}
+
+func NewDispatcher(store Store, sinks []Sink, cfg config.OutboxConfig) *Dispatcher {
+	policy := models.DefaultOutboxRetryPolicy
+	policy.MaxAttempts = uint(cfg.MaxAttempts)
+
+	return &Dispatcher{
+		Store:     store,
+		Sinks:     sinks,
+		Policy:    policy,
+		Interval:  cfg.Interval,
+		BatchSize: cfg.BatchSize,
+	}
+}
+
+// Start runs the dispatcher in the background. The returned function
+// stops it, waiting for the batch in progress, and is meant for
+// App.OnShutdown.
+func (d *Dispatcher) Start() func(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+
+	return func(shutdownCtx context.Context) error {
+		cancel()
+		select {
+		case <-done:
+			return nil
+		case <-shutdownCtx.Done():
+			return fmt.Errorf("outbox dispatcher did not stop: %w", shutdownCtx.Err())
+		}
+	}
+}
+
+// Run polls until ctx is done. A full batch is followed by another one
+// right away, the dispatcher only waits once the outbox is drained.
+func (d *Dispatcher) Run(ctx context.Context) {
+	logging.Log.Info("Outbox dispatcher started", slog.Duration("interval", d.Interval), slog.Int("sinks", len(d.Sinks)))
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			logging.Log.Info("Outbox dispatcher stopped")
+			return
+		case <-timer.C:
+		}
+
+		processed, err := d.Store.Dispatch(ctx, d.BatchSize, d.Policy, d.deliver)
+		if err != nil && ctx.Err() == nil {
+			logging.Log.Error("Failed to dispatch outbox events", slog.String("error", err.Error()))
+		}
+
+		wait := d.Interval
+		if err == nil && processed >= d.BatchSize {
+			wait = 0
+		}
+		timer.Reset(wait)
+	}
+}
+
+// deliver publishes the event to every sink, even when one fails
+func (d *Dispatcher) deliver(ctx context.Context, event *models.OutboxEvent) error {
+	ctx, span := tracing.Start(ctx, "outbox.publish",
+		attribute.Int64("outbox.event_id", int64(event.ID)),
+		attribute.String("outbox.event_type", event.Type))
+
+	var errs []error
+	for _, sink := range d.Sinks {
+		if err := sink.Publish(ctx, event); err != nil {
+			metrics.OutboxPublish(sink.Name(), metrics.OutcomeFailed)
+			logging.Log.WarnContext(ctx, "Failed to publish outbox event",
+				slog.Uint64("event_id", uint64(event.ID)),
+				slog.String("sink", sink.Name()),
+				slog.Uint64("attempt", uint64(event.Attempts+1)),
+				slog.String("error", err.Error()),
+			)
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+			continue
+		}
+		metrics.OutboxPublish(sink.Name(), metrics.OutcomePublished)
+	}
+
+	err := errors.Join(errs...)
+	tracing.End(span, err)
+	return err
+}
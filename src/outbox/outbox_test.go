@@ -0,0 +1,126 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+)
+
+func testEvent() *models.OutboxEvent {
+	return &models.OutboxEvent{
+		ID:            7,
+		Type:          models.WalletCreated,
+		AggregateType: "wallet",
+		AggregateID:   "3",
+		Payload:       []byte(`{"id":3}`),
+	}
+}
+
+func TestWebhookSinkSignsEvents(t *testing.T) {
+	secret := []byte("webhook-secret")
+	var received Envelope
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(HeaderSignature) != "sha256="+Sign(secret, body) {
+			t.Errorf("Unexpected signature %q", r.Header.Get(HeaderSignature))
+		}
+		if r.Header.Get(HeaderEventID) != "7" || r.Header.Get(HeaderEventType) != models.WalletCreated {
+			t.Errorf("Unexpected event headers %v", r.Header)
+		}
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	sink := NewWebhookSink(server.URL, string(secret))
+	if err := sink.Publish(context.Background(), testEvent()); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if received.ID != 7 || string(received.Payload) != `{"id":3}` {
+		t.Errorf("Unexpected envelope %+v", received)
+	}
+}
+
+func TestWebhookSinkRejectedEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	if err := NewWebhookSink(server.URL, "").Publish(context.Background(), testEvent()); err == nil {
+		t.Error("Expected a non 2xx answer to fail the delivery")
+	}
+}
+
+type fakeSink struct {
+	err       error
+	published int
+}
+
+func (s *fakeSink) Name() string {
+	return "fake"
+}
+
+func (s *fakeSink) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	s.published++
+	return s.err
+}
+
+func TestDeliverPublishesToEverySink(t *testing.T) {
+	failing := &fakeSink{err: errors.New("down")}
+	working := &fakeSink{}
+	dispatcher := &Dispatcher{Sinks: []Sink{failing, working}}
+
+	err := dispatcher.deliver(context.Background(), testEvent())
+	if err == nil {
+		t.Error("Expected the failing sink to fail the delivery")
+	}
+	if failing.published != 1 || working.published != 1 {
+		t.Errorf("Expected every sink to be tried, got %d and %d", failing.published, working.published)
+	}
+}
+
+// fakeStore has a backlog of events, handed out a batch at a time
+type fakeStore struct {
+	backlog int
+	calls   chan int
+}
+
+func (s *fakeStore) Dispatch(
+	ctx context.Context,
+	limit int,
+	policy models.OutboxRetryPolicy,
+	deliver func(context.Context, *models.OutboxEvent) error) (int, error) {
+	processed := min(limit, s.backlog)
+	s.backlog -= processed
+	s.calls <- processed
+	return processed, nil
+}
+
+func TestDispatcherDrainsBacklog(t *testing.T) {
+	store := &fakeStore{backlog: 5, calls: make(chan int, 10)}
+	dispatcher := &Dispatcher{Store: store, Interval: time.Hour, BatchSize: 2}
+	stop := dispatcher.Start()
+
+	// Full batches are followed by another one without waiting the interval
+	for _, want := range []int{2, 2, 1} {
+		select {
+		case got := <-store.calls:
+			if got != want {
+				t.Errorf("Expected a batch of %d, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected the backlog to be drained without waiting")
+		}
+	}
+
+	if err := stop(context.Background()); err != nil {
+		t.Errorf("Expected the dispatcher to stop, got %v", err)
+	}
+}
@@ -0,0 +1,197 @@
+// Package outbox publishes the events of the outbox table to the sinks
+// configured, see Dispatcher
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/alexbsec/MiniMarketplace/src/config"
+	"github.com/alexbsec/MiniMarketplace/src/db/models"
+	"github.com/alexbsec/MiniMarketplace/src/logging"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Headers of the requests of the webhook sink
+const (
+	HeaderEventID   = "X-Event-ID"
+	HeaderEventType = "X-Event-Type"
+	// Hex HMAC-SHA256 of the body with the secret, prefixed by "sha256="
+	HeaderSignature = "X-Signature"
+)
+
+// Time given to the webhook receiver to answer
+const webhookTimeout = 10 * time.Second
+
+// Sink publishes the events somewhere. An event may be published more
+// than once, receivers deduplicate on its id.
+type Sink interface {
+	Name() string
+	Publish(ctx context.Context, event *models.OutboxEvent) error
+}
+
+// Envelope is the event as published to the sinks
+type Envelope struct {
+	ID            uint            `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   string          `json:"aggregate_id"`
+	Payload       json.RawMessage `json:"payload"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+func envelopeOf(event *models.OutboxEvent) Envelope {
+	return Envelope{
+		ID:            event.ID,
+		Type:          event.Type,
+		AggregateType: event.AggregateType,
+		AggregateID:   event.AggregateID,
+		Payload:       event.Payload,
+		CreatedAt:     event.CreatedAt,
+	}
+}
+
+// NewSinks builds the sinks of the configuration. mongoClient is only used
+// by the mongo sink and may be nil otherwise.
+func NewSinks(cfg config.OutboxConfig, mongoClient *mongo.Client) ([]Sink, error) {
+	sinks := make([]Sink, 0, len(cfg.Sinks))
+	for _, name := range cfg.Sinks {
+		switch name {
+		case config.SinkLog:
+			sinks = append(sinks, LogSink{})
+		case config.SinkWebhook:
+			sinks = append(sinks, NewWebhookSink(cfg.WebhookURL, cfg.WebhookSecret))
+		case config.SinkMongo:
+			if mongoClient == nil {
+				return nil, fmt.Errorf("the mongo sink requires a MongoDB connection")
+			}
+			collection := mongoClient.Database(cfg.MongoDatabase).Collection(cfg.MongoCollection)
+			sinks = append(sinks, MongoSink{Collection: collection})
+		default:
+			return nil, fmt.Errorf("unknown outbox sink %q", name)
+		}
+	}
+
+	return sinks, nil
+}
+
+// LogSink writes the events to the application log
+type LogSink struct{}
+
+func (LogSink) Name() string {
+	return config.SinkLog
+}
+
+func (LogSink) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	logging.Log.InfoContext(ctx, "Outbox event published",
+		slog.Uint64("event_id", uint64(event.ID)),
+		slog.String("type", event.Type),
+		slog.String("aggregate_type", event.AggregateType),
+		slog.String("aggregate_id", event.AggregateID),
+		slog.String("payload", string(event.Payload)),
+	)
+	return nil
+}
+
+// WebhookSink posts the events as JSON envelopes to a URL. Any status but
+// 2xx is a failed delivery.
+type WebhookSink struct {
+	URL    string
+	Secret []byte
+	Client *http.Client
+}
+
+func NewWebhookSink(url string, secret string) *WebhookSink {
+	return &WebhookSink{
+		URL:    url,
+		Secret: []byte(secret),
+		Client: &http.Client{Timeout: webhookTimeout},
+	}
+}
+
+func (ws *WebhookSink) Name() string {
+	return config.SinkWebhook
+}
+
+func (ws *WebhookSink) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	body, err := json.Marshal(envelopeOf(event))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ws.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEventID, strconv.FormatUint(uint64(event.ID), 10))
+	req.Header.Set(HeaderEventType, event.Type)
+	if len(ws.Secret) > 0 {
+		req.Header.Set(HeaderSignature, "sha256="+Sign(ws.Secret, body))
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	res, err := ws.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// Drained so the connection is reused
+	io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook answered %s", res.Status)
+	}
+
+	return nil
+}
+
+// Sign is the hex HMAC-SHA256 of body, receivers compare it to the
+// X-Signature header to authenticate the events
+func Sign(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// MongoSink stores the events in a collection, keyed by event id so a
+// publication made twice leaves a single document
+type MongoSink struct {
+	Collection *mongo.Collection
+}
+
+func (ms MongoSink) Name() string {
+	return config.SinkMongo
+}
+
+func (ms MongoSink) Publish(ctx context.Context, event *models.OutboxEvent) error {
+	var payload any
+	if err := json.Unmarshal(event.Payload, &payload); err != nil {
+		return fmt.Errorf("invalid payload: %w", err)
+	}
+
+	document := bson.M{
+		"_id":            event.ID,
+		"type":           event.Type,
+		"aggregate_type": event.AggregateType,
+		"aggregate_id":   event.AggregateID,
+		"payload":        payload,
+		"created_at":     event.CreatedAt,
+	}
+	_, err := ms.Collection.ReplaceOne(ctx, bson.M{"_id": event.ID}, document, options.Replace().SetUpsert(true))
+	return err
+}
@@ -18,6 +18,11 @@ func main() {
         &models.Product{},
         &models.User{},
         &models.Wallet{},
+        &models.LoginAttempt{},
+        &models.APIKey{},
+        &models.UserIdentity{},
+        &models.Session{},
+        &models.OutboxEvent{},
         )
 
     if err != nil {
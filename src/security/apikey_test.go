@@ -0,0 +1,72 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, hash, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !strings.HasPrefix(key, prefix+"_") {
+		t.Errorf("Expected key %q to start with prefix %q", key, prefix)
+	}
+
+	parsed, err := APIKeyPrefix(key)
+	if err != nil || parsed != prefix {
+		t.Errorf("Expected prefix %q, got %q (err: %v)", prefix, parsed, err)
+	}
+
+	if !VerifyAPIKey(key, hash) {
+		t.Errorf("Expected key to match its hash")
+	}
+
+	if VerifyAPIKey(key+"x", hash) {
+		t.Errorf("Expected altered key not to match the hash")
+	}
+
+	if len(prefix) != len("mmk_")+16 {
+		t.Errorf("Expected a prefix with 8 random bytes, got %q", prefix)
+	}
+}
+
+func TestAPIKeyPrefixAcceptsLegacyKeys(t *testing.T) {
+	prefix, err := APIKeyPrefix("mmk_1a2b3c4d_secret")
+	if err != nil || prefix != "mmk_1a2b3c4d" {
+		t.Errorf("Expected the prefix of a key with 4 random bytes, got %q (err: %v)", prefix, err)
+	}
+}
+
+func TestAPIKeyPrefixRejectsMalformedKeys(t *testing.T) {
+	for _, key := range []string{"", "mmk", "mmk_1234", "abc_1a2b3c4d_secret", "mmk_1a2b_secret", "mmk_1a2b3c4d5e_secret", "mmk_1a2b3c4d_"} {
+		if _, err := APIKeyPrefix(key); err == nil {
+			t.Errorf("Expected %q to be rejected", key)
+		}
+	}
+}
+
+func TestScopeAllows(t *testing.T) {
+	cases := []struct {
+		scopes   []string
+		resource string
+		action   string
+		allowed  bool
+	}{
+		{nil, "products", "write", true},
+		{[]string{"products:read"}, "products", "read", true},
+		{[]string{"products:read"}, "products", "write", false},
+		{[]string{"products:read"}, "wallets", "read", false},
+		{[]string{"wallets:*"}, "wallets", "write", true},
+		{[]string{"*:read"}, "users", "read", true},
+		{[]string{"*:read"}, "users", "write", false},
+	}
+
+	for _, c := range cases {
+		if got := ScopeAllows(c.scopes, c.resource, c.action); got != c.allowed {
+			t.Errorf("ScopeAllows(%v, %q, %q) = %v, expected %v", c.scopes, c.resource, c.action, got, c.allowed)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/alexbsec/MiniMarketplace/src/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Supported hashing algorithms. Stored hashes are told apart by their
+// prefix: "$2a$"/"$2b$" for bcrypt and "$argon2id$" for argon2id.
+const (
+	AlgorithmBcrypt   = "bcrypt"
+	AlgorithmArgon2id = "argon2id"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+type Argon2Params struct {
+	Memory     uint32 // in KiB
+	Iterations uint32
+	Threads    uint8
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+// PasswordHasher hashes new passwords with the configured algorithm and
+// verifies hashes of any supported algorithm
+type PasswordHasher struct {
+	Algorithm  string
+	BcryptCost int
+	Argon2     Argon2Params
+}
+
+var DefaultPasswordHasher = PasswordHasher{
+	Algorithm:  AlgorithmBcrypt,
+	BcryptCost: 12,
+	Argon2: Argon2Params{
+		Memory:     64 * 1024,
+		Iterations: 3,
+		Threads:    2,
+		SaltLength: 16,
+		KeyLength:  32,
+	},
+}
+
+// NewPasswordHasher builds the hasher of the configuration, the salt and
+// key lengths are the ones of DefaultPasswordHasher
+func NewPasswordHasher(cfg config.PasswordHashConfig) (PasswordHasher, error) {
+	hasher := DefaultPasswordHasher
+	hasher.Algorithm = cfg.Algorithm
+	hasher.BcryptCost = cfg.BcryptCost
+	hasher.Argon2.Memory = uint32(cfg.Argon2Memory)
+	hasher.Argon2.Iterations = uint32(cfg.Argon2Iterations)
+	hasher.Argon2.Threads = uint8(cfg.Argon2Threads)
+
+	return hasher, hasher.Validate()
+}
+
+func (h PasswordHasher) Validate() error {
+	switch h.Algorithm {
+	case AlgorithmBcrypt:
+		if h.BcryptCost < bcrypt.MinCost || h.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgorithmArgon2id:
+		if h.Argon2.Memory == 0 || h.Argon2.Iterations == 0 || h.Argon2.Threads == 0 {
+			return fmt.Errorf("argon2id memory, iterations and threads must be positive")
+		}
+		if h.Argon2.SaltLength == 0 || h.Argon2.KeyLength == 0 {
+			return fmt.Errorf("argon2id salt and key lengths must be positive")
+		}
+	default:
+		return fmt.Errorf("unsupported password hash algorithm %q", h.Algorithm)
+	}
+
+	return nil
+}
+
+// Hash hashes the password with the configured algorithm and parameters
+func (h PasswordHasher) Hash(password string) (string, error) {
+	switch h.Algorithm {
+	case AlgorithmBcrypt:
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), h.BcryptCost)
+		return string(bytes), err
+	case AlgorithmArgon2id:
+		return h.hashArgon2id(password)
+	default:
+		return "", fmt.Errorf("unsupported password hash algorithm %q", h.Algorithm)
+	}
+}
+
+// Verify checks the password against a hash of any supported algorithm.
+// needsRehash is true when the password matches but the hash was not made
+// with the configured algorithm and parameters.
+func (h PasswordHasher) Verify(password string, hash string) (match bool, needsRehash bool, err error) {
+	if strings.HasPrefix(hash, argon2idPrefix) {
+		params, salt, key, err := decodeArgon2id(hash)
+		if err != nil {
+			return false, false, err
+		}
+
+		computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false, nil
+		}
+
+		params.SaltLength = uint32(len(salt))
+		params.KeyLength = uint32(len(key))
+		return true, h.Algorithm != AlgorithmArgon2id || params != h.Argon2, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, err
+	}
+
+	return true, h.Algorithm != AlgorithmBcrypt || cost != h.BcryptCost, nil
+}
+
+func (h PasswordHasher) hashArgon2id(password string) (string, error) {
+	p := h.Argon2
+
+	salt := make([]byte, p.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Threads, p.KeyLength)
+
+	// PHC string format, the same produced by the reference implementation
+	return fmt.Sprintf(
+		"%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, p.Memory, p.Iterations, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func decodeArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var params Argon2Params
+
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id version: %w", err)
+	}
+	if version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Threads); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %w", err)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+
+	return params, salt, key, nil
+}
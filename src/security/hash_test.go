@@ -0,0 +1,93 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cheap parameters so the tests run fast
+func testHasher(algorithm string) PasswordHasher {
+	return PasswordHasher{
+		Algorithm:  algorithm,
+		BcryptCost: 4,
+		Argon2: Argon2Params{
+			Memory:     1024,
+			Iterations: 1,
+			Threads:    1,
+			SaltLength: 16,
+			KeyLength:  32,
+		},
+	}
+}
+
+func TestPasswordHasherRoundTrip(t *testing.T) {
+	prefixes := map[string]string{
+		AlgorithmBcrypt:   "$2a$",
+		AlgorithmArgon2id: "$argon2id$",
+	}
+
+	for algorithm, prefix := range prefixes {
+		hasher := testHasher(algorithm)
+
+		hash, err := hasher.Hash("Correct7Horse")
+		if err != nil {
+			t.Fatalf("%s: expected no error, got %v", algorithm, err)
+		}
+
+		if !strings.HasPrefix(hash, prefix) {
+			t.Errorf("%s: expected hash to start with %q, got %q", algorithm, prefix, hash)
+		}
+
+		match, needsRehash, err := hasher.Verify("Correct7Horse", hash)
+		if err != nil || !match || needsRehash {
+			t.Errorf("%s: expected match without rehash, got match=%v rehash=%v err=%v", algorithm, match, needsRehash, err)
+		}
+
+		match, _, err = hasher.Verify("Wrong7Horse", hash)
+		if err != nil || match {
+			t.Errorf("%s: expected mismatch, got match=%v err=%v", algorithm, match, err)
+		}
+	}
+}
+
+func TestPasswordHasherNeedsRehash(t *testing.T) {
+	bcryptHash, _ := testHasher(AlgorithmBcrypt).Hash("Correct7Horse")
+	argonHash, _ := testHasher(AlgorithmArgon2id).Hash("Correct7Horse")
+
+	costlier := testHasher(AlgorithmBcrypt)
+	costlier.BcryptCost = 5
+
+	stronger := testHasher(AlgorithmArgon2id)
+	stronger.Argon2.Iterations = 2
+
+	cases := []struct {
+		name   string
+		hasher PasswordHasher
+		hash   string
+	}{
+		{"bcrypt to argon2id", testHasher(AlgorithmArgon2id), bcryptHash},
+		{"argon2id to bcrypt", testHasher(AlgorithmBcrypt), argonHash},
+		{"bcrypt cost", costlier, bcryptHash},
+		{"argon2id iterations", stronger, argonHash},
+	}
+
+	for _, c := range cases {
+		match, needsRehash, err := c.hasher.Verify("Correct7Horse", c.hash)
+		if err != nil || !match || !needsRehash {
+			t.Errorf("%s: expected match with rehash, got match=%v rehash=%v err=%v", c.name, match, needsRehash, err)
+		}
+	}
+}
+
+func TestPasswordHasherValidate(t *testing.T) {
+	hasher := testHasher("md5")
+	if err := hasher.Validate(); err == nil {
+		t.Errorf("Expected unsupported algorithm to be rejected")
+	}
+
+	hasher = testHasher(AlgorithmBcrypt)
+	hasher.BcryptCost = 64
+	if err := hasher.Validate(); err == nil {
+		t.Errorf("Expected out of range bcrypt cost to be rejected")
+	}
+}
@@ -0,0 +1,215 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/alexbsec/MiniMarketplace/src/config"
+)
+
+// bcrypt ignores everything after the 72nd byte
+const BcryptMaxBytes = 72
+
+// Rules reported in a PasswordViolation
+const (
+	RuleConfirmation = "confirmation"
+	RuleMinLength    = "min_length"
+	RuleMaxLength    = "max_length"
+	RuleUpper        = "uppercase"
+	RuleLower        = "lowercase"
+	RuleDigit        = "digit"
+	RuleSymbol       = "symbol"
+	RuleNotEmail     = "not_email"
+	RuleBreached     = "breached"
+)
+
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+	// Length the rule requires, for the length rules
+	Limit int `json:"limit,omitempty"`
+}
+
+// PasswordPolicyError lists every rule a password failed to meet
+type PasswordPolicyError struct {
+	Violations []PasswordViolation `json:"violations"`
+}
+
+func (e *PasswordPolicyError) Error() string {
+	rules := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		rules[i] = v.Rule
+	}
+
+	return fmt.Sprintf("password violates policy: %s", strings.Join(rules, ", "))
+}
+
+type PasswordPolicy struct {
+	MinLength     int
+	MaxBytes      int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// Breached holds the known breached passwords, nil disables the check
+	Breached *BreachedPasswords
+}
+
+var DefaultPasswordPolicy = PasswordPolicy{
+	MinLength:    8,
+	MaxBytes:     BcryptMaxBytes,
+	RequireUpper: true,
+	RequireLower: true,
+	RequireDigit: true,
+}
+
+// NewPasswordPolicy builds the policy of the configuration, loading the
+// breached passwords when a file is set
+func NewPasswordPolicy(cfg config.PasswordConfig) (PasswordPolicy, error) {
+	policy := PasswordPolicy{
+		MinLength:     cfg.MinLength,
+		MaxBytes:      cfg.MaxBytes,
+		RequireUpper:  cfg.RequireUpper,
+		RequireLower:  cfg.RequireLower,
+		RequireDigit:  cfg.RequireDigit,
+		RequireSymbol: cfg.RequireSymbol,
+	}
+
+	if cfg.BreachedFile != "" {
+		breached, err := LoadBreachedPasswords(cfg.BreachedFile)
+		if err != nil {
+			return policy, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// Validate checks the password against every rule of the policy and returns
+// a *PasswordPolicyError listing all the violations, if any
+func (p PasswordPolicy) Validate(password string, confirmPassword string, email string) error {
+	var violations []PasswordViolation
+	violate := func(rule string, message string) {
+		violations = append(violations, PasswordViolation{Rule: rule, Message: message})
+	}
+
+	if password != confirmPassword {
+		violate(RuleConfirmation, "passwords do not match")
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, PasswordViolation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("password must have at least %d characters", p.MinLength),
+			Limit:   p.MinLength,
+		})
+	}
+
+	if p.MaxBytes > 0 && len(password) > p.MaxBytes {
+		violations = append(violations, PasswordViolation{
+			Rule:    RuleMaxLength,
+			Message: fmt.Sprintf("password must have at most %d bytes", p.MaxBytes),
+			Limit:   p.MaxBytes,
+		})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.RequireUpper && !hasUpper {
+		violate(RuleUpper, "password must contain an uppercase letter")
+	}
+	if p.RequireLower && !hasLower {
+		violate(RuleLower, "password must contain a lowercase letter")
+	}
+	if p.RequireDigit && !hasDigit {
+		violate(RuleDigit, "password must contain a digit")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violate(RuleSymbol, "password must contain a symbol")
+	}
+
+	if email != "" && strings.EqualFold(strings.TrimSpace(password), strings.TrimSpace(email)) {
+		violate(RuleNotEmail, "password must not be equal to the e-mail")
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violate(RuleBreached, "password appears in a list of breached passwords")
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+
+	return nil
+}
+
+// BreachedPasswords is a set of SHA-1 hashes of known breached passwords
+type BreachedPasswords struct {
+	hashes map[[sha1.Size]byte]struct{}
+}
+
+// LoadBreachedPasswords reads a file with one hex encoded SHA-1 hash per
+// line. The "HASH:COUNT" format of the Have I Been Pwned dumps is accepted,
+// as are empty lines and lines starting with '#'.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open breached passwords file: %w", err)
+	}
+	defer file.Close()
+
+	breached := &BreachedPasswords{hashes: make(map[[sha1.Size]byte]struct{})}
+
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hashHex, _, _ := strings.Cut(text, ":")
+		var hash [sha1.Size]byte
+		decoded, err := hex.DecodeString(hashHex)
+		if err != nil || len(decoded) != sha1.Size {
+			return nil, fmt.Errorf("invalid SHA-1 hash on line %d of %s", line, path)
+		}
+		copy(hash[:], decoded)
+		breached.hashes[hash] = struct{}{}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read breached passwords file: %w", err)
+	}
+
+	return breached, nil
+}
+
+func (b *BreachedPasswords) Contains(password string) bool {
+	_, ok := b.hashes[sha1.Sum([]byte(password))]
+	return ok
+}
+
+func (b *BreachedPasswords) Len() int {
+	return len(b.hashes)
+}
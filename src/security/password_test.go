@@ -0,0 +1,95 @@
+package security
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func violatedRules(t *testing.T, err error) []string {
+	t.Helper()
+
+	if err == nil {
+		return nil
+	}
+
+	var policyErr *PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		t.Fatalf("Expected a *PasswordPolicyError, got %T", err)
+	}
+
+	rules := make([]string, len(policyErr.Violations))
+	for i, v := range policyErr.Violations {
+		rules[i] = v.Rule
+	}
+	return rules
+}
+
+func TestPasswordPolicyAcceptsStrongPassword(t *testing.T) {
+	err := DefaultPasswordPolicy.Validate("Correct7Horse", "Correct7Horse", "john@doe.com")
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestPasswordPolicyListsEveryViolation(t *testing.T) {
+	err := DefaultPasswordPolicy.Validate("", "x", "john@doe.com")
+
+	rules := violatedRules(t, err)
+	expected := []string{RuleConfirmation, RuleMinLength, RuleUpper, RuleLower, RuleDigit}
+	if strings.Join(rules, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected rules %v, got %v", expected, rules)
+	}
+}
+
+func TestPasswordPolicyMaxLength(t *testing.T) {
+	password := "Aa1" + strings.Repeat("x", BcryptMaxBytes)
+
+	rules := violatedRules(t, DefaultPasswordPolicy.Validate(password, password, ""))
+	if len(rules) != 1 || rules[0] != RuleMaxLength {
+		t.Errorf("Expected only %q to be violated, got %v", RuleMaxLength, rules)
+	}
+}
+
+func TestPasswordPolicyNotEmail(t *testing.T) {
+	policy := DefaultPasswordPolicy
+	policy.RequireUpper = false
+	policy.RequireDigit = false
+
+	rules := violatedRules(t, policy.Validate("John@Doe.com", "John@Doe.com", "john@doe.com"))
+	if len(rules) != 1 || rules[0] != RuleNotEmail {
+		t.Errorf("Expected only %q to be violated, got %v", RuleNotEmail, rules)
+	}
+}
+
+func TestPasswordPolicyBreached(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// SHA-1 of "Password1", in the Have I Been Pwned format
+	content := "# known breached passwords\n70CCD9007338D6D81DD3B6271621B9CF9A97EA00:12345\n\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write breached passwords file: %v", err)
+	}
+
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if breached.Len() != 1 {
+		t.Fatalf("Expected 1 hash to be loaded, got %d", breached.Len())
+	}
+
+	policy := DefaultPasswordPolicy
+	policy.Breached = breached
+
+	rules := violatedRules(t, policy.Validate("Password1", "Password1", ""))
+	if len(rules) != 1 || rules[0] != RuleBreached {
+		t.Errorf("Expected only %q to be violated, got %v", RuleBreached, rules)
+	}
+
+	if err := policy.Validate("Password2", "Password2", ""); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alexbsec/MiniMarketplace/src/config"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetupFileExporter(t *testing.T) {
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	cfg := config.Defaults(config.ProfileTest).Tracing
+	cfg.Exporter = config.TracingFile
+	cfg.File = filepath.Join(t.TempDir(), "traces.json")
+
+	shutdown, err := Setup(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	_, span := Start(context.Background(), "offline-span")
+	span.End()
+
+	// Flushes the batch
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("Expected no error on shutdown, got %v", err)
+	}
+
+	content, err := os.ReadFile(cfg.File)
+	if err != nil {
+		t.Fatalf("Failed to read the traces: %v", err)
+	}
+	if !strings.Contains(string(content), `"Name":"offline-span"`) {
+		t.Errorf("Expected the span in the file, got %s", content)
+	}
+	if !strings.Contains(string(content), "minimarketplace") {
+		t.Errorf("Expected the service name in the file, got %s", content)
+	}
+}
+
+func TestSetupUnknownExporter(t *testing.T) {
+	cfg := config.Defaults(config.ProfileTest).Tracing
+	cfg.Exporter = "zipkin"
+
+	if _, err := Setup(cfg); err == nil {
+		t.Error("Expected an error for an unknown exporter")
+	}
+}